@@ -3,7 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
@@ -11,15 +13,141 @@ import (
 
 type Config struct {
 	ServerPort                 int           `validate:"required,min=1,max=65535"`
-	OpenAIAPIKey               string        `validate:"required"`
+	OpenAIAPIKey               string        `validate:"required_if=LLMProvider deepseek"`
 	LLMModel                   string        `validate:"required"`
 	LLMMaxTokens               int           `validate:"required,min=1,max=4096"`
 	LLMTemperature             float64       `validate:"required,min=0,max=2"`
-	RateLimitRequestsPerMinute int           `validate:"required,min=1,max=60"`
+	RateLimitRequestsPerMinute int           `validate:"required,min=1,max=6000"`
+	RateLimitBurst             int           `validate:"required,min=1"`
+	LLMMaxConcurrent           int           `validate:"required,min=1,max=256"`
+	LLMDeadlineRatio           float64       `validate:"min=0,max=10"`
 	AnalysisIntervalSeconds    time.Duration `validate:"required,min=1s,max=1m"`
 	StageMaxValue              int           `validate:"required,min=1"`
 	ClicksMaxValue             int           `validate:"required,min=1"`
 	HistoryWindowSize          int           `validate:"required,min=5,max=50"`
+	TimelineSize               int           `validate:"required,min=20,max=5000"`
+	EventQueueSize             int           `validate:"required,min=1"`
+
+	LeaderboardSnapshotInterval time.Duration `validate:"required,min=1s,max=5m"`
+	LeaderboardSnapshotSize     int           `validate:"required,min=10,max=1000"`
+	LeaderboardMaxEntries       int           `validate:"required,min=100"`
+	LeaderboardWindows          string
+	LeaderboardResetHour        int    `validate:"min=0,max=23"`
+	LeaderboardWeeklyResetDay   int    `validate:"min=0,max=6"`
+	LeaderboardTimezone         string `validate:"required"`
+	LeaderboardEvents           string
+	LeaderboardArchiveSize      int `validate:"required,min=1,max=365"`
+	LeaderboardArchiveTop       int `validate:"required,min=1,max=100"`
+
+	OfflineIncomePerTick   int           `validate:"min=0"`
+	OfflineTickInterval    time.Duration `validate:"required,min=1s"`
+	OfflineEfficiency      float64       `validate:"min=0,max=1"`
+	OfflineMaxDuration     time.Duration `validate:"required,min=1m,max=168h"`
+	OfflineMinAway         time.Duration `validate:"min=0"`
+	OfflineNarratorEnabled bool
+
+	LLMProvider                        string        `validate:"required,oneof=deepseek openai ollama heuristic scripted replay"`
+	HeuristicBreakIdle                 time.Duration `validate:"required,min=1s"`
+	HeuristicDisengagedIdle            time.Duration `validate:"required,gtfield=HeuristicBreakIdle"`
+	HeuristicObsessedClicksPerSecond   float64       `validate:"required,gt=0"`
+	HeuristicProductiveClicksPerSecond float64       `validate:"required,gt=0"`
+	HeuristicBreakMinStage             int           `validate:"min=0"`
+	HeuristicObsessedClicksPerStage    float64       `validate:"required,gt=0"`
+	HeuristicConfusedVariation         float64       `validate:"required,gt=0"`
+	HeuristicMinActions                int           `validate:"required,min=1"`
+	HeuristicPrefilter                 bool
+	HeuristicSkipConfidence            float64 `validate:"min=0,max=1"`
+	HeuristicOverrideConfidence        float64 `validate:"min=0,max=1"`
+
+	OpenAICompatBaseURL    string `validate:"required_if=LLMProvider openai"`
+	OpenAICompatModel      string
+	OpenAICompatAPIKey     string
+	OpenAICompatAuthHeader string `validate:"required"`
+	OpenAICompatAuthScheme string
+	OpenAICompatHeaders    string
+	OpenAICompatJSONMode   bool
+	OpenAICompatTimeout    time.Duration `validate:"required,min=1s,max=5m"`
+
+	LLMScriptPath   string
+	LLMRecordPath   string
+	LLMReplayPath   string `validate:"required_if=LLMProvider replay"`
+	LLMReplayStrict bool
+
+	LLMFallbacks       string
+	LLMBreakerFailures int           `validate:"min=1"`
+	LLMBreakerOpen     time.Duration `validate:"min=0"`
+	LLMLatencyBudget   time.Duration `validate:"min=0"`
+	LLMLatencyBudgets  string
+	LLMStreaming       bool
+
+	LLMCacheEnabled   bool
+	LLMCacheTTL       time.Duration `validate:"min=0"`
+	LLMCacheVariety   int           `validate:"required,min=1,max=20"`
+	LLMCacheMaxKeys   int           `validate:"required,min=1"`
+	LLMCacheStageBand int           `validate:"required,min=1"`
+
+	LLMPricing              string
+	LLMBudgetModels         string
+	LLMBudgetDaily          float64 `validate:"min=0"`
+	LLMBudgetMonthly        float64 `validate:"min=0"`
+	LLMBudgetStretchAt      float64 `validate:"min=0,lt=1"`
+	LLMBudgetEconomyAt      float64 `validate:"min=0,lt=1"`
+	LLMBudgetIntervalFactor float64 `validate:"required,min=1,max=10"`
+	LLMUsageCallsKept       int     `validate:"required,min=1,max=10000"`
+
+	OllamaBaseURL     string `validate:"required"`
+	OllamaModel       string `validate:"required"`
+	OllamaPull        bool
+	OllamaTimeout     time.Duration `validate:"required,min=1s,max=10m"`
+	OllamaPullTimeout time.Duration `validate:"required,min=1m"`
+
+	StateMinDwell      time.Duration `validate:"min=0"`
+	StateMinConfidence float64       `validate:"min=0,max=1"`
+	StateLLMConfidence float64       `validate:"min=0,max=1"`
+	StateHistorySize   int           `validate:"required,min=1,max=1000"`
+
+	IdleEnabled      bool
+	IdleMode         string        `validate:"required,oneof=analysis scripted"`
+	IdleFirst        time.Duration `validate:"required,min=5s"`
+	IdleBackoff      float64       `validate:"required,min=1"`
+	IdleMaxInterval  time.Duration `validate:"required,gtefield=IdleFirst"`
+	IdleMaxNudges    int           `validate:"min=0"`
+	IdleTickInterval time.Duration `validate:"required,min=1s,max=1m"`
+
+	NudgeEnabled     bool
+	NudgePolicyPath  string
+	NudgeDefaultMode string `validate:"required,oneof=instruct inject"`
+
+	PromptDir            string
+	PromptLocale         string        `validate:"required"`
+	PromptReloadInterval time.Duration `validate:"min=0"`
+	PromptHistorySize    int           `validate:"required,min=1"`
+
+	NarratorMemorySize          int     `validate:"min=0,max=50"`
+	NarratorNgramSize           int     `validate:"required,min=1,max=5"`
+	NarratorSimilarityThreshold float64 `validate:"min=0,max=1"`
+	NarratorRegenerateAttempts  int     `validate:"min=0,max=3"`
+
+	NarrativeEnabled      bool
+	NarrativeScriptPath   string
+	NarrativeTickInterval time.Duration `validate:"required,min=1s,max=1m"`
+	NarrativeMinGap       time.Duration `validate:"min=0"`
+
+	ExperimentPath string
+
+	PlayerTokenSecret string
+
+	AdminToken string
+
+	PrestigeEnabled            bool
+	PrestigeCapGrowth          float64 `validate:"min=0,max=10"`
+	PrestigeStagePerPoint      int     `validate:"required,min=1"`
+	PrestigeMultiplierPerPoint float64 `validate:"min=0,max=1"`
+
+	QuestEnabled      bool
+	QuestDailyCount   int           `validate:"min=0,max=10"`
+	QuestWeeklyCount  int           `validate:"min=0,max=10"`
+	QuestTickInterval time.Duration `validate:"required,min=1s,max=1m"`
 }
 
 var validate = validator.New()
@@ -27,24 +155,170 @@ var validate = validator.New()
 func Load() (*Config, error) {
 	cfg := &Config{
 		ServerPort:                 getEnvInt("SERVER_PORT", 8080),
-		OpenAIAPIKey:               getEnvString("DEEPSEEK_API_KEY", "sk-5882e9e6a74349b9b0598a5ad1814e6c"),
+		OpenAIAPIKey:               getEnvString("DEEPSEEK_API_KEY", ""),
 		LLMModel:                   getEnvString("LLM_MODEL", "deepseek-chat"),
 		LLMMaxTokens:               getEnvInt("LLM_MAX_TOKENS", 150),
 		LLMTemperature:             getEnvFloat("LLM_TEMPERATURE", 0.7),
 		RateLimitRequestsPerMinute: getEnvInt("RATE_LIMIT_REQUESTS_PER_MINUTE", 6),
+		RateLimitBurst:             getEnvInt("RATE_LIMIT_BURST", 3),
+		LLMMaxConcurrent:           getEnvInt("LLM_MAX_CONCURRENT", 4),
+		LLMDeadlineRatio:           getEnvFloat("LLM_DEADLINE_RATIO", 0.9),
 		AnalysisIntervalSeconds:    time.Duration(getEnvInt("ANALYSIS_INTERVAL_SECONDS", 17)) * time.Second,
 		StageMaxValue:              getEnvInt("STAGE_MAX_VALUE", 3000),
 		ClicksMaxValue:             getEnvInt("CLICKS_MAX_VALUE", 10000),
 		HistoryWindowSize:          getEnvInt("HISTORY_WINDOW_SIZE", 10),
+		TimelineSize:               getEnvInt("TIMELINE_SIZE", 200),
+		EventQueueSize:             getEnvInt("EVENT_QUEUE_SIZE", 1024),
+
+		LeaderboardSnapshotInterval: time.Duration(getEnvInt("LEADERBOARD_SNAPSHOT_INTERVAL_SECONDS", 5)) * time.Second,
+		LeaderboardSnapshotSize:     getEnvInt("LEADERBOARD_SNAPSHOT_SIZE", 100),
+		LeaderboardMaxEntries:       getEnvInt("LEADERBOARD_MAX_ENTRIES", 100000),
+		LeaderboardWindows:          getEnvString("LEADERBOARD_WINDOWS", "daily,weekly"),
+		LeaderboardResetHour:        getEnvInt("LEADERBOARD_RESET_HOUR", 0),
+		LeaderboardWeeklyResetDay:   getEnvInt("LEADERBOARD_WEEKLY_RESET_DAY", 1),
+		LeaderboardTimezone:         getEnvString("LEADERBOARD_TIMEZONE", "UTC"),
+		LeaderboardEvents:           getEnvString("LEADERBOARD_EVENTS", ""),
+		LeaderboardArchiveSize:      getEnvInt("LEADERBOARD_ARCHIVE_SIZE", 30),
+		LeaderboardArchiveTop:       getEnvInt("LEADERBOARD_ARCHIVE_TOP", 10),
+
+		OfflineIncomePerTick:   getEnvInt("OFFLINE_INCOME_PER_TICK", 25),
+		OfflineTickInterval:    time.Duration(getEnvInt("OFFLINE_TICK_SECONDS", 3)) * time.Second,
+		OfflineEfficiency:      getEnvFloat("OFFLINE_EFFICIENCY", 0.5),
+		OfflineMaxDuration:     time.Duration(getEnvInt("OFFLINE_MAX_HOURS", 8)) * time.Hour,
+		OfflineMinAway:         time.Duration(getEnvInt("OFFLINE_MIN_AWAY_SECONDS", 60)) * time.Second,
+		OfflineNarratorEnabled: getEnvBool("OFFLINE_NARRATOR_ENABLED", true),
+
+		LLMProvider:                        getEnvString("LLM_PROVIDER", "deepseek"),
+		HeuristicBreakIdle:                 time.Duration(getEnvInt("HEURISTIC_BREAK_IDLE_SECONDS", 20)) * time.Second,
+		HeuristicDisengagedIdle:            time.Duration(getEnvInt("HEURISTIC_DISENGAGED_IDLE_SECONDS", 90)) * time.Second,
+		HeuristicObsessedClicksPerSecond:   getEnvFloat("HEURISTIC_OBSESSED_CLICKS_PER_SECOND", 8),
+		HeuristicProductiveClicksPerSecond: getEnvFloat("HEURISTIC_PRODUCTIVE_CLICKS_PER_SECOND", 0.5),
+		HeuristicBreakMinStage:             getEnvInt("HEURISTIC_BREAK_MIN_STAGE", 100),
+		HeuristicObsessedClicksPerStage:    getEnvFloat("HEURISTIC_OBSESSED_CLICKS_PER_STAGE", 25),
+		HeuristicConfusedVariation:         getEnvFloat("HEURISTIC_CONFUSED_INTERVAL_VARIATION", 1.5),
+		HeuristicMinActions:                getEnvInt("HEURISTIC_MIN_ACTIONS", 3),
+		HeuristicPrefilter:                 getEnvBool("HEURISTIC_PREFILTER", true),
+		HeuristicSkipConfidence:            getEnvFloat("HEURISTIC_SKIP_CONFIDENCE", 0.75),
+		HeuristicOverrideConfidence:        getEnvFloat("HEURISTIC_OVERRIDE_CONFIDENCE", 0.85),
+
+		OpenAICompatBaseURL:    getEnvString("OPENAI_COMPAT_BASE_URL", "http://localhost:8000/v1"),
+		OpenAICompatModel:      getEnvString("OPENAI_COMPAT_MODEL", ""),
+		OpenAICompatAPIKey:     getEnvString("OPENAI_COMPAT_API_KEY", ""),
+		OpenAICompatAuthHeader: getEnvString("OPENAI_COMPAT_AUTH_HEADER", "Authorization"),
+		OpenAICompatAuthScheme: getEnvString("OPENAI_COMPAT_AUTH_SCHEME", "Bearer"),
+		OpenAICompatHeaders:    getEnvString("OPENAI_COMPAT_HEADERS", ""),
+		OpenAICompatJSONMode:   getEnvBool("OPENAI_COMPAT_JSON_MODE", true),
+		OpenAICompatTimeout:    time.Duration(getEnvInt("OPENAI_COMPAT_TIMEOUT_SECONDS", 30)) * time.Second,
+
+		LLMScriptPath:   getEnvString("LLM_SCRIPT_PATH", ""),
+		LLMRecordPath:   getEnvString("LLM_RECORD_PATH", ""),
+		LLMReplayPath:   getEnvString("LLM_REPLAY_PATH", ""),
+		LLMReplayStrict: getEnvBool("LLM_REPLAY_STRICT", false),
+
+		LLMFallbacks:       getEnvString("LLM_FALLBACKS", "heuristic"),
+		LLMBreakerFailures: getEnvInt("LLM_BREAKER_FAILURES", 3),
+		LLMBreakerOpen:     time.Duration(getEnvInt("LLM_BREAKER_OPEN_SECONDS", 30)) * time.Second,
+		LLMLatencyBudget:   time.Duration(getEnvInt("LLM_LATENCY_BUDGET_SECONDS", 20)) * time.Second,
+		LLMLatencyBudgets:  getEnvString("LLM_LATENCY_BUDGETS", ""),
+		LLMStreaming:       getEnvBool("LLM_STREAMING", false),
+
+		LLMCacheEnabled:   getEnvBool("LLM_CACHE_ENABLED", false),
+		LLMCacheTTL:       time.Duration(getEnvInt("LLM_CACHE_TTL_SECONDS", 600)) * time.Second,
+		LLMCacheVariety:   getEnvInt("LLM_CACHE_VARIETY", 3),
+		LLMCacheMaxKeys:   getEnvInt("LLM_CACHE_MAX_KEYS", 1000),
+		LLMCacheStageBand: getEnvInt("LLM_CACHE_STAGE_BAND", 50),
+
+		LLMPricing:              getEnvString("LLM_PRICING", ""),
+		LLMBudgetModels:         getEnvString("LLM_BUDGET_MODELS", "deepseek=deepseek-chat"),
+		LLMBudgetDaily:          getEnvFloat("LLM_BUDGET_DAILY_USD", 0),
+		LLMBudgetMonthly:        getEnvFloat("LLM_BUDGET_MONTHLY_USD", 0),
+		LLMBudgetStretchAt:      getEnvFloat("LLM_BUDGET_STRETCH_AT", 0.8),
+		LLMBudgetEconomyAt:      getEnvFloat("LLM_BUDGET_ECONOMY_AT", 0.9),
+		LLMBudgetIntervalFactor: getEnvFloat("LLM_BUDGET_INTERVAL_FACTOR", 2),
+		LLMUsageCallsKept:       getEnvInt("LLM_USAGE_CALLS_KEPT", 100),
+
+		OllamaBaseURL:     getEnvString("OLLAMA_BASE_URL", "http://localhost:11434"),
+		OllamaModel:       getEnvString("OLLAMA_MODEL", "qwen2.5:3b"),
+		OllamaPull:        getEnvBool("OLLAMA_PULL", true),
+		OllamaTimeout:     time.Duration(getEnvInt("OLLAMA_TIMEOUT_SECONDS", 60)) * time.Second,
+		OllamaPullTimeout: time.Duration(getEnvInt("OLLAMA_PULL_TIMEOUT_MINUTES", 30)) * time.Minute,
+
+		StateMinDwell:      time.Duration(getEnvInt("STATE_MIN_DWELL_SECONDS", 30)) * time.Second,
+		StateMinConfidence: getEnvFloat("STATE_MIN_CONFIDENCE", 0.6),
+		StateLLMConfidence: getEnvFloat("STATE_LLM_CONFIDENCE", 0.7),
+		StateHistorySize:   getEnvInt("STATE_HISTORY_SIZE", 20),
+
+		IdleEnabled:      getEnvBool("IDLE_ENABLED", true),
+		IdleMode:         getEnvString("IDLE_MODE", "analysis"),
+		IdleFirst:        time.Duration(getEnvInt("IDLE_FIRST_SECONDS", 30)) * time.Second,
+		IdleBackoff:      getEnvFloat("IDLE_BACKOFF", 2),
+		IdleMaxInterval:  time.Duration(getEnvInt("IDLE_MAX_INTERVAL_SECONDS", 300)) * time.Second,
+		IdleMaxNudges:    getEnvInt("IDLE_MAX_NUDGES", 4),
+		IdleTickInterval: time.Duration(getEnvInt("IDLE_TICK_SECONDS", 5)) * time.Second,
+
+		NudgeEnabled:     getEnvBool("NUDGE_ENABLED", true),
+		NudgePolicyPath:  getEnvString("NUDGE_POLICY_PATH", ""),
+		NudgeDefaultMode: getEnvString("NUDGE_DEFAULT_MODE", "instruct"),
+
+		PromptDir:            getEnvString("PROMPT_DIR", ""),
+		PromptLocale:         getEnvString("PROMPT_LOCALE", "zh-CN"),
+		PromptReloadInterval: time.Duration(getEnvInt("PROMPT_RELOAD_SECONDS", 5)) * time.Second,
+		PromptHistorySize:    getEnvInt("PROMPT_HISTORY_SIZE", 20),
+
+		NarratorMemorySize:          getEnvInt("NARRATOR_MEMORY_SIZE", 6),
+		NarratorNgramSize:           getEnvInt("NARRATOR_NGRAM_SIZE", 2),
+		NarratorSimilarityThreshold: getEnvFloat("NARRATOR_SIMILARITY_THRESHOLD", 0.6),
+		NarratorRegenerateAttempts:  getEnvInt("NARRATOR_REGENERATE_ATTEMPTS", 1),
+
+		NarrativeEnabled:      getEnvBool("NARRATIVE_ENABLED", true),
+		NarrativeScriptPath:   getEnvString("NARRATIVE_SCRIPT_PATH", ""),
+		NarrativeTickInterval: time.Duration(getEnvInt("NARRATIVE_TICK_SECONDS", 5)) * time.Second,
+		NarrativeMinGap:       time.Duration(getEnvInt("NARRATIVE_MIN_GAP_SECONDS", 10)) * time.Second,
+
+		ExperimentPath: getEnvString("EXPERIMENT_PATH", ""),
+
+		PlayerTokenSecret: getEnvString("PLAYER_TOKEN_SECRET", ""),
+
+		AdminToken: getEnvString("ADMIN_TOKEN", ""),
+
+		PrestigeEnabled:            getEnvBool("PRESTIGE_ENABLED", true),
+		PrestigeCapGrowth:          getEnvFloat("PRESTIGE_CAP_GROWTH", 0.5),
+		PrestigeStagePerPoint:      getEnvInt("PRESTIGE_STAGE_PER_POINT", 300),
+		PrestigeMultiplierPerPoint: getEnvFloat("PRESTIGE_MULTIPLIER_PER_POINT", 0.05),
+
+		QuestEnabled:      getEnvBool("QUEST_ENABLED", true),
+		QuestDailyCount:   getEnvInt("QUEST_DAILY_COUNT", 3),
+		QuestWeeklyCount:  getEnvInt("QUEST_WEEKLY_COUNT", 2),
+		QuestTickInterval: time.Duration(getEnvInt("QUEST_TICK_SECONDS", 5)) * time.Second,
 	}
 
 	if err := validate.Struct(cfg); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
 	}
+	if cfg.OpenAIAPIKey == "" && slices.Contains(splitList(cfg.LLMFallbacks), "deepseek") {
+		return nil, fmt.Errorf("config validation failed: DEEPSEEK_API_KEY is required when deepseek is in LLM_FALLBACKS")
+	}
+	// Zero disables a budget level, so the order only matters when both
+	// levels are on.
+	if cfg.LLMBudgetEconomyAt > 0 && cfg.LLMBudgetStretchAt > cfg.LLMBudgetEconomyAt {
+		return nil, fmt.Errorf("config validation failed: LLM_BUDGET_STRETCH_AT (%g) must not exceed LLM_BUDGET_ECONOMY_AT (%g)",
+			cfg.LLMBudgetStretchAt, cfg.LLMBudgetEconomyAt)
+	}
 
 	return cfg, nil
 }
 
+// splitList splits a comma separated setting into trimmed, non-empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func getEnvString(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -61,6 +335,15 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
+
 func getEnvFloat(key string, defaultValue float64) float64 {
 	if value := os.Getenv(key); value != "" {
 		if floatValue, err := strconv.ParseFloat(value, 64); err == nil {
@@ -36,7 +36,7 @@ func TestDeepSeekConnectivity(t *testing.T) {
 	// 获取API密钥
 	apiKey := os.Getenv("DEEPSEEK_API_KEY")
 	if apiKey == "" {
-		apiKey = "sk-5882e9e6a74349b9b0598a5ad1814e6c"
+		t.Skip("DEEPSEEK_API_KEY 未设置，跳过真实 API 连通性测试")
 	}
 
 	// 准备请求数据
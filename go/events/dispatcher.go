@@ -0,0 +1,136 @@
+package events
+
+import (
+	"log"
+	"runtime/debug"
+	"sync"
+)
+
+type Handler func(Event)
+
+type subscriber struct {
+	name    string
+	handler Handler
+	queue   chan Event // nil for synchronous subscribers
+}
+
+// Dispatcher fans domain events out to subscribers. Synchronous subscribers
+// run on the publishing goroutine before Publish returns; asynchronous ones
+// get their own goroutine and buffered queue, so a slow subscriber only
+// delays itself. A panicking subscriber is logged and never reaches the
+// publisher or other subscribers.
+type Dispatcher struct {
+	subscribers map[Kind][]*subscriber
+	queueSize   int
+	mu          sync.RWMutex
+	wg          sync.WaitGroup
+	stopped     bool
+}
+
+func NewDispatcher(queueSize int) *Dispatcher {
+	return &Dispatcher{
+		subscribers: make(map[Kind][]*subscriber),
+		queueSize:   queueSize,
+	}
+}
+
+// Subscribe registers a typed synchronous handler, e.g.
+//
+//	events.Subscribe(d, "leaderboard", func(e events.ActionApplied) { ... })
+func Subscribe[T Event](d *Dispatcher, name string, fn func(T)) {
+	var zero T
+	d.SubscribeKind(zero.Kind(), name, typed(fn))
+}
+
+// SubscribeAsync registers a typed handler that runs on its own goroutine.
+func SubscribeAsync[T Event](d *Dispatcher, name string, fn func(T)) {
+	var zero T
+	d.SubscribeKindAsync(zero.Kind(), name, typed(fn))
+}
+
+func typed[T Event](fn func(T)) Handler {
+	return func(e Event) {
+		if event, ok := e.(T); ok {
+			fn(event)
+		}
+	}
+}
+
+func (d *Dispatcher) SubscribeKind(kind Kind, name string, handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.subscribers[kind] = append(d.subscribers[kind], &subscriber{name: name, handler: handler})
+}
+
+func (d *Dispatcher) SubscribeKindAsync(kind Kind, name string, handler Handler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	sub := &subscriber{
+		name:    name,
+		handler: handler,
+		queue:   make(chan Event, d.queueSize),
+	}
+	d.subscribers[kind] = append(d.subscribers[kind], sub)
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for event := range sub.queue {
+			sub.invoke(event)
+		}
+	}()
+}
+
+func (d *Dispatcher) Publish(event Event) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.stopped {
+		return
+	}
+
+	for _, sub := range d.subscribers[event.Kind()] {
+		if sub.queue == nil {
+			sub.invoke(event)
+			continue
+		}
+
+		select {
+		case sub.queue <- event:
+		default:
+			log.Printf("Event queue full for subscriber %s, dropping %s", sub.name, event.Kind())
+		}
+	}
+}
+
+// Stop closes the asynchronous queues and waits for them to drain.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	if d.stopped {
+		d.mu.Unlock()
+		return
+	}
+	d.stopped = true
+	for _, subs := range d.subscribers {
+		for _, sub := range subs {
+			if sub.queue != nil {
+				close(sub.queue)
+			}
+		}
+	}
+	d.mu.Unlock()
+
+	d.wg.Wait()
+	log.Println("Event dispatcher stopped")
+}
+
+func (s *subscriber) invoke(event Event) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Event subscriber %s panicked on %s: %v\n%s", s.name, event.Kind(), r, debug.Stack())
+		}
+	}()
+	s.handler(event)
+}
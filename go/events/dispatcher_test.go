@@ -0,0 +1,68 @@
+package events
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatcherDeliversTypedEvents(t *testing.T) {
+	d := NewDispatcher(8)
+
+	var stages []int
+	Subscribe(d, "sync", func(e ActionApplied) {
+		stages = append(stages, e.Stage)
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	var purchased int
+	SubscribeAsync(d, "async", func(e PurchaseApplied) {
+		purchased = e.ItemID
+		wg.Done()
+	})
+
+	d.Publish(ActionApplied{Stage: 10})
+	d.Publish(ActionApplied{Stage: 20})
+	d.Publish(PurchaseApplied{ItemID: 4})
+
+	if len(stages) != 2 || stages[1] != 20 {
+		t.Fatalf("sync subscriber got %v", stages)
+	}
+
+	wg.Wait()
+	if purchased != 4 {
+		t.Fatalf("async subscriber got item %d", purchased)
+	}
+
+	d.Stop()
+	d.Publish(ActionApplied{Stage: 30})
+	if len(stages) != 2 {
+		t.Fatal("events published after Stop should be ignored")
+	}
+}
+
+func TestDispatcherIsolatesPanics(t *testing.T) {
+	d := NewDispatcher(8)
+	defer d.Stop()
+
+	Subscribe(d, "broken", func(e SessionEnded) {
+		panic("boom")
+	})
+
+	delivered := make(chan struct{}, 1)
+	SubscribeAsync(d, "broken-async", func(e SessionEnded) {
+		panic("boom")
+	})
+	Subscribe(d, "healthy", func(e SessionEnded) {
+		delivered <- struct{}{}
+	})
+
+	d.Publish(SessionEnded{SessionID: "s1"})
+
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("healthy subscriber did not receive the event")
+	}
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"time"
+)
+
+type Kind string
+
+const (
+	KindSessionCreated      Kind = "session_created"
+	KindActionApplied       Kind = "action_applied"
+	KindPurchaseApplied     Kind = "purchase_applied"
+	KindNarratorMessageSent Kind = "narrator_message_sent"
+	KindStateChanged        Kind = "state_changed"
+	KindSessionEnded        Kind = "session_ended"
+	KindPrestigeApplied     Kind = "prestige_applied"
+)
+
+// Event is implemented by every domain event published on the Dispatcher.
+// Events about a session carry its experiment variant.
+type Event interface {
+	Kind() Kind
+}
+
+type SessionCreated struct {
+	SessionID string    `json:"session_id"`
+	PlayerID  string    `json:"player_id"`
+	Variant   string    `json:"variant,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// ActionApplied is published after a user_action has been validated and
+// stored. Source is "client" for reports from the browser and "offline"
+// for progress credited on reconnect.
+type ActionApplied struct {
+	SessionID string    `json:"session_id"`
+	PlayerID  string    `json:"player_id"`
+	Stage     int       `json:"stage"`
+	Clicks    int       `json:"clicks"`
+	Source    string    `json:"source"`
+	Variant   string    `json:"variant,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+type PurchaseApplied struct {
+	SessionID string    `json:"session_id"`
+	PlayerID  string    `json:"player_id"`
+	ItemID    int       `json:"item_id"`
+	Category  string    `json:"category"`
+	Variant   string    `json:"variant,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// NarratorMessageSent is published for every narrator line delivered to a
+// client. Source tells where the line came from, e.g. "llm" or "offline".
+type NarratorMessageSent struct {
+	SessionID     string    `json:"session_id"`
+	PlayerID      string    `json:"player_id"`
+	State         string    `json:"state"`
+	Message       string    `json:"message"`
+	Source        string    `json:"source"`
+	Variant       string    `json:"variant,omitempty"`
+	PromptVersion string    `json:"prompt_version,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+type StateChanged struct {
+	SessionID string    `json:"session_id"`
+	PlayerID  string    `json:"player_id"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Variant   string    `json:"variant,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+type SessionEnded struct {
+	SessionID string        `json:"session_id"`
+	PlayerID  string        `json:"player_id"`
+	Stage     int           `json:"stage"`
+	Clicks    int           `json:"clicks"`
+	Duration  time.Duration `json:"duration"`
+	Variant   string        `json:"variant,omitempty"`
+	Time      time.Time     `json:"time"`
+}
+
+// PrestigeApplied is published when a player starts over. Stage is where
+// they prestiged; Count and Currency are their totals afterwards.
+type PrestigeApplied struct {
+	SessionID string    `json:"session_id"`
+	PlayerID  string    `json:"player_id"`
+	Stage     int       `json:"stage"`
+	Count     int       `json:"count"`
+	Earned    int       `json:"earned"`
+	Currency  int       `json:"currency"`
+	Variant   string    `json:"variant,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+func (SessionCreated) Kind() Kind      { return KindSessionCreated }
+func (ActionApplied) Kind() Kind       { return KindActionApplied }
+func (PurchaseApplied) Kind() Kind     { return KindPurchaseApplied }
+func (NarratorMessageSent) Kind() Kind { return KindNarratorMessageSent }
+func (StateChanged) Kind() Kind        { return KindStateChanged }
+func (SessionEnded) Kind() Kind        { return KindSessionEnded }
+func (PrestigeApplied) Kind() Kind     { return KindPrestigeApplied }
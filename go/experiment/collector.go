@@ -0,0 +1,195 @@
+package experiment
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/events"
+)
+
+// VariantReport aggregates the outcomes of every session in one variant,
+// live and ended.
+type VariantReport struct {
+	Variant             string  `json:"variant"`
+	Sessions            int     `json:"sessions"`
+	ActiveSessions      int     `json:"active_sessions"`
+	AvgSessionSeconds   float64 `json:"avg_session_seconds"`
+	Purchases           int     `json:"purchases"`
+	PurchasesPerSession float64 `json:"purchases_per_session"`
+	NarratorMessages    int     `json:"narrator_messages"`
+	StateChanges        int     `json:"state_changes"`
+	AvgMaxStage         float64 `json:"avg_max_stage"`
+	MaxStage            int     `json:"max_stage"`
+}
+
+type Report struct {
+	Experiment  string          `json:"experiment"`
+	Variants    []VariantReport `json:"variants"`
+	GeneratedAt time.Time       `json:"generated_at"`
+}
+
+type sessionStats struct {
+	variant          string
+	startedAt        time.Time
+	duration         time.Duration
+	maxStage         int
+	purchases        int
+	narratorMessages int
+	stateChanges     int
+}
+
+type totals struct {
+	sessions         int
+	duration         time.Duration
+	stageSum         int
+	maxStage         int
+	purchases        int
+	narratorMessages int
+	stateChanges     int
+}
+
+func (t *totals) add(s *sessionStats) {
+	t.sessions++
+	t.duration += s.duration
+	t.stageSum += s.maxStage
+	if s.maxStage > t.maxStage {
+		t.maxStage = s.maxStage
+	}
+	t.purchases += s.purchases
+	t.narratorMessages += s.narratorMessages
+	t.stateChanges += s.stateChanges
+}
+
+// Collector keeps per-variant outcome metrics from domain events. Ended
+// sessions are folded into running totals so memory only grows with the
+// number of live sessions.
+type Collector struct {
+	experiment *Experiment
+	live       map[string]*sessionStats
+	ended      map[string]*totals
+	mu         sync.Mutex
+}
+
+func NewCollector(exp *Experiment) *Collector {
+	return &Collector{
+		experiment: exp,
+		live:       make(map[string]*sessionStats),
+		ended:      make(map[string]*totals),
+	}
+}
+
+// Subscribe registers the collector's event handlers.
+func (c *Collector) Subscribe(d *events.Dispatcher) {
+	events.Subscribe(d, "experiment", c.onSessionCreated)
+	events.Subscribe(d, "experiment", c.onActionApplied)
+	events.Subscribe(d, "experiment", c.onPurchaseApplied)
+	events.Subscribe(d, "experiment", c.onNarratorMessageSent)
+	events.Subscribe(d, "experiment", c.onStateChanged)
+	events.Subscribe(d, "experiment", c.onSessionEnded)
+}
+
+func (c *Collector) onSessionCreated(e events.SessionCreated) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.live[e.SessionID] = &sessionStats{variant: e.Variant, startedAt: e.Time}
+}
+
+func (c *Collector) onActionApplied(e events.ActionApplied) {
+	c.update(e.SessionID, func(s *sessionStats) {
+		if e.Stage > s.maxStage {
+			s.maxStage = e.Stage
+		}
+	})
+}
+
+func (c *Collector) onPurchaseApplied(e events.PurchaseApplied) {
+	c.update(e.SessionID, func(s *sessionStats) { s.purchases++ })
+}
+
+func (c *Collector) onNarratorMessageSent(e events.NarratorMessageSent) {
+	c.update(e.SessionID, func(s *sessionStats) { s.narratorMessages++ })
+}
+
+func (c *Collector) onStateChanged(e events.StateChanged) {
+	c.update(e.SessionID, func(s *sessionStats) { s.stateChanges++ })
+}
+
+func (c *Collector) onSessionEnded(e events.SessionEnded) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats, exists := c.live[e.SessionID]
+	if !exists {
+		return
+	}
+	delete(c.live, e.SessionID)
+
+	stats.duration = e.Duration
+	if e.Stage > stats.maxStage {
+		stats.maxStage = e.Stage
+	}
+	c.totalsFor(stats.variant).add(stats)
+}
+
+func (c *Collector) update(sessionID string, fn func(*sessionStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stats, exists := c.live[sessionID]; exists {
+		fn(stats)
+	}
+}
+
+func (c *Collector) totalsFor(variant string) *totals {
+	t, exists := c.ended[variant]
+	if !exists {
+		t = &totals{}
+		c.ended[variant] = t
+	}
+	return t
+}
+
+// Report summarises every variant of the experiment, in definition order.
+func (c *Collector) Report(now time.Time) *Report {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	combined := make(map[string]*totals)
+	active := make(map[string]int)
+	for variant, t := range c.ended {
+		copied := *t
+		combined[variant] = &copied
+	}
+	for _, s := range c.live {
+		live := *s
+		live.duration = now.Sub(s.startedAt)
+		if combined[s.variant] == nil {
+			combined[s.variant] = &totals{}
+		}
+		combined[s.variant].add(&live)
+		active[s.variant]++
+	}
+
+	report := &Report{
+		Experiment:  c.experiment.Name,
+		Variants:    make([]VariantReport, 0, len(c.experiment.Variants)),
+		GeneratedAt: now,
+	}
+	for _, v := range c.experiment.Variants {
+		vr := VariantReport{Variant: v.Name, ActiveSessions: active[v.Name]}
+		if t := combined[v.Name]; t != nil && t.sessions > 0 {
+			n := float64(t.sessions)
+			vr.Sessions = t.sessions
+			vr.AvgSessionSeconds = t.duration.Seconds() / n
+			vr.Purchases = t.purchases
+			vr.PurchasesPerSession = float64(t.purchases) / n
+			vr.NarratorMessages = t.narratorMessages
+			vr.StateChanges = t.stateChanges
+			vr.AvgMaxStage = float64(t.stageSum) / n
+			vr.MaxStage = t.maxStage
+		}
+		report.Variants = append(report.Variants, vr)
+	}
+	return report
+}
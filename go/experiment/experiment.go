@@ -0,0 +1,128 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// ControlVariant is the only variant when no experiment is configured.
+const ControlVariant = "control"
+
+// Variant is one arm of an experiment. Empty fields keep the server
+// defaults, so a control arm only needs a name.
+type Variant struct {
+	Name                    string   `json:"name"`
+	Weight                  int      `json:"weight"`
+	SystemPrompt            string   `json:"system_prompt,omitempty"`
+	Temperature             *float64 `json:"temperature,omitempty"`
+	ResponsePool            string   `json:"response_pool,omitempty"`
+	AnalysisIntervalSeconds int      `json:"analysis_interval_seconds,omitempty"`
+}
+
+func (v *Variant) AnalysisInterval() time.Duration {
+	return time.Duration(v.AnalysisIntervalSeconds) * time.Second
+}
+
+type Experiment struct {
+	Name     string    `json:"name"`
+	Variants []Variant `json:"variants"`
+}
+
+// Default is the experiment used when none is configured: everyone is in
+// the control arm.
+func Default() *Experiment {
+	return &Experiment{
+		Name:     "default",
+		Variants: []Variant{{Name: ControlVariant, Weight: 1}},
+	}
+}
+
+// Load reads an experiment definition from path, or returns Default when
+// path is empty.
+func Load(path string) (*Experiment, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read experiment: %w", err)
+	}
+
+	var exp Experiment
+	if err := json.Unmarshal(data, &exp); err != nil {
+		return nil, fmt.Errorf("failed to parse experiment: %w", err)
+	}
+	if err := exp.Validate(); err != nil {
+		return nil, err
+	}
+	return &exp, nil
+}
+
+func (e *Experiment) Validate() error {
+	if e.Name == "" {
+		return fmt.Errorf("experiment has no name")
+	}
+	if len(e.Variants) == 0 {
+		return fmt.Errorf("experiment %s has no variants", e.Name)
+	}
+
+	responses := game.NewResponseSystem()
+	seen := make(map[string]bool)
+	total := 0
+	for i, v := range e.Variants {
+		if v.Name == "" {
+			return fmt.Errorf("variant %d has no name", i)
+		}
+		if seen[v.Name] {
+			return fmt.Errorf("duplicate variant %s", v.Name)
+		}
+		seen[v.Name] = true
+
+		if v.Weight < 0 {
+			return fmt.Errorf("variant %s has a negative weight", v.Name)
+		}
+		if v.Temperature != nil && (*v.Temperature < 0 || *v.Temperature > 2) {
+			return fmt.Errorf("variant %s temperature must be between 0 and 2", v.Name)
+		}
+		if !responses.HasPool(v.ResponsePool) {
+			return fmt.Errorf("variant %s uses unknown response pool %s", v.Name, v.ResponsePool)
+		}
+		if v.AnalysisIntervalSeconds < 0 {
+			return fmt.Errorf("variant %s has a negative analysis interval", v.Name)
+		}
+		total += v.Weight
+	}
+
+	if total == 0 {
+		return fmt.Errorf("experiment %s has no weighted variants", e.Name)
+	}
+	return nil
+}
+
+// Assign maps a key, normally the player ID, to a variant. The result only
+// depends on the experiment name, the key and the weights, so a player keeps
+// their variant across reconnects and server restarts.
+func (e *Experiment) Assign(key string) *Variant {
+	total := 0
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(e.Name + "/" + key))
+	bucket := int(h.Sum32() % uint32(total))
+
+	for i := range e.Variants {
+		bucket -= e.Variants[i].Weight
+		if bucket < 0 {
+			return &e.Variants[i]
+		}
+	}
+	return &e.Variants[len(e.Variants)-1]
+}
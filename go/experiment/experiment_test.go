@@ -0,0 +1,83 @@
+package experiment
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/events"
+)
+
+func TestAssignIsDeterministicAndFollowsWeights(t *testing.T) {
+	exp := &Experiment{
+		Name: "tone",
+		Variants: []Variant{
+			{Name: "control", Weight: 3},
+			{Name: "gentle", Weight: 1, ResponsePool: "companion"},
+			{Name: "off", Weight: 0},
+		},
+	}
+	if err := exp.Validate(); err != nil {
+		t.Fatalf("unexpected validation error: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 4000; i++ {
+		key := fmt.Sprintf("player-%d", i)
+		v := exp.Assign(key)
+		if again := exp.Assign(key); again.Name != v.Name {
+			t.Fatalf("player %s moved from %s to %s", key, v.Name, again.Name)
+		}
+		counts[v.Name]++
+	}
+
+	if counts["off"] != 0 {
+		t.Fatalf("zero-weight variant got %d players", counts["off"])
+	}
+	if share := float64(counts["gentle"]) / 4000; share < 0.2 || share > 0.3 {
+		t.Fatalf("gentle share %.2f, want about 0.25", share)
+	}
+}
+
+func TestValidateRejectsUnknownPool(t *testing.T) {
+	exp := &Experiment{Name: "x", Variants: []Variant{{Name: "a", Weight: 1, ResponsePool: "missing"}}}
+	if err := exp.Validate(); err == nil {
+		t.Fatal("expected an error for an unknown response pool")
+	}
+}
+
+func TestCollectorReportsPerVariant(t *testing.T) {
+	exp := &Experiment{Name: "tone", Variants: []Variant{{Name: "control", Weight: 1}, {Name: "gentle", Weight: 1}}}
+	d := events.NewDispatcher(8)
+	defer d.Stop()
+	c := NewCollector(exp)
+	c.Subscribe(d)
+
+	start := time.Now()
+	d.Publish(events.SessionCreated{SessionID: "a", Variant: "control", Time: start})
+	d.Publish(events.SessionCreated{SessionID: "b", Variant: "gentle", Time: start})
+	d.Publish(events.SessionCreated{SessionID: "c", Variant: "gentle", Time: start})
+
+	d.Publish(events.ActionApplied{SessionID: "b", Stage: 400})
+	d.Publish(events.PurchaseApplied{SessionID: "b", Variant: "gentle"})
+	d.Publish(events.PurchaseApplied{SessionID: "c", Variant: "gentle"})
+	d.Publish(events.PurchaseApplied{SessionID: "c", Variant: "gentle"})
+	d.Publish(events.NarratorMessageSent{SessionID: "a", Variant: "control"})
+	d.Publish(events.SessionEnded{SessionID: "b", Stage: 500, Duration: time.Minute, Variant: "gentle"})
+
+	report := c.Report(start.Add(3 * time.Minute))
+	if len(report.Variants) != 2 {
+		t.Fatalf("expected both variants, got %+v", report.Variants)
+	}
+
+	control, gentle := report.Variants[0], report.Variants[1]
+	if control.Sessions != 1 || control.NarratorMessages != 1 || control.ActiveSessions != 1 {
+		t.Fatalf("unexpected control report: %+v", control)
+	}
+	if gentle.Sessions != 2 || gentle.ActiveSessions != 1 || gentle.Purchases != 3 {
+		t.Fatalf("unexpected gentle report: %+v", gentle)
+	}
+	if gentle.MaxStage != 500 || gentle.AvgMaxStage != 250 || gentle.AvgSessionSeconds != 120 {
+		t.Fatalf("unexpected gentle outcomes: %+v", gentle)
+	}
+}
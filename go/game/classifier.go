@@ -0,0 +1,214 @@
+package game
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	StateProductive  = "productive"
+	StateTakingBreak = "taking_break"
+	StateDisengaged  = "disengaged"
+	StateConfused    = "confused"
+	StateObsessed    = "obsessed"
+)
+
+// NarratorStates lists the player states the narrator can report.
+var NarratorStates = []string{
+	StateProductive,
+	StateTakingBreak,
+	StateDisengaged,
+	StateConfused,
+	StateObsessed,
+}
+
+func IsNarratorState(state string) bool {
+	for _, s := range NarratorStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassifierThresholds tune the heuristic classifier. Rates are measured over
+// the recent action window passed to Classify.
+type ClassifierThresholds struct {
+	BreakIdle                 time.Duration
+	DisengagedIdle            time.Duration
+	BreakMinStage             int
+	ObsessedClicksPerSecond   float64
+	ObsessedClicksPerStage    float64
+	ProductiveClicksPerSecond float64
+	ConfusedIntervalVariation float64
+	MinActions                int
+}
+
+func DefaultClassifierThresholds() ClassifierThresholds {
+	return ClassifierThresholds{
+		BreakIdle:                 20 * time.Second,
+		DisengagedIdle:            90 * time.Second,
+		BreakMinStage:             100,
+		ObsessedClicksPerSecond:   8,
+		ObsessedClicksPerStage:    25,
+		ProductiveClicksPerSecond: 0.5,
+		ConfusedIntervalVariation: 1.5,
+		MinActions:                3,
+	}
+}
+
+// Signals are the measurements a classification was based on.
+type Signals struct {
+	ClicksPerSecond   float64 `json:"clicks_per_second"`
+	StagePerMinute    float64 `json:"stage_per_minute"`
+	ClicksPerStage    float64 `json:"clicks_per_stage"`
+	IdleSeconds       float64 `json:"idle_seconds"`
+	IntervalVariation float64 `json:"interval_variation"`
+	StageRegressed    bool    `json:"stage_regressed"`
+	Samples           int     `json:"samples"`
+}
+
+type Classification struct {
+	State      string  `json:"state"`
+	Confidence float64 `json:"confidence"`
+	Reason     string  `json:"reason"`
+	Signals    Signals `json:"signals"`
+}
+
+// Classifier maps click and stage rates plus idle time onto the narrator
+// states without calling an LLM. It is deterministic for a given input.
+type Classifier struct {
+	thresholds ClassifierThresholds
+}
+
+func NewClassifier(thresholds ClassifierThresholds) *Classifier {
+	return &Classifier{thresholds: thresholds}
+}
+
+func (c *Classifier) Thresholds() ClassifierThresholds {
+	return c.thresholds
+}
+
+func (c *Classifier) Classify(userState *UserState, recentActions []UserAction, now time.Time) *Classification {
+	t := c.thresholds
+	signals := measure(recentActions, now)
+
+	idle := time.Duration(signals.IdleSeconds * float64(time.Second))
+	stage := 0
+	if userState != nil {
+		stage = userState.Stage
+	}
+
+	switch {
+	case signals.Samples == 0:
+		return &Classification{StateDisengaged, 0.4, "no recent actions", signals}
+
+	case idle >= t.DisengagedIdle:
+		return &Classification{StateDisengaged, scale(idle.Seconds(), t.DisengagedIdle.Seconds(), 0.7),
+			fmt.Sprintf("idle for %.0fs", signals.IdleSeconds), signals}
+
+	case idle >= t.BreakIdle && stage >= t.BreakMinStage:
+		return &Classification{StateTakingBreak, scale(idle.Seconds(), t.BreakIdle.Seconds(), 0.6),
+			fmt.Sprintf("idle for %.0fs at a high stage", signals.IdleSeconds), signals}
+
+	case idle >= t.BreakIdle:
+		return &Classification{StateDisengaged, 0.55,
+			fmt.Sprintf("idle for %.0fs at a low stage", signals.IdleSeconds), signals}
+
+	case signals.Samples < t.MinActions:
+		return &Classification{StateProductive, 0.3, "not enough actions to judge", signals}
+
+	case signals.StageRegressed:
+		return &Classification{StateConfused, 0.75, "stage went backwards", signals}
+
+	case signals.ClicksPerSecond >= t.ObsessedClicksPerSecond:
+		return &Classification{StateObsessed, scale(signals.ClicksPerSecond, t.ObsessedClicksPerSecond, 0.65),
+			fmt.Sprintf("clicking at %.1f/s", signals.ClicksPerSecond), signals}
+
+	case signals.ClicksPerStage >= t.ObsessedClicksPerStage:
+		return &Classification{StateObsessed, scale(signals.ClicksPerStage, t.ObsessedClicksPerStage, 0.6),
+			fmt.Sprintf("%.1f clicks per stage", signals.ClicksPerStage), signals}
+
+	case signals.IntervalVariation >= t.ConfusedIntervalVariation:
+		return &Classification{StateConfused, scale(signals.IntervalVariation, t.ConfusedIntervalVariation, 0.55),
+			"erratic action rhythm", signals}
+
+	case signals.ClicksPerSecond >= t.ProductiveClicksPerSecond && signals.StagePerMinute > 0:
+		return &Classification{StateProductive, scale(signals.ClicksPerSecond, t.ProductiveClicksPerSecond, 0.6),
+			"steady clicking and progress", signals}
+
+	default:
+		return &Classification{StateDisengaged, 0.45, "little activity", signals}
+	}
+}
+
+// measure derives rates from the action window. Actions are expected in
+// chronological order.
+func measure(actions []UserAction, now time.Time) Signals {
+	signals := Signals{Samples: len(actions)}
+	if len(actions) == 0 {
+		return signals
+	}
+
+	first := actions[0]
+	last := actions[len(actions)-1]
+	signals.IdleSeconds = math.Max(0, now.Sub(last.ServerTimestamp).Seconds())
+
+	elapsed := last.ServerTimestamp.Sub(first.ServerTimestamp).Seconds()
+	clicksDelta := float64(last.Clicks - first.Clicks)
+	stageDelta := float64(last.Stage - first.Stage)
+	if elapsed > 0 {
+		signals.ClicksPerSecond = math.Max(0, clicksDelta/elapsed)
+		signals.StagePerMinute = stageDelta / elapsed * 60
+	}
+	if stageDelta > 0 {
+		signals.ClicksPerStage = clicksDelta / stageDelta
+	}
+
+	intervals := make([]float64, 0, len(actions)-1)
+	for i := 1; i < len(actions); i++ {
+		if actions[i].Stage < actions[i-1].Stage {
+			signals.StageRegressed = true
+		}
+		intervals = append(intervals, actions[i].ServerTimestamp.Sub(actions[i-1].ServerTimestamp).Seconds())
+	}
+	signals.IntervalVariation = coefficientOfVariation(intervals)
+
+	return signals
+}
+
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+	if mean <= 0 {
+		return 0
+	}
+
+	variance := 0.0
+	for _, v := range values {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(values))
+
+	return math.Sqrt(variance) / mean
+}
+
+// scale grows confidence from base towards 0.95 as value exceeds threshold.
+func scale(value, threshold, base float64) float64 {
+	if threshold <= 0 {
+		return base
+	}
+	excess := value/threshold - 1
+	if excess < 0 {
+		excess = 0
+	}
+	return math.Min(0.95, base+excess*0.15)
+}
@@ -0,0 +1,80 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+// actionsEvery builds a chronological action window ending at end.
+func actionsEvery(end time.Time, interval time.Duration, stages, clicks []int) []UserAction {
+	actions := make([]UserAction, len(stages))
+	for i := range stages {
+		actions[i] = UserAction{
+			Stage:           stages[i],
+			Clicks:          clicks[i],
+			ServerTimestamp: end.Add(-time.Duration(len(stages)-1-i) * interval),
+		}
+	}
+	return actions
+}
+
+func TestClassifierStates(t *testing.T) {
+	c := NewClassifier(DefaultClassifierThresholds())
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		state   *UserState
+		actions []UserAction
+		now     time.Time
+		want    string
+	}{
+		{
+			name:    "steady progress is productive",
+			state:   &UserState{Stage: 40},
+			actions: actionsEvery(now, time.Second, []int{10, 20, 30, 40}, []int{20, 23, 26, 29}),
+			now:     now,
+			want:    StateProductive,
+		},
+		{
+			name:    "fast clicking is obsessed",
+			state:   &UserState{Stage: 13},
+			actions: actionsEvery(now, time.Second, []int{10, 11, 12, 13}, []int{100, 120, 140, 160}),
+			now:     now,
+			want:    StateObsessed,
+		},
+		{
+			name:    "idle at a high stage is a break",
+			state:   &UserState{Stage: 500},
+			actions: actionsEvery(now, time.Second, []int{480, 490, 500}, []int{900, 950, 1000}),
+			now:     now.Add(30 * time.Second),
+			want:    StateTakingBreak,
+		},
+		{
+			name:    "long idle is disengaged",
+			state:   &UserState{Stage: 500},
+			actions: actionsEvery(now, time.Second, []int{480, 490, 500}, []int{900, 950, 1000}),
+			now:     now.Add(5 * time.Minute),
+			want:    StateDisengaged,
+		},
+		{
+			name:    "stage regression is confusing",
+			state:   &UserState{Stage: 15},
+			actions: actionsEvery(now, time.Second, []int{10, 20, 15, 16}, []int{10, 20, 30, 40}),
+			now:     now,
+			want:    StateConfused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Classify(tt.state, tt.actions, tt.now)
+			if got.State != tt.want {
+				t.Fatalf("got %s (%s), want %s", got.State, got.Reason, tt.want)
+			}
+			if got.Confidence <= 0 || got.Confidence > 1 {
+				t.Fatalf("confidence out of range: %f", got.Confidence)
+			}
+		})
+	}
+}
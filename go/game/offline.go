@@ -0,0 +1,231 @@
+package game
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// PlayerRecord keeps what a player owns and where they stopped between
+// connections. Sessions are per-connection; records are per player.
+type PlayerRecord struct {
+	PlayerID string        `json:"player_id"`
+	Stage    int           `json:"stage"`
+	Clicks   int           `json:"clicks"`
+	Upgrades map[int]int   `json:"upgrades"`
+	LeftAt   time.Time     `json:"left_at"`
+	Prestige PrestigeState `json:"prestige"`
+	Credits  int           `json:"credits"`
+}
+
+// Producers counts owned upgrades that keep earning while the player is away.
+func (pr *PlayerRecord) Producers() int {
+	count := 0
+	for itemID, owned := range pr.Upgrades {
+		if GetItemCategory(itemID) == "auto_clicker" {
+			count += owned
+		}
+	}
+	return count
+}
+
+type PlayerStore struct {
+	players map[string]*PlayerRecord
+	mu      sync.RWMutex
+}
+
+func NewPlayerStore() *PlayerStore {
+	return &PlayerStore{
+		players: make(map[string]*PlayerRecord),
+	}
+}
+
+// Get returns a copy of the player's record.
+func (ps *PlayerStore) Get(playerID string) (*PlayerRecord, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	record, exists := ps.players[playerID]
+	if !exists {
+		return nil, false
+	}
+	return record.clone(), true
+}
+
+// RecordLeave stores the player's final progress for a session and adds the
+// upgrades bought during it to what the player already owned.
+func (ps *PlayerStore) RecordLeave(playerID string, stage, clicks int, inventory map[int]int, leftAt time.Time) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	record, exists := ps.players[playerID]
+	if !exists {
+		record = &PlayerRecord{
+			PlayerID: playerID,
+			Upgrades: make(map[int]int),
+		}
+		ps.players[playerID] = record
+	}
+
+	for itemID, count := range inventory {
+		record.Upgrades[itemID] += count
+	}
+	if stage > record.Stage {
+		record.Stage = stage
+	}
+	if clicks > record.Clicks {
+		record.Clicks = clicks
+	}
+	record.LeftAt = leftAt
+}
+
+// MarkReturned clears the leave time once offline progress has been paid
+// out, so a reconnect storm cannot claim the same absence twice.
+func (ps *PlayerStore) MarkReturned(playerID string, stage, clicks int) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	record, exists := ps.players[playerID]
+	if !exists {
+		return
+	}
+	record.Stage = stage
+	record.Clicks = clicks
+	record.LeftAt = time.Time{}
+}
+
+// AddCredits pays a reward into the player's balance and returns the new
+// balance. The record is created when the player has not left a session yet.
+func (ps *PlayerStore) AddCredits(playerID string, amount int) int {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	record, exists := ps.players[playerID]
+	if !exists {
+		record = &PlayerRecord{
+			PlayerID: playerID,
+			Upgrades: make(map[int]int),
+		}
+		ps.players[playerID] = record
+	}
+	record.Credits += amount
+	return record.Credits
+}
+
+func (ps *PlayerStore) Credits(playerID string) int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	if record, exists := ps.players[playerID]; exists {
+		return record.Credits
+	}
+	return 0
+}
+
+func (pr *PlayerRecord) clone() *PlayerRecord {
+	upgrades := make(map[int]int, len(pr.Upgrades))
+	for itemID, owned := range pr.Upgrades {
+		upgrades[itemID] = owned
+	}
+	copied := *pr
+	copied.Upgrades = upgrades
+	return &copied
+}
+
+// OfflineRules mirror the frontend factory: every producer yields
+// IncomePerTick every TickInterval, scaled by Efficiency while offline.
+type OfflineRules struct {
+	IncomePerTick int
+	TickInterval  time.Duration
+	Efficiency    float64
+	MaxDuration   time.Duration
+	MinAway       time.Duration
+	StageMax      int
+	ClicksMax     int
+}
+
+// StageLevels are the stages at which each level starts, mirroring
+// LEVEL_THRESHOLDS in the frontend's level system.
+var StageLevels = []int{0, 10, 30, 100, 200, 350, 550, 800, 1100, 1450, 1850, 2300, 2800, 3000}
+
+// LevelForStage returns the level, counted from 1, the player has reached
+// at stage.
+func LevelForStage(stage int) int {
+	for i := len(StageLevels) - 1; i >= 0; i-- {
+		if stage >= StageLevels[i] {
+			return i + 1
+		}
+	}
+	return 1
+}
+
+// OfflineProgress reports a returning player's earnings. Stage and clicks
+// grow by the same income, as in the factory; LevelsEarned counts the
+// levels that income carried the player through.
+type OfflineProgress struct {
+	AwaySeconds     int  `json:"away_seconds"`
+	CreditedSeconds int  `json:"credited_seconds"`
+	Producers       int  `json:"producers"`
+	ClicksEarned    int  `json:"clicks_earned"`
+	StageEarned     int  `json:"stage_earned"`
+	LevelsEarned    int  `json:"levels_earned"`
+	Stage           int  `json:"stage"`
+	Level           int  `json:"level"`
+	Clicks          int  `json:"clicks"`
+	Capped          bool `json:"capped"`
+}
+
+// ComputeOfflineProgress returns what a returning player earned, or nil when
+// the absence was too short or nothing was producing.
+func ComputeOfflineProgress(record *PlayerRecord, now time.Time, rules OfflineRules) *OfflineProgress {
+	if record == nil || record.LeftAt.IsZero() {
+		return nil
+	}
+
+	away := now.Sub(record.LeftAt)
+	producers := record.Producers()
+	if away < rules.MinAway || producers == 0 || rules.TickInterval <= 0 {
+		return nil
+	}
+
+	credited := away
+	capped := false
+	if rules.MaxDuration > 0 && credited > rules.MaxDuration {
+		credited = rules.MaxDuration
+		capped = true
+	}
+
+	ticks := int(credited / rules.TickInterval)
+	earned := int(math.Floor(float64(ticks*producers*rules.IncomePerTick) * rules.Efficiency))
+
+	progress := &OfflineProgress{
+		AwaySeconds:     int(away.Seconds()),
+		CreditedSeconds: int(credited.Seconds()),
+		Producers:       producers,
+		ClicksEarned:    earned,
+		Stage:           record.Stage + earned,
+		Clicks:          record.Clicks + earned,
+		Capped:          capped,
+	}
+
+	if rules.StageMax > 0 && progress.Stage > rules.StageMax {
+		progress.Stage = rules.StageMax
+		progress.Capped = true
+	}
+	if rules.ClicksMax > 0 && progress.Clicks > rules.ClicksMax {
+		progress.Clicks = rules.ClicksMax
+		progress.ClicksEarned = rules.ClicksMax - record.Clicks
+		progress.Capped = true
+	}
+	if progress.Stage < record.Stage {
+		progress.Stage = record.Stage
+	}
+	progress.StageEarned = progress.Stage - record.Stage
+	progress.Level = LevelForStage(progress.Stage)
+	progress.LevelsEarned = progress.Level - LevelForStage(record.Stage)
+	if progress.ClicksEarned < 0 {
+		progress.ClicksEarned = 0
+	}
+
+	return progress
+}
@@ -0,0 +1,82 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func testOfflineRules() OfflineRules {
+	return OfflineRules{
+		IncomePerTick: 25,
+		TickInterval:  3 * time.Second,
+		Efficiency:    0.5,
+		MaxDuration:   time.Hour,
+		MinAway:       time.Minute,
+		StageMax:      3000,
+		ClicksMax:     10000,
+	}
+}
+
+func TestComputeOfflineProgress(t *testing.T) {
+	store := NewPlayerStore()
+	leftAt := time.Now()
+	// item 1 is an auto-clicker, item 2 an abstract meme that earns nothing
+	store.RecordLeave("p1", 100, 400, map[int]int{1: 2, 2: 1}, leftAt)
+
+	record, _ := store.Get("p1")
+	progress := ComputeOfflineProgress(record, leftAt.Add(10*time.Minute), testOfflineRules())
+	if progress == nil {
+		t.Fatal("expected offline progress")
+	}
+
+	// 200 ticks * 2 producers * 25 * 0.5
+	if progress.Producers != 2 || progress.ClicksEarned != 5000 || progress.Clicks != 5400 {
+		t.Fatalf("unexpected progress: %+v", progress)
+	}
+	// stage is capped at StageMax
+	if progress.Stage != 3000 || progress.StageEarned != 2900 || !progress.Capped {
+		t.Fatalf("stage not capped: %+v", progress)
+	}
+	// stage 100 is level 4, stage 3000 level 14
+	if progress.Level != 14 || progress.LevelsEarned != 10 {
+		t.Fatalf("unexpected levels: %+v", progress)
+	}
+}
+
+func TestComputeOfflineProgressSkipsShortAbsences(t *testing.T) {
+	store := NewPlayerStore()
+	leftAt := time.Now()
+	store.RecordLeave("p1", 10, 10, map[int]int{1: 1}, leftAt)
+
+	record, _ := store.Get("p1")
+	if progress := ComputeOfflineProgress(record, leftAt.Add(30*time.Second), testOfflineRules()); progress != nil {
+		t.Fatalf("expected no progress for a short absence, got %+v", progress)
+	}
+
+	store.MarkReturned("p1", 10, 10)
+	record, _ = store.Get("p1")
+	if progress := ComputeOfflineProgress(record, leftAt.Add(time.Hour), testOfflineRules()); progress != nil {
+		t.Fatalf("absence already paid out, got %+v", progress)
+	}
+}
+
+func TestComputeOfflineProgressCapsDuration(t *testing.T) {
+	store := NewPlayerStore()
+	leftAt := time.Now()
+	store.RecordLeave("p1", 0, 0, map[int]int{4: 1}, leftAt)
+
+	record, _ := store.Get("p1")
+	progress := ComputeOfflineProgress(record, leftAt.Add(5*time.Hour), testOfflineRules())
+	if progress == nil || !progress.Capped || progress.CreditedSeconds != 3600 {
+		t.Fatalf("expected duration cap, got %+v", progress)
+	}
+}
+
+func TestLevelForStage(t *testing.T) {
+	cases := map[int]int{0: 1, 9: 1, 10: 2, 99: 3, 100: 4, 2999: 13, 3000: 14, 5000: 14}
+	for stage, want := range cases {
+		if got := LevelForStage(stage); got != want {
+			t.Errorf("LevelForStage(%d) = %d, want %d", stage, got, want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+// PrestigeState is a player's permanent rebirth progress. It survives
+// sessions and prestiges; only stage and clicks start over.
+type PrestigeState struct {
+	Count      int       `json:"count"`
+	Currency   int       `json:"currency"`
+	Multiplier float64   `json:"multiplier"`
+	LastAt     time.Time `json:"last_at,omitempty"`
+}
+
+// ProgressLimits are the largest stage and clicks a player may report.
+type ProgressLimits struct {
+	StageMax  int `json:"stage_max"`
+	ClicksMax int `json:"clicks_max"`
+}
+
+// PrestigeRules describe the rebirth loop: the caps grow by CapGrowth per
+// prestige, every StagePerPoint stages reached earn one point of currency,
+// and each point adds MultiplierPerPoint to the permanent multiplier.
+type PrestigeRules struct {
+	StageMax           int
+	ClicksMax          int
+	CapGrowth          float64
+	StagePerPoint      int
+	MultiplierPerPoint float64
+}
+
+// PrestigeStatus is what the client needs to render the prestige screen.
+type PrestigeStatus struct {
+	Count      int     `json:"count"`
+	Currency   int     `json:"currency"`
+	Multiplier float64 `json:"multiplier"`
+	Earned     int     `json:"earned,omitempty"`
+	StageCap   int     `json:"stage_cap"`
+	ClicksCap  int     `json:"clicks_cap"`
+}
+
+// Limits returns the caps after count prestiges.
+func (r PrestigeRules) Limits(count int) ProgressLimits {
+	scale := 1 + r.CapGrowth*float64(count)
+	return ProgressLimits{
+		StageMax:  int(float64(r.StageMax) * scale),
+		ClicksMax: int(float64(r.ClicksMax) * scale),
+	}
+}
+
+// Earned is the currency a prestige at stage would pay out.
+func (r PrestigeRules) Earned(stage int) int {
+	if r.StagePerPoint <= 0 {
+		return 0
+	}
+	return stage / r.StagePerPoint
+}
+
+func (r PrestigeRules) Multiplier(currency int) float64 {
+	return 1 + r.MultiplierPerPoint*float64(currency)
+}
+
+// CanPrestige reports whether a player has reached the current stage cap.
+func (r PrestigeRules) CanPrestige(state PrestigeState, stage int) bool {
+	return stage >= r.Limits(state.Count).StageMax
+}
+
+// Apply returns the state after prestiging at stage and the currency earned.
+func (r PrestigeRules) Apply(state PrestigeState, stage int, now time.Time) (PrestigeState, int, error) {
+	if !r.CanPrestige(state, stage) {
+		return state, 0, fmt.Errorf("prestige requires stage %d", r.Limits(state.Count).StageMax)
+	}
+
+	earned := r.Earned(stage)
+	state.Count++
+	state.Currency += earned
+	state.Multiplier = r.Multiplier(state.Currency)
+	state.LastAt = now
+	return state, earned, nil
+}
+
+func (r PrestigeRules) Status(state PrestigeState, earned int) PrestigeStatus {
+	limits := r.Limits(state.Count)
+	multiplier := state.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+	return PrestigeStatus{
+		Count:      state.Count,
+		Currency:   state.Currency,
+		Multiplier: multiplier,
+		Earned:     earned,
+		StageCap:   limits.StageMax,
+		ClicksCap:  limits.ClicksMax,
+	}
+}
+
+// Prestige starts the player's progress over. The record is created when
+// the player has not left a session yet.
+func (ps *PlayerStore) Prestige(playerID string, rules PrestigeRules, stage int, now time.Time) (PrestigeState, int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	record, exists := ps.players[playerID]
+	if !exists {
+		record = &PlayerRecord{
+			PlayerID: playerID,
+			Upgrades: make(map[int]int),
+		}
+	}
+
+	state, earned, err := rules.Apply(record.Prestige, stage, now)
+	if err != nil {
+		return record.Prestige, 0, err
+	}
+
+	ps.players[playerID] = record
+	record.Prestige = state
+	record.Stage = 0
+	record.Clicks = 0
+	record.LeftAt = time.Time{}
+	return state, earned, nil
+}
+
+// GetPrestigeResponse is the narrator's line after a player starts over for
+// the count-th time.
+func GetPrestigeResponse(count int) string {
+	switch {
+	case count <= 1:
+		return "你亲手推倒了一切，又站回了起点。"
+	case count <= 3:
+		return "又一次从头开始，你好像越来越熟练了。"
+	case count <= 9:
+		return "重来已经成了习惯，起点和终点开始分不清。"
+	default:
+		return "你重新开始了太多次，也许重新开始本身就是目的。"
+	}
+}
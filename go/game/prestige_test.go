@@ -0,0 +1,73 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+var testPrestigeRules = PrestigeRules{
+	StageMax:           3000,
+	ClicksMax:          10000,
+	CapGrowth:          0.5,
+	StagePerPoint:      300,
+	MultiplierPerPoint: 0.05,
+}
+
+func TestPrestigeRaisesCapsAndMultiplier(t *testing.T) {
+	store := NewPlayerStore()
+	now := time.Now()
+
+	if _, _, err := store.Prestige("p1", testPrestigeRules, 2999, now); err == nil {
+		t.Fatal("prestige below the stage cap should fail")
+	}
+
+	state, earned, err := store.Prestige("p1", testPrestigeRules, 3000, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if earned != 10 || state.Count != 1 || state.Currency != 10 || state.Multiplier != 1.5 {
+		t.Fatalf("unexpected first prestige: earned %d, %+v", earned, state)
+	}
+
+	limits := testPrestigeRules.Limits(state.Count)
+	if limits.StageMax != 4500 || limits.ClicksMax != 15000 {
+		t.Fatalf("unexpected limits: %+v", limits)
+	}
+
+	if _, _, err := store.Prestige("p1", testPrestigeRules, 3000, now); err == nil {
+		t.Fatal("the second prestige should require the raised cap")
+	}
+
+	state, earned, err = store.Prestige("p1", testPrestigeRules, 4500, now)
+	if err != nil || earned != 15 || state.Count != 2 || state.Currency != 25 {
+		t.Fatalf("unexpected second prestige: %+v, %d, %v", state, earned, err)
+	}
+
+	record, _ := store.Get("p1")
+	if record.Stage != 0 || record.Clicks != 0 || record.Prestige.Count != 2 {
+		t.Fatalf("record should keep prestige and reset progress: %+v", record)
+	}
+}
+
+func TestSessionStartOverResetsUserState(t *testing.T) {
+	session := NewSessionData("s1", 10, 50)
+	session.UpdateState(3000, 9000, time.Now())
+	session.UpdateState(3000, 9500, time.Now())
+
+	session.StartOver(PrestigeState{Count: 1, Currency: 10, Multiplier: 1.5})
+
+	state := session.GetUserState()
+	if state.Stage != 0 || state.Clicks != 0 || state.PrestigeCount != 1 || state.PrestigeMultiplier != 1.5 {
+		t.Fatalf("unexpected state after prestige: %+v", state)
+	}
+
+	session.UpdateState(5, 20, time.Now())
+	if actions := session.GetRecentActions(10); len(actions) != 1 || actions[0].Stage != 5 {
+		t.Fatalf("actions before the prestige should not count: %+v", actions)
+	}
+
+	marker := session.Timeline(TimelineQuery{Types: []EventType{EventPrestige}})
+	if len(marker) != 1 || marker[0].Stage != 3000 || marker[0].Prestige != 1 {
+		t.Fatalf("unexpected prestige event: %+v", marker)
+	}
+}
@@ -14,17 +14,24 @@ type EncodedResponse struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+// DefaultResponsePool names the built-in purchase lines.
+const DefaultResponsePool = "default"
+
 type ResponseSystem struct {
 	responses map[int]string
 	categories map[int]string
+	// pools holds alternative purchase lines by name, e.g. for experiments.
+	pools map[string]map[int]string
 }
 
 func NewResponseSystem() *ResponseSystem {
 	rs := &ResponseSystem{
 		responses:  make(map[int]string),
 		categories: make(map[int]string),
+		pools:      make(map[string]map[int]string),
 	}
 	rs.initializeResponses()
+	rs.initializePools()
 	return rs
 }
 
@@ -63,6 +70,43 @@ func (rs *ResponseSystem) initializeResponses() {
 	}
 }
 
+func (rs *ResponseSystem) initializePools() {
+    rs.pools["companion"] = map[int]string{
+        0: "多一点力量也好，你值得被自己温柔地推着走。",
+        1: "让它替你忙一会儿吧，你可以歇歇了。",
+        2: "买下一个说不清的东西，也算是给自己的小礼物。",
+        3: "每一下都更有分量了，别忘了手也会累。",
+        4: "机器又多了一台，你终于可以抬头看看窗外。",
+        5: "它没有形状，但你喜欢，这就够了。",
+        6: "你想走得更快，我陪你。",
+        7: "它在替你点，你在替自己想。",
+        8: "这点小小的浪费，也是生活的一部分。",
+    }
+}
+
+// HasPool reports whether a purchase line pool exists.
+func (rs *ResponseSystem) HasPool(pool string) bool {
+	if pool == "" || pool == DefaultResponsePool {
+		return true
+	}
+	_, exists := rs.pools[pool]
+	return exists
+}
+
+// GetPoolResponse returns the purchase line for itemID from the named pool,
+// falling back to the default lines for unknown pools or items.
+func (rs *ResponseSystem) GetPoolResponse(pool string, itemID int) string {
+	if lines, exists := rs.pools[pool]; exists {
+		if response, exists := lines[itemID]; exists {
+			return response
+		}
+	}
+	if response, exists := rs.responses[itemID]; exists {
+		return response
+	}
+	return "你买了点什么。恭喜？"
+}
+
 func (rs *ResponseSystem) GetResponse(itemID int) (*EncodedResponse, error) {
 	if itemID < 0 || itemID > 8 {
 		return nil, fmt.Errorf("item_id must be between 0 and 8")
@@ -168,4 +212,81 @@ func GetDefaultResponse(itemID int) string {
 	default:
 		return "You purchased something. Is this progress?"
 	}
-}
\ No newline at end of file
+}
+
+// GetReturnResponse picks a narrator line for a player coming back after
+// their upgrades kept working without them.
+func GetReturnResponse(progress *OfflineProgress) string {
+	switch {
+	case progress == nil:
+		return "你回来了，这里什么都没变。"
+	case progress.AwaySeconds < 10*60:
+		return "才离开一会儿，工厂已经替你忙了起来。"
+	case progress.AwaySeconds < 3*60*60:
+		return "你不在的时候，机器一刻也没停，它们不需要你。"
+	case progress.Capped:
+		return "你走了太久，连机器都累了，它们只替你攒下这么多。"
+	default:
+		return "欢迎回来，世界在你缺席时照样运转。"
+	}
+}
+
+var stateResponses = map[string][]string{
+	StateProductive: {
+		"你一直在往前走，可前面究竟有什么？",
+		"这么认真，像是在完成一件很重要的事。",
+		"你很投入，世界也因此安静了一点。",
+	},
+	StateTakingBreak: {
+		"停下来也很好，反正它不会跑掉。",
+		"走了这么远，歇一歇也无妨。",
+		"休息的时候，你终于属于自己了。",
+	},
+	StateDisengaged: {
+		"还在吗？",
+		"这里只剩下按钮在等你。",
+		"你离开的时候，按钮显得有点孤单。",
+	},
+	StateConfused: {
+		"你在找什么，也许它并不存在。",
+		"方向感消失了，这很正常。",
+		"不知道该做什么的时候，就什么也别做。",
+	},
+	StateObsessed: {
+		"慢一点，按钮不会因此更爱你。",
+		"你的手比你的心更快。",
+		"再多一下，就会满足吗？",
+	},
+}
+
+// GetStateResponse returns a scripted narrator line for a player state. The
+// variant index rotates through the pool so repeated calls vary.
+func GetStateResponse(state string, variant int) string {
+	pool, exists := stateResponses[state]
+	if !exists || len(pool) == 0 {
+		return "你还在这里。"
+	}
+	if variant < 0 {
+		variant = -variant
+	}
+	return pool[variant%len(pool)]
+}
+
+var idleResponses = []string{
+	"你停下来了。按钮还在等你。",
+	"安静了好一会儿，你还在吗？",
+	"也许你找到了比点击更重要的事。",
+	"我就不打扰你了，想回来的时候它一直都在。",
+}
+
+// GetIdleResponse returns the scripted line for the count-th nudge of an
+// idle streak. Later nudges get quieter instead of louder.
+func GetIdleResponse(count int) string {
+	if count < 1 {
+		count = 1
+	}
+	if count > len(idleResponses) {
+		count = len(idleResponses)
+	}
+	return idleResponses[count-1]
+}
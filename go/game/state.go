@@ -11,24 +11,40 @@ type GameState struct {
 	Clicks int `json:"clicks"`
 }
 
+// rateSmoothing is the weight of the newest sample in the moving averages.
+const rateSmoothing = 0.3
+
+// UserAction is one progress report. ClientTimestamp is what the browser
+// sent; ServerTimestamp is when the server received it and is the clock all
+// rates are measured on.
 type UserAction struct {
-	Stage     int       `json:"stage"`
-	Clicks    int       `json:"clicks"`
-	Timestamp time.Time `json:"timestamp"`
+	Stage           int       `json:"stage"`
+	Clicks          int       `json:"clicks"`
+	ClientTimestamp time.Time `json:"client_timestamp"`
+	ServerTimestamp time.Time `json:"server_timestamp"`
 }
 
 type SessionData struct {
-	ID            string    `json:"session_id"`
-	CurrentState  string    `json:"current_state"`
-	LastAnalysis  time.Time `json:"last_analysis"`
-	StageHistory  []int     `json:"stage_history"`
-	ClicksHistory []int     `json:"clicks_history"`
-	ItemPurchases []int     `json:"item_purchases"`
-	LLMResponses  []string  `json:"llm_responses"`
-	CreatedAt     time.Time `json:"created_at"`
-	LastActivity  time.Time `json:"last_activity"`
-	historySize   int
-	mu            sync.RWMutex
+	ID           string    `json:"session_id"`
+	CurrentState string    `json:"current_state"`
+	LastAnalysis time.Time `json:"last_analysis"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	variant      string
+	prestige     PrestigeState
+	// resetSeq is the timeline sequence of the last prestige; actions
+	// before it no longer count towards the user state.
+	resetSeq     uint64
+	machine      *StateMachine
+	timeline     *Timeline
+	inventory    map[int]int
+	purchases    int
+	narrations   int
+	historySize  int
+	rateSamples  int
+	avgClickRate float64
+	avgStageRate float64
+	mu           sync.RWMutex
 }
 
 type UserState struct {
@@ -39,60 +55,219 @@ type UserState struct {
 	PreviousStage  int       `json:"previous_stage"`
 	PreviousClicks int       `json:"previous_clicks"`
 	EngagementRate float64   `json:"engagement_rate"`
+
+	LastActionAt       time.Time `json:"last_action_at"`
+	ClicksPerSecond    float64   `json:"clicks_per_second"`
+	StagePerMinute     float64   `json:"stage_per_minute"`
+	AvgClicksPerSecond float64   `json:"avg_clicks_per_second"`
+	AvgStagePerMinute  float64   `json:"avg_stage_per_minute"`
+	IdleSeconds        float64   `json:"idle_seconds"`
+	LongestGapSeconds  float64   `json:"longest_gap_seconds"`
+
+	PrestigeCount      int     `json:"prestige_count"`
+	PrestigeMultiplier float64 `json:"prestige_multiplier"`
+}
+
+func NewSessionData(sessionID string, historySize, timelineSize int) *SessionData {
+	return NewSessionDataWithRules(sessionID, historySize, timelineSize, DefaultStateMachineRules())
 }
 
-func NewSessionData(sessionID string, historySize int) *SessionData {
+// NewSessionDataWithRules creates a session whose narrator state follows
+// the given state machine rules.
+func NewSessionDataWithRules(sessionID string, historySize, timelineSize int, rules StateMachineRules) *SessionData {
 	now := time.Now()
 	return &SessionData{
-		ID:            sessionID,
-		CurrentState:  "new",
-		LastAnalysis:  now,
-		StageHistory:  make([]int, 0, historySize),
-		ClicksHistory: make([]int, 0, historySize),
-		ItemPurchases: make([]int, 0),
-		LLMResponses:  make([]string, 0),
-		CreatedAt:     now,
-		LastActivity:  now,
-		historySize:   historySize,
+		ID:           sessionID,
+		CurrentState: StateNew,
+		LastAnalysis: now,
+		CreatedAt:    now,
+		LastActivity: now,
+		machine:      NewStateMachine(rules, now),
+		timeline:     NewTimeline(timelineSize),
+		inventory:    make(map[int]int),
+		historySize:  historySize,
 	}
 }
 
-func (sd *SessionData) UpdateState(stage, clicks int) {
+// SetVariant records the experiment variant the session was assigned to.
+// Purchases and narrator lines on the timeline are tagged with it.
+func (sd *SessionData) SetVariant(variant string) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.variant = variant
+}
+
+func (sd *SessionData) Variant() string {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	return sd.variant
+}
+
+// SetPrestige loads the player's permanent prestige progress.
+func (sd *SessionData) SetPrestige(state PrestigeState) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.prestige = state
+}
+
+func (sd *SessionData) Prestige() PrestigeState {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	return sd.prestige
+}
+
+// StartOver records a prestige and resets stage, clicks and rate metrics.
+// Earlier actions stay on the timeline but no longer feed the user state.
+func (sd *SessionData) StartOver(state PrestigeState) {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	sd.StageHistory = append(sd.StageHistory, stage)
-	sd.ClicksHistory = append(sd.ClicksHistory, clicks)
+	stage, clicks := 0, 0
+	if last := sd.recentActions(1); len(last) == 1 {
+		stage, clicks = last[0].Stage, last[0].Clicks
+	}
+
+	event := sd.timeline.Append(TimelineEvent{
+		Type:     EventPrestige,
+		Stage:    stage,
+		Clicks:   clicks,
+		Prestige: state.Count,
+	})
+	sd.resetSeq = event.Seq
+	sd.prestige = state
+	sd.rateSamples = 0
+	sd.avgClickRate, sd.avgStageRate = 0, 0
 	sd.LastActivity = time.Now()
+}
 
-	if len(sd.StageHistory) > sd.historySize {
-		sd.StageHistory = sd.StageHistory[1:]
-		sd.ClicksHistory = sd.ClicksHistory[1:]
+func (sd *SessionData) RecordConnect() {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.timeline.Append(TimelineEvent{Type: EventConnect, State: sd.CurrentState})
+}
+
+func (sd *SessionData) RecordDisconnect() {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.timeline.Append(TimelineEvent{Type: EventDisconnect, State: sd.CurrentState})
+}
+
+func (sd *SessionData) UpdateState(stage, clicks int, clientTime time.Time) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	now := time.Now()
+	if prev := sd.recentActions(1); len(prev) == 1 {
+		if elapsed := now.Sub(prev[0].ServerTimestamp).Seconds(); elapsed > 0 {
+			clickRate := float64(clicks-prev[0].Clicks) / elapsed
+			stageRate := float64(stage-prev[0].Stage) / elapsed * 60
+			if sd.rateSamples == 0 {
+				sd.avgClickRate, sd.avgStageRate = clickRate, stageRate
+			} else {
+				sd.avgClickRate += rateSmoothing * (clickRate - sd.avgClickRate)
+				sd.avgStageRate += rateSmoothing * (stageRate - sd.avgStageRate)
+			}
+			sd.rateSamples++
+		}
 	}
+
+	sd.timeline.Append(TimelineEvent{
+		Type:       EventAction,
+		Time:       now,
+		ClientTime: clientTime,
+		Stage:      stage,
+		Clicks:     clicks,
+	})
+	sd.LastActivity = now
 }
 
 func (sd *SessionData) AddPurchase(itemID int) {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	sd.ItemPurchases = append(sd.ItemPurchases, itemID)
+	sd.inventory[itemID]++
+	sd.purchases++
+	sd.timeline.Append(TimelineEvent{
+		Type:     EventPurchase,
+		ItemID:   &itemID,
+		Category: GetItemCategory(itemID),
+		Variant:  sd.variant,
+	})
 	sd.LastActivity = time.Now()
 }
 
-func (sd *SessionData) AddLLMResponse(response string) {
+// AddLLMResponse records an analyzer line with the version of the prompt
+// templates that produced it.
+func (sd *SessionData) AddLLMResponse(response, promptVersion string) {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	sd.LLMResponses = append(sd.LLMResponses, response)
-	sd.CurrentState = "analyzed"
+	sd.appendNarration(sd.CurrentState, response, "llm", promptVersion)
 	sd.LastAnalysis = time.Now()
 }
 
-func (sd *SessionData) UpdateCurrentState(state string) {
+// AddNarration records a narrator line that did not come from analysis,
+// such as a scripted story beat, without touching the narrator state.
+func (sd *SessionData) AddNarration(state, message, source string) {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	sd.appendNarration(state, message, source, "")
+}
+
+func (sd *SessionData) appendNarration(state, message, source, promptVersion string) {
+	sd.narrations++
+	sd.timeline.Append(TimelineEvent{
+		Type:          EventNarrator,
+		Message:       message,
+		Source:        source,
+		State:         state,
+		Variant:       sd.variant,
+		PromptVersion: promptVersion,
+	})
+}
+
+// ProposeState passes a suggested narrator state through the session's
+// state machine. Accepted transitions update CurrentState and are recorded
+// on the timeline.
+func (sd *SessionData) ProposeState(p StateProposal, now time.Time) Decision {
+	sd.mu.Lock()
+	defer sd.mu.Unlock()
+
+	decision := sd.machine.Propose(p, now)
+	if !decision.Accepted {
+		return decision
+	}
+
+	sd.timeline.Append(TimelineEvent{
+		Type:      EventStateTransition,
+		FromState: decision.From,
+		ToState:   decision.To,
+		Source:    p.Source,
+	})
+	sd.CurrentState = decision.To
+	return decision
+}
+
+// StateHistory returns the session's accepted narrator state transitions.
+func (sd *SessionData) StateHistory() []Transition {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	return sd.machine.History()
+}
+
+// Touch marks the session as active without recording anything.
+func (sd *SessionData) Touch() {
 	sd.mu.Lock()
 	defer sd.mu.Unlock()
 
-	sd.CurrentState = state
 	sd.LastActivity = time.Now()
 }
 
@@ -108,23 +283,52 @@ func (sd *SessionData) GetUserState() *UserState {
 		PreviousStage:  0,
 		PreviousClicks: 0,
 		EngagementRate: 0.0,
+
+		PrestigeCount:      sd.prestige.Count,
+		PrestigeMultiplier: sd.prestige.Multiplier,
+	}
+	if state.PrestigeMultiplier == 0 {
+		state.PrestigeMultiplier = 1
 	}
 
-	historyLen := len(sd.StageHistory)
+	actions := sd.recentActions(sd.historySize)
+	historyLen := len(actions)
 	if historyLen > 0 {
-		state.Stage = sd.StageHistory[historyLen-1]
-		state.Clicks = sd.ClicksHistory[historyLen-1]
+		last := actions[historyLen-1]
+		state.Stage = last.Stage
+		state.Clicks = last.Clicks
+		state.LastActionAt = last.ServerTimestamp
+		state.IdleSeconds = time.Since(last.ServerTimestamp).Seconds()
 	}
 
 	if historyLen > 1 {
-		state.PreviousStage = sd.StageHistory[historyLen-2]
-		state.PreviousClicks = sd.ClicksHistory[historyLen-2]
-		stageChange := float64(state.Stage - state.PreviousStage)
-		clicksChange := float64(state.Clicks - state.PreviousClicks)
+		first := actions[0]
+		prev := actions[historyLen-2]
+		last := actions[historyLen-1]
+
+		state.PreviousStage = prev.Stage
+		state.PreviousClicks = prev.Clicks
+		stageChange := float64(last.Stage - prev.Stage)
+		clicksChange := float64(last.Clicks - prev.Clicks)
 
 		if stageChange > 0 {
 			state.EngagementRate = clicksChange / stageChange
 		}
+
+		if window := last.ServerTimestamp.Sub(first.ServerTimestamp).Seconds(); window > 0 {
+			state.ClicksPerSecond = float64(last.Clicks-first.Clicks) / window
+			state.StagePerMinute = float64(last.Stage-first.Stage) / window * 60
+		}
+
+		for i := 1; i < historyLen; i++ {
+			gap := actions[i].ServerTimestamp.Sub(actions[i-1].ServerTimestamp).Seconds()
+			if gap > state.LongestGapSeconds {
+				state.LongestGapSeconds = gap
+			}
+		}
+
+		state.AvgClicksPerSecond = sd.avgClickRate
+		state.AvgStagePerMinute = sd.avgStageRate
 	}
 
 	return state
@@ -134,27 +338,85 @@ func (sd *SessionData) GetRecentActions(limit int) []UserAction {
 	sd.mu.RLock()
 	defer sd.mu.RUnlock()
 
-	count := len(sd.StageHistory)
-	if count == 0 {
+	return sd.recentActions(limit)
+}
+
+// recentActions rebuilds the last limit actions from the timeline. The
+// caller must hold the lock.
+func (sd *SessionData) recentActions(limit int) []UserAction {
+	if limit <= 0 {
 		return []UserAction{}
 	}
 
-	if limit > count {
-		limit = count
+	events := sd.timeline.Query(TimelineQuery{Types: []EventType{EventAction}, AfterSeq: sd.resetSeq, Limit: limit})
+	actions := make([]UserAction, len(events))
+	for i, event := range events {
+		actions[i] = UserAction{
+			Stage:           event.Stage,
+			Clicks:          event.Clicks,
+			ClientTimestamp: event.ClientTime,
+			ServerTimestamp: event.Time,
+		}
 	}
+	return actions
+}
 
-	actions := make([]UserAction, limit)
-	start := count - limit
+// RecentNarrations returns the last limit narrator lines spoken to the
+// session, oldest first, whatever their source.
+func (sd *SessionData) RecentNarrations(limit int) []string {
+	if limit <= 0 {
+		return nil
+	}
 
-	for i := 0; i < limit; i++ {
-		actions[i] = UserAction{
-			Stage:     sd.StageHistory[start+i],
-			Clicks:    sd.ClicksHistory[start+i],
-			Timestamp: time.Now().Add(-time.Duration(limit-i) * time.Second),
-		}
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	events := sd.timeline.Query(TimelineQuery{Types: []EventType{EventNarrator}, Limit: limit})
+	messages := make([]string, len(events))
+	for i, event := range events {
+		messages[i] = event.Message
 	}
+	return messages
+}
 
-	return actions
+// Timeline returns the session's events matching the query, oldest first.
+func (sd *SessionData) Timeline(q TimelineQuery) []TimelineEvent {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	return sd.timeline.Query(q)
+}
+
+// LastEventBefore returns the most recent event of a type that precedes seq.
+func (sd *SessionData) LastEventBefore(seq uint64, eventType EventType) (TimelineEvent, bool) {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	return sd.timeline.LastBefore(seq, eventType)
+}
+
+// GetInventory returns how many of each item were bought in this session.
+func (sd *SessionData) GetInventory() map[int]int {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	inventory := make(map[int]int, len(sd.inventory))
+	for itemID, count := range sd.inventory {
+		inventory[itemID] = count
+	}
+	return inventory
+}
+
+func (sd *SessionData) PurchaseCount() int {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	return sd.purchases
+}
+
+func (sd *SessionData) NarratorMessageCount() int {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+	return sd.narrations
 }
 
 func (sd *SessionData) IsActive(timeout time.Duration) bool {
@@ -164,24 +426,67 @@ func (sd *SessionData) IsActive(timeout time.Duration) bool {
 	return time.Since(sd.LastActivity) < timeout
 }
 
+// SessionSummary is a copy of a session's exported fields.
+type SessionSummary struct {
+	ID           string
+	CurrentState string
+	LastAnalysis time.Time
+	CreatedAt    time.Time
+	LastActivity time.Time
+}
+
+// Summary copies the exported fields under the session lock.
+func (sd *SessionData) Summary() SessionSummary {
+	sd.mu.RLock()
+	defer sd.mu.RUnlock()
+
+	return SessionSummary{
+		ID:           sd.ID,
+		CurrentState: sd.CurrentState,
+		LastAnalysis: sd.LastAnalysis,
+		CreatedAt:    sd.CreatedAt,
+		LastActivity: sd.LastActivity,
+	}
+}
+
 type StateManager struct {
-	sessions    map[string]*SessionData
-	mu          sync.RWMutex
-	historySize int
+	sessions     map[string]*SessionData
+	mu           sync.RWMutex
+	historySize  int
+	timelineSize int
+	rules        StateMachineRules
 }
 
-func NewStateManager(historySize int) *StateManager {
+func NewStateManager(historySize, timelineSize int) *StateManager {
 	return &StateManager{
-		sessions:    make(map[string]*SessionData),
-		historySize: historySize,
+		sessions:     make(map[string]*SessionData),
+		historySize:  historySize,
+		timelineSize: timelineSize,
+		rules:        DefaultStateMachineRules(),
 	}
 }
 
+// SetMachineRules changes the narrator state machine rules of sessions
+// created from now on.
+func (sm *StateManager) SetMachineRules(rules StateMachineRules) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	sm.rules = rules
+}
+
+func (sm *StateManager) MachineRules() StateMachineRules {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return sm.rules
+}
+
 func (sm *StateManager) CreateSession(sessionID string) *SessionData {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session := NewSessionData(sessionID, sm.historySize)
+	session := NewSessionDataWithRules(sessionID, sm.historySize, sm.timelineSize, sm.rules)
 	sm.sessions[sessionID] = session
 	return session
 }
@@ -201,13 +506,13 @@ func (sm *StateManager) DeleteSession(sessionID string) {
 	delete(sm.sessions, sessionID)
 }
 
-func (sm *StateManager) UpdateSessionState(sessionID string, stage, clicks int) (*SessionData, error) {
+func (sm *StateManager) UpdateSessionState(sessionID string, stage, clicks int, clientTime time.Time) (*SessionData, error) {
 	session, exists := sm.GetSession(sessionID)
 	if !exists {
 		return nil, fmt.Errorf("session %s not found", sessionID)
 	}
 
-	session.UpdateState(stage, clicks)
+	session.UpdateState(stage, clicks, clientTime)
 	return session, nil
 }
 
@@ -0,0 +1,167 @@
+package game
+
+import (
+	"fmt"
+	"time"
+)
+
+// StateNew is the narrator state of a session that has not been analyzed
+// yet. Any narrator state may follow it.
+const StateNew = "new"
+
+// StateMachineRules describe which narrator state changes are allowed and
+// how much evidence they need. A state must be held for its minimum dwell
+// time before it can be left, which keeps sessions from flapping between
+// states on every analysis tick.
+type StateMachineRules struct {
+	Allowed       map[string][]string
+	MinDwell      map[string]time.Duration
+	DefaultDwell  time.Duration
+	MinConfidence float64
+	HistorySize   int
+}
+
+func DefaultStateMachineRules() StateMachineRules {
+	return StateMachineRules{
+		Allowed: map[string][]string{
+			StateProductive:  {StateTakingBreak, StateObsessed, StateConfused, StateDisengaged},
+			StateTakingBreak: {StateProductive, StateDisengaged, StateConfused},
+			StateDisengaged:  {StateProductive, StateTakingBreak, StateConfused},
+			StateConfused:    {StateProductive, StateTakingBreak, StateDisengaged, StateObsessed},
+			StateObsessed:    {StateProductive, StateTakingBreak, StateConfused},
+		},
+		MinDwell: map[string]time.Duration{
+			StateTakingBreak: 15 * time.Second,
+			StateDisengaged:  60 * time.Second,
+		},
+		DefaultDwell:  30 * time.Second,
+		MinConfidence: 0.6,
+		HistorySize:   20,
+	}
+}
+
+// Dwell is how long a session must stay in state before leaving it.
+func (r StateMachineRules) Dwell(state string) time.Duration {
+	if dwell, exists := r.MinDwell[state]; exists {
+		return dwell
+	}
+	return r.DefaultDwell
+}
+
+// CanTransition reports whether from may be followed by to.
+func (r StateMachineRules) CanTransition(from, to string) bool {
+	if !IsNarratorState(to) {
+		return false
+	}
+	if from == StateNew {
+		return true
+	}
+	for _, next := range r.Allowed[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateProposal is a suggested narrator state from the analyzer or the
+// heuristic classifier.
+type StateProposal struct {
+	State      string
+	Confidence float64
+	Source     string
+	Reason     string
+}
+
+// Transition is one accepted state change.
+type Transition struct {
+	From       string    `json:"from"`
+	To         string    `json:"to"`
+	At         time.Time `json:"at"`
+	Source     string    `json:"source,omitempty"`
+	Confidence float64   `json:"confidence"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+// Decision is the outcome of a proposal. Reason explains a rejection.
+type Decision struct {
+	Accepted bool
+	From     string
+	To       string
+	Reason   string
+}
+
+// StateMachine holds a session's narrator state and the transitions that
+// led to it. It is not safe for concurrent use; SessionData guards it.
+type StateMachine struct {
+	rules   StateMachineRules
+	state   string
+	since   time.Time
+	history []Transition
+}
+
+func NewStateMachine(rules StateMachineRules, now time.Time) *StateMachine {
+	return &StateMachine{
+		rules:   rules,
+		state:   StateNew,
+		since:   now,
+		history: make([]Transition, 0),
+	}
+}
+
+func (m *StateMachine) State() string {
+	return m.state
+}
+
+// Since is when the current state was entered.
+func (m *StateMachine) Since() time.Time {
+	return m.since
+}
+
+// Propose applies p if the transition is allowed, the current state has
+// been held long enough and the proposal is confident enough.
+func (m *StateMachine) Propose(p StateProposal, now time.Time) Decision {
+	decision := Decision{From: m.state, To: p.State}
+
+	switch {
+	case p.State == m.state:
+		decision.Reason = "already in state"
+	case !IsNarratorState(p.State):
+		decision.Reason = fmt.Sprintf("unknown state %q", p.State)
+	case !m.rules.CanTransition(m.state, p.State):
+		decision.Reason = fmt.Sprintf("transition %s -> %s not allowed", m.state, p.State)
+	case m.state != StateNew && now.Sub(m.since) < m.rules.Dwell(m.state):
+		decision.Reason = fmt.Sprintf("%s held for %s of %s", m.state,
+			now.Sub(m.since).Round(time.Second), m.rules.Dwell(m.state))
+	case p.Confidence < m.rules.MinConfidence:
+		decision.Reason = fmt.Sprintf("confidence %.2f below %.2f", p.Confidence, m.rules.MinConfidence)
+	default:
+		decision.Accepted = true
+	}
+
+	if !decision.Accepted {
+		return decision
+	}
+
+	m.history = append(m.history, Transition{
+		From:       m.state,
+		To:         p.State,
+		At:         now,
+		Source:     p.Source,
+		Confidence: p.Confidence,
+		Reason:     p.Reason,
+	})
+	if m.rules.HistorySize > 0 && len(m.history) > m.rules.HistorySize {
+		m.history = m.history[len(m.history)-m.rules.HistorySize:]
+	}
+	m.state = p.State
+	m.since = now
+	return decision
+}
+
+// History returns the accepted transitions, oldest first.
+func (m *StateMachine) History() []Transition {
+	history := make([]Transition, len(m.history))
+	copy(history, m.history)
+	return history
+}
@@ -0,0 +1,78 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateMachineRules(t *testing.T) {
+	rules := DefaultStateMachineRules()
+	rules.DefaultDwell = 30 * time.Second
+	rules.MinConfidence = 0.6
+	start := time.Now()
+	m := NewStateMachine(rules, start)
+
+	if d := m.Propose(StateProposal{State: "analyzed", Confidence: 1}, start); d.Accepted {
+		t.Fatal("unknown states must be rejected")
+	}
+	if d := m.Propose(StateProposal{State: StateProductive, Confidence: 0.3}, start); d.Accepted {
+		t.Fatal("low-confidence proposals must be rejected")
+	}
+	if d := m.Propose(StateProposal{State: StateProductive, Confidence: 0.8, Source: "llm"}, start); !d.Accepted || d.From != StateNew {
+		t.Fatalf("first state should be accepted from new: %+v", d)
+	}
+
+	if d := m.Propose(StateProposal{State: StateObsessed, Confidence: 0.9}, start.Add(10*time.Second)); d.Accepted {
+		t.Fatal("state left before its dwell time")
+	}
+	if d := m.Propose(StateProposal{State: StateObsessed, Confidence: 0.9}, start.Add(31*time.Second)); !d.Accepted {
+		t.Fatalf("transition after dwell should be accepted: %+v", d)
+	}
+	if d := m.Propose(StateProposal{State: StateDisengaged, Confidence: 0.9}, start.Add(2*time.Minute)); d.Accepted {
+		t.Fatal("obsessed -> disengaged is not an allowed transition")
+	}
+
+	history := m.History()
+	if len(history) != 2 || history[1].From != StateProductive || history[1].To != StateObsessed {
+		t.Fatalf("unexpected history: %+v", history)
+	}
+}
+
+func TestStateMachineHistoryIsBounded(t *testing.T) {
+	rules := DefaultStateMachineRules()
+	rules.MinDwell = nil
+	rules.DefaultDwell = 0
+	rules.HistorySize = 3
+	now := time.Now()
+	m := NewStateMachine(rules, now)
+
+	states := []string{StateProductive, StateTakingBreak, StateProductive, StateTakingBreak, StateProductive}
+	for _, state := range states {
+		if d := m.Propose(StateProposal{State: state, Confidence: 1}, now); !d.Accepted {
+			t.Fatalf("proposal %s rejected: %s", state, d.Reason)
+		}
+	}
+
+	history := m.History()
+	if len(history) != 3 || history[0].To != StateProductive || history[2].To != StateProductive {
+		t.Fatalf("expected the last 3 transitions, got %+v", history)
+	}
+}
+
+func TestSessionProposeStateRecordsTransition(t *testing.T) {
+	session := NewSessionData("s1", 10, 50)
+	session.AddLLMResponse("hello", "")
+	if session.CurrentState != StateNew {
+		t.Fatalf("narration must not change the state, got %s", session.CurrentState)
+	}
+
+	d := session.ProposeState(StateProposal{State: StateConfused, Confidence: 0.75, Source: "heuristic"}, time.Now())
+	if !d.Accepted || session.CurrentState != StateConfused {
+		t.Fatalf("expected confused, got %+v / %s", d, session.CurrentState)
+	}
+
+	events := session.Timeline(TimelineQuery{Types: []EventType{EventStateTransition}})
+	if len(events) != 1 || events[0].ToState != StateConfused || events[0].Source != "heuristic" {
+		t.Fatalf("unexpected transition events: %+v", events)
+	}
+}
@@ -0,0 +1,157 @@
+package game
+
+import (
+	"time"
+)
+
+type EventType string
+
+const (
+	EventAction          EventType = "action"
+	EventPurchase        EventType = "purchase"
+	EventNarrator        EventType = "narrator_message"
+	EventStateTransition EventType = "state_transition"
+	EventConnect         EventType = "connect"
+	EventDisconnect      EventType = "disconnect"
+	EventPrestige        EventType = "prestige"
+)
+
+// TimelineEvent is one entry of a session's history. Only the fields that
+// belong to the event type are set.
+type TimelineEvent struct {
+	Seq        uint64    `json:"seq"`
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	ClientTime time.Time `json:"client_time,omitempty"`
+
+	Stage  int `json:"stage,omitempty"`
+	Clicks int `json:"clicks,omitempty"`
+
+	ItemID   *int   `json:"item_id,omitempty"`
+	Category string `json:"category,omitempty"`
+
+	Message   string `json:"message,omitempty"`
+	Source    string `json:"source,omitempty"`
+	State     string `json:"state,omitempty"`
+	FromState string `json:"from_state,omitempty"`
+	ToState   string `json:"to_state,omitempty"`
+
+	// Prestige is the player's prestige count after a prestige event.
+	Prestige int `json:"prestige,omitempty"`
+
+	// Variant is the session's experiment variant on purchase and
+	// narrator_message events.
+	Variant string `json:"variant,omitempty"`
+
+	// PromptVersion is the prompt template version of analyzer lines.
+	PromptVersion string `json:"prompt_version,omitempty"`
+}
+
+// TimelineQuery filters timeline reads. Zero values mean no filter; Limit
+// keeps the most recent matches.
+type TimelineQuery struct {
+	Types    []EventType
+	AfterSeq uint64
+	Limit    int
+}
+
+func (q TimelineQuery) matches(event *TimelineEvent) bool {
+	if event.Seq <= q.AfterSeq {
+		return false
+	}
+	if len(q.Types) == 0 {
+		return true
+	}
+	for _, t := range q.Types {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeline is a bounded, ordered event log. Once full, the oldest events
+// are overwritten; sequence numbers keep increasing so readers can tell how
+// much was dropped.
+type Timeline struct {
+	events  []TimelineEvent
+	head    int
+	size    int
+	nextSeq uint64
+}
+
+func NewTimeline(capacity int) *Timeline {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Timeline{
+		events:  make([]TimelineEvent, capacity),
+		nextSeq: 1,
+	}
+}
+
+// Append stamps the event with the next sequence number and stores it.
+func (tl *Timeline) Append(event TimelineEvent) TimelineEvent {
+	event.Seq = tl.nextSeq
+	tl.nextSeq++
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	idx := (tl.head + tl.size) % len(tl.events)
+	if tl.size == len(tl.events) {
+		tl.head = (tl.head + 1) % len(tl.events)
+	} else {
+		tl.size++
+	}
+	tl.events[idx] = event
+	return event
+}
+
+func (tl *Timeline) Len() int {
+	return tl.size
+}
+
+// LastSeq is the sequence number of the newest event, or 0 if empty.
+func (tl *Timeline) LastSeq() uint64 {
+	return tl.nextSeq - 1
+}
+
+func (tl *Timeline) at(i int) *TimelineEvent {
+	return &tl.events[(tl.head+i)%len(tl.events)]
+}
+
+// Query returns matching events in chronological order.
+func (tl *Timeline) Query(q TimelineQuery) []TimelineEvent {
+	matched := make([]TimelineEvent, 0)
+	for i := tl.size - 1; i >= 0; i-- {
+		event := tl.at(i)
+		if event.Seq <= q.AfterSeq {
+			break
+		}
+		if !q.matches(event) {
+			continue
+		}
+		matched = append(matched, *event)
+		if q.Limit > 0 && len(matched) == q.Limit {
+			break
+		}
+	}
+
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched
+}
+
+// LastBefore finds the most recent event of the given type that happened
+// before seq, e.g. the narrator line that preceded a purchase.
+func (tl *Timeline) LastBefore(seq uint64, eventType EventType) (TimelineEvent, bool) {
+	for i := tl.size - 1; i >= 0; i-- {
+		event := tl.at(i)
+		if event.Seq < seq && event.Type == eventType {
+			return *event, true
+		}
+	}
+	return TimelineEvent{}, false
+}
@@ -0,0 +1,58 @@
+package game
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimelineDropsOldestWhenFull(t *testing.T) {
+	tl := NewTimeline(3)
+	for i := 1; i <= 5; i++ {
+		tl.Append(TimelineEvent{Type: EventAction, Stage: i})
+	}
+
+	events := tl.Query(TimelineQuery{})
+	if len(events) != 3 || tl.LastSeq() != 5 {
+		t.Fatalf("got %d events, last seq %d", len(events), tl.LastSeq())
+	}
+	if events[0].Seq != 3 || events[0].Stage != 3 || events[2].Seq != 5 {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+
+	after := tl.Query(TimelineQuery{AfterSeq: 4})
+	if len(after) != 1 || after[0].Seq != 5 {
+		t.Fatalf("after query = %+v", after)
+	}
+}
+
+func TestSessionTimelineOrdersNarrationAndPurchases(t *testing.T) {
+	session := NewSessionData("s1", 10, 50)
+	session.RecordConnect()
+	session.UpdateState(10, 20, time.Now())
+	session.AddLLMResponse("你还在这里。", "v1")
+	session.UpdateState(20, 40, time.Now())
+	session.AddPurchase(1)
+
+	purchases := session.Timeline(TimelineQuery{Types: []EventType{EventPurchase}})
+	if len(purchases) != 1 || purchases[0].ItemID == nil || *purchases[0].ItemID != 1 {
+		t.Fatalf("unexpected purchases: %+v", purchases)
+	}
+
+	narration, ok := session.LastEventBefore(purchases[0].Seq, EventNarrator)
+	if !ok || narration.Message != "你还在这里。" || narration.PromptVersion != "v1" {
+		t.Fatalf("expected the narrator line before the purchase, got %+v", narration)
+	}
+
+	actions := session.GetRecentActions(5)
+	if len(actions) != 2 || actions[1].Stage != 20 || actions[1].ServerTimestamp.IsZero() {
+		t.Fatalf("unexpected actions: %+v", actions)
+	}
+	if session.PurchaseCount() != 1 || session.NarratorMessageCount() != 1 {
+		t.Fatal("counters should survive independently of the timeline")
+	}
+
+	session.AddNarration("prestige", "又从头开始了。", "prestige")
+	if recent := session.RecentNarrations(5); len(recent) != 2 || recent[0] != "你还在这里。" || recent[1] != "又从头开始了。" {
+		t.Fatalf("unexpected recent narrations: %q", recent)
+	}
+}
@@ -6,7 +6,6 @@ require (
 	github.com/gin-gonic/gin v1.10.0
 	github.com/go-playground/validator/v10 v10.22.1
 	github.com/gorilla/websocket v1.5.3
-	github.com/sashabaranov/go-openai v1.20.4
 	github.com/stretchr/testify v1.9.0
 )
 
@@ -0,0 +1,194 @@
+package idle
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+)
+
+// Nudge asks the narrator to speak to a session that has gone quiet.
+// Count is 1 for the first nudge of an idle streak.
+type Nudge struct {
+	SessionID string        `json:"session_id"`
+	PlayerID  string        `json:"player_id"`
+	IdleFor   time.Duration `json:"idle_for"`
+	Count     int           `json:"count"`
+	Time      time.Time     `json:"time"`
+}
+
+type sessionIdle struct {
+	playerID     string
+	lastActiveAt time.Time
+	nudges       int
+	nextAt       time.Time
+}
+
+// Watcher notices sessions that have stopped sending actions. The first
+// nudge comes after IdleFirst; every further one waits IdleBackoff times
+// longer than the previous gap, up to IdleMaxInterval, and a streak stops
+// after IdleMaxNudges. Any action or purchase starts the streak over.
+type Watcher struct {
+	cfg      *config.Config
+	nudges   chan *Nudge
+	stopChan chan struct{}
+	ticker   *time.Ticker
+	running  bool
+	mu       sync.RWMutex
+
+	sessions   map[string]*sessionIdle
+	sessionsMu sync.Mutex
+}
+
+func NewWatcher(cfg *config.Config) *Watcher {
+	return &Watcher{
+		cfg:      cfg,
+		nudges:   make(chan *Nudge, 256),
+		stopChan: make(chan struct{}),
+		sessions: make(map[string]*sessionIdle),
+	}
+}
+
+// Subscribe registers the watcher's event handlers. They only touch watcher
+// state, so they are safe to run synchronously under the hub's locks.
+func (w *Watcher) Subscribe(d *events.Dispatcher) {
+	events.Subscribe(d, "idle", w.onSessionCreated)
+	events.Subscribe(d, "idle", w.onActionApplied)
+	events.Subscribe(d, "idle", w.onPurchaseApplied)
+	events.Subscribe(d, "idle", w.onSessionEnded)
+}
+
+func (w *Watcher) Start() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.running || !w.cfg.IdleEnabled {
+		return
+	}
+
+	w.running = true
+	w.ticker = time.NewTicker(w.cfg.IdleTickInterval)
+
+	go w.tickWorker()
+
+	log.Printf("Idle watcher started, first nudge after %s", w.cfg.IdleFirst)
+}
+
+func (w *Watcher) Stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.running {
+		return
+	}
+
+	w.running = false
+	if w.ticker != nil {
+		w.ticker.Stop()
+	}
+	close(w.stopChan)
+
+	log.Println("Idle watcher stopped")
+}
+
+// Nudges emits sessions that are due a nudge.
+func (w *Watcher) Nudges() <-chan *Nudge {
+	return w.nudges
+}
+
+func (w *Watcher) tickWorker() {
+	for {
+		select {
+		case <-w.ticker.C:
+			w.tick(time.Now())
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+func (w *Watcher) tick(now time.Time) {
+	w.sessionsMu.Lock()
+	defer w.sessionsMu.Unlock()
+
+	for sessionID, s := range w.sessions {
+		if now.Before(s.nextAt) {
+			continue
+		}
+		if w.cfg.IdleMaxNudges > 0 && s.nudges >= w.cfg.IdleMaxNudges {
+			continue
+		}
+
+		s.nudges++
+		s.nextAt = now.Add(w.interval(s.nudges))
+		w.emit(&Nudge{
+			SessionID: sessionID,
+			PlayerID:  s.playerID,
+			IdleFor:   now.Sub(s.lastActiveAt),
+			Count:     s.nudges,
+			Time:      now,
+		})
+	}
+}
+
+// interval is the gap after the given number of nudges.
+func (w *Watcher) interval(nudges int) time.Duration {
+	gap := w.cfg.IdleFirst
+	for i := 0; i < nudges; i++ {
+		gap = time.Duration(float64(gap) * w.cfg.IdleBackoff)
+		if gap >= w.cfg.IdleMaxInterval {
+			return w.cfg.IdleMaxInterval
+		}
+	}
+	return gap
+}
+
+func (w *Watcher) onSessionCreated(e events.SessionCreated) {
+	w.sessionsMu.Lock()
+	defer w.sessionsMu.Unlock()
+
+	w.sessions[e.SessionID] = &sessionIdle{playerID: e.PlayerID}
+	w.touch(e.SessionID, e.Time)
+}
+
+func (w *Watcher) onSessionEnded(e events.SessionEnded) {
+	w.sessionsMu.Lock()
+	defer w.sessionsMu.Unlock()
+
+	delete(w.sessions, e.SessionID)
+}
+
+func (w *Watcher) onActionApplied(e events.ActionApplied) {
+	w.sessionsMu.Lock()
+	defer w.sessionsMu.Unlock()
+
+	w.touch(e.SessionID, e.Time)
+}
+
+func (w *Watcher) onPurchaseApplied(e events.PurchaseApplied) {
+	w.sessionsMu.Lock()
+	defer w.sessionsMu.Unlock()
+
+	w.touch(e.SessionID, e.Time)
+}
+
+// touch starts a new idle streak. Callers hold sessionsMu.
+func (w *Watcher) touch(sessionID string, at time.Time) {
+	s, exists := w.sessions[sessionID]
+	if !exists {
+		return
+	}
+	s.lastActiveAt = at
+	s.nudges = 0
+	s.nextAt = at.Add(w.cfg.IdleFirst)
+}
+
+func (w *Watcher) emit(nudge *Nudge) {
+	select {
+	case w.nudges <- nudge:
+	default:
+		log.Printf("Idle nudge channel full, dropping nudge for session %s", nudge.SessionID)
+	}
+}
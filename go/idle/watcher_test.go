@@ -0,0 +1,84 @@
+package idle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+)
+
+func testWatcher() *Watcher {
+	return NewWatcher(&config.Config{
+		IdleEnabled:      true,
+		IdleFirst:        30 * time.Second,
+		IdleBackoff:      2,
+		IdleMaxInterval:  90 * time.Second,
+		IdleMaxNudges:    4,
+		IdleTickInterval: time.Second,
+	})
+}
+
+func drain(w *Watcher) []*Nudge {
+	var nudges []*Nudge
+	for len(w.Nudges()) > 0 {
+		nudges = append(nudges, <-w.Nudges())
+	}
+	return nudges
+}
+
+func TestNudgesEscalateAndStop(t *testing.T) {
+	w := testWatcher()
+	start := time.Now()
+	w.onSessionCreated(events.SessionCreated{SessionID: "s1", PlayerID: "p1", Time: start})
+
+	// Gaps are 30s, then 60s, then capped at 90s; the fifth nudge never comes.
+	var at []time.Duration
+	for sec := 0; sec <= 600; sec++ {
+		now := start.Add(time.Duration(sec) * time.Second)
+		w.tick(now)
+		for _, n := range drain(w) {
+			if n.IdleFor != now.Sub(start) {
+				t.Fatalf("idle_for = %s, want %s", n.IdleFor, now.Sub(start))
+			}
+			at = append(at, n.IdleFor)
+		}
+	}
+
+	want := []time.Duration{30 * time.Second, 90 * time.Second, 180 * time.Second, 270 * time.Second}
+	if len(at) != len(want) {
+		t.Fatalf("nudged at %v, want %v", at, want)
+	}
+	for i := range want {
+		if at[i] != want[i] {
+			t.Fatalf("nudged at %v, want %v", at, want)
+		}
+	}
+}
+
+func TestActivityResetsStreak(t *testing.T) {
+	w := testWatcher()
+	start := time.Now()
+	w.onSessionCreated(events.SessionCreated{SessionID: "s1", PlayerID: "p1", Time: start})
+
+	w.tick(start.Add(31 * time.Second))
+	if n := drain(w); len(n) != 1 || n[0].Count != 1 {
+		t.Fatalf("expected the first nudge, got %+v", n)
+	}
+
+	w.onActionApplied(events.ActionApplied{SessionID: "s1", Time: start.Add(40 * time.Second)})
+	w.tick(start.Add(60 * time.Second))
+	if n := drain(w); len(n) != 0 {
+		t.Fatalf("an action should restart the streak, got %+v", n)
+	}
+	w.tick(start.Add(71 * time.Second))
+	if n := drain(w); len(n) != 1 || n[0].Count != 1 {
+		t.Fatalf("expected a fresh first nudge, got %+v", n)
+	}
+
+	w.onSessionEnded(events.SessionEnded{SessionID: "s1"})
+	w.tick(start.Add(time.Hour))
+	if n := drain(w); len(n) != 0 {
+		t.Fatalf("ended sessions must not be nudged, got %+v", n)
+	}
+}
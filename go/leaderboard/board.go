@@ -0,0 +1,224 @@
+package leaderboard
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+type Entry struct {
+	PlayerID  string    `json:"player_id"`
+	Score     int       `json:"score"`
+	Stage     int       `json:"stage"`
+	Clicks    int       `json:"clicks"`
+	Rank      int       `json:"rank"`
+	ReachedAt time.Time `json:"reached_at"`
+}
+
+// Board keeps players ordered by best score. Ties share the same rank
+// (competition ranking); inside a tie the player who reached the score
+// first is listed first.
+type Board struct {
+	entries []*Entry
+	index   map[string]*Entry
+	mu      sync.RWMutex
+}
+
+func NewBoard() *Board {
+	return &Board{
+		entries: make([]*Entry, 0),
+		index:   make(map[string]*Entry),
+	}
+}
+
+// Submit records a score for a player. Only improvements are kept; it
+// returns true when the player's best score changed.
+func (b *Board) Submit(playerID string, score, stage, clicks int, at time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	existing, ok := b.index[playerID]
+	if ok {
+		if score <= existing.Score {
+			return false
+		}
+		b.removeAt(b.position(existing))
+	}
+
+	entry := &Entry{
+		PlayerID:  playerID,
+		Score:     score,
+		Stage:     stage,
+		Clicks:    clicks,
+		ReachedAt: at,
+	}
+	b.insert(entry)
+	b.index[playerID] = entry
+	return true
+}
+
+func (b *Board) Remove(playerID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	entry, ok := b.index[playerID]
+	if !ok {
+		return
+	}
+	b.removeAt(b.position(entry))
+	delete(b.index, playerID)
+}
+
+// Trim drops the lowest entries so that at most max players remain.
+func (b *Board) Trim(max int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if max <= 0 || len(b.entries) <= max {
+		return 0
+	}
+
+	dropped := b.entries[max:]
+	for _, entry := range dropped {
+		delete(b.index, entry.PlayerID)
+	}
+	b.entries = b.entries[:max]
+	return len(dropped)
+}
+
+func (b *Board) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries = make([]*Entry, 0)
+	b.index = make(map[string]*Entry)
+}
+
+func (b *Board) Len() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.entries)
+}
+
+// Rank returns the 1-based rank of a player, or 0 if the player is unranked.
+func (b *Board) Rank(playerID string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.index[playerID]
+	if !ok {
+		return 0
+	}
+	return b.rankOfScore(entry.Score)
+}
+
+func (b *Board) Get(playerID string) (Entry, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.index[playerID]
+	if !ok {
+		return Entry{}, false
+	}
+	result := *entry
+	result.Rank = b.rankOfScore(entry.Score)
+	return result, true
+}
+
+// Top returns up to limit entries starting from the first place.
+func (b *Board) Top(limit int) []Entry {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	return b.slice(0, limit)
+}
+
+// Around returns the player's entry together with up to radius entries
+// above and below it. The bool is false if the player is unranked.
+func (b *Board) Around(playerID string, radius int) ([]Entry, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	entry, ok := b.index[playerID]
+	if !ok {
+		return nil, false
+	}
+
+	pos := b.position(entry)
+	start := pos - radius
+	if start < 0 {
+		start = 0
+	}
+	return b.slice(start, pos+radius+1-start), true
+}
+
+func (b *Board) slice(start, limit int) []Entry {
+	if limit <= 0 || start >= len(b.entries) {
+		return []Entry{}
+	}
+
+	end := start + limit
+	if end > len(b.entries) {
+		end = len(b.entries)
+	}
+
+	result := make([]Entry, 0, end-start)
+	rank := b.rankOfScore(b.entries[start].Score)
+	for i := start; i < end; i++ {
+		entry := *b.entries[i]
+		if i > start && entry.Score != b.entries[i-1].Score {
+			rank = i + 1
+		}
+		entry.Rank = rank
+		result = append(result, entry)
+	}
+	return result
+}
+
+// rankOfScore is one plus the number of players with a strictly higher score.
+func (b *Board) rankOfScore(score int) int {
+	return sort.Search(len(b.entries), func(i int) bool {
+		return b.entries[i].Score <= score
+	}) + 1
+}
+
+// position finds the slice index of an entry already on the board.
+func (b *Board) position(entry *Entry) int {
+	i := sort.Search(len(b.entries), func(i int) bool {
+		return !ranksBefore(b.entries[i], entry)
+	})
+	for ; i < len(b.entries); i++ {
+		if b.entries[i] == entry {
+			return i
+		}
+	}
+	return -1
+}
+
+func (b *Board) insert(entry *Entry) {
+	i := sort.Search(len(b.entries), func(i int) bool {
+		return ranksBefore(entry, b.entries[i])
+	})
+	b.entries = append(b.entries, nil)
+	copy(b.entries[i+1:], b.entries[i:])
+	b.entries[i] = entry
+}
+
+func (b *Board) removeAt(i int) {
+	if i < 0 {
+		return
+	}
+	copy(b.entries[i:], b.entries[i+1:])
+	b.entries[len(b.entries)-1] = nil
+	b.entries = b.entries[:len(b.entries)-1]
+}
+
+func ranksBefore(a, b *Entry) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	if !a.ReachedAt.Equal(b.ReachedAt) {
+		return a.ReachedAt.Before(b.ReachedAt)
+	}
+	return a.PlayerID < b.PlayerID
+}
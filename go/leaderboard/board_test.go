@@ -0,0 +1,73 @@
+package leaderboard
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBoardRanksTiesTogether(t *testing.T) {
+	b := NewBoard()
+	base := time.Now()
+
+	b.Submit("alice", 300, 300, 900, base)
+	b.Submit("bob", 500, 500, 1200, base.Add(time.Second))
+	b.Submit("carol", 300, 300, 800, base.Add(2*time.Second))
+	b.Submit("dave", 100, 100, 100, base.Add(3*time.Second))
+
+	top := b.Top(10)
+	wantOrder := []string{"bob", "alice", "carol", "dave"}
+	wantRanks := []int{1, 2, 2, 4}
+	for i, entry := range top {
+		if entry.PlayerID != wantOrder[i] || entry.Rank != wantRanks[i] {
+			t.Fatalf("position %d: got %s rank %d, want %s rank %d",
+				i, entry.PlayerID, entry.Rank, wantOrder[i], wantRanks[i])
+		}
+	}
+
+	if rank := b.Rank("carol"); rank != 2 {
+		t.Fatalf("carol rank = %d, want 2", rank)
+	}
+}
+
+func TestBoardKeepsBestScore(t *testing.T) {
+	b := NewBoard()
+
+	if !b.Submit("alice", 200, 200, 200, time.Now()) {
+		t.Fatal("first submission should be recorded")
+	}
+	if b.Submit("alice", 150, 150, 300, time.Now()) {
+		t.Fatal("lower score should not replace the best score")
+	}
+
+	entry, ok := b.Get("alice")
+	if !ok || entry.Score != 200 {
+		t.Fatalf("got %+v, want score 200", entry)
+	}
+}
+
+func TestBoardAroundAndTrim(t *testing.T) {
+	b := NewBoard()
+	base := time.Now()
+	players := []string{"p1", "p2", "p3", "p4", "p5", "p6"}
+	for i, id := range players {
+		b.Submit(id, 1000-i*100, 0, 0, base)
+	}
+
+	around, ok := b.Around("p4", 1)
+	if !ok || len(around) != 3 {
+		t.Fatalf("around = %+v, want 3 entries", around)
+	}
+	if around[0].PlayerID != "p3" || around[1].Rank != 4 || around[2].PlayerID != "p5" {
+		t.Fatalf("unexpected neighbourhood: %+v", around)
+	}
+
+	if dropped := b.Trim(4); dropped != 2 {
+		t.Fatalf("trim dropped %d, want 2", dropped)
+	}
+	if _, ok := b.Get("p6"); ok {
+		t.Fatal("p6 should have been trimmed")
+	}
+	if b.Len() != 4 {
+		t.Fatalf("len = %d, want 4", b.Len())
+	}
+}
@@ -0,0 +1,477 @@
+package leaderboard
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+)
+
+type RankChange struct {
+	Window       string    `json:"window"`
+	PlayerID     string    `json:"player_id"`
+	Rank         int       `json:"rank"`
+	PreviousRank int       `json:"previous_rank"`
+	Score        int       `json:"score"`
+	TotalPlayers int       `json:"total_players"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+type Snapshot struct {
+	Window       string    `json:"window"`
+	PeriodStart  time.Time `json:"period_start,omitempty"`
+	PeriodEnd    time.Time `json:"period_end,omitempty"`
+	Entries      []Entry   `json:"entries"`
+	TotalPlayers int       `json:"total_players"`
+	TakenAt      time.Time `json:"taken_at"`
+}
+
+type WindowInfo struct {
+	Name        string    `json:"name"`
+	Schedule    Schedule  `json:"schedule"`
+	PeriodStart time.Time `json:"period_start,omitempty"`
+	PeriodEnd   time.Time `json:"period_end,omitempty"`
+	Active      bool      `json:"active"`
+	Closed      bool      `json:"closed"`
+	Players     int       `json:"players"`
+	Archived    int       `json:"archived_periods"`
+}
+
+type windowState struct {
+	window   Window
+	board    *Board
+	start    time.Time
+	end      time.Time
+	closed   bool
+	snapshot *Snapshot
+	history  []Standings
+}
+
+func (ws *windowState) active(now time.Time) bool {
+	if ws.closed || now.Before(ws.start) {
+		return false
+	}
+	return ws.end.IsZero() || now.Before(ws.end)
+}
+
+// Service ranks players by their best validated stage across a set of
+// windows: the all-time board plus any daily, weekly or event boards. Reads
+// for the top of a board are served from a periodic snapshot, and rank
+// changes for connected players are computed once per snapshot tick instead
+// of on every submission so that a burst of actions produces at most one
+// update each.
+type Service struct {
+	cfg        *config.Config
+	updateChan chan *RankChange
+	stopChan   chan struct{}
+	ticker     *time.Ticker
+	running    bool
+	mu         sync.RWMutex
+
+	windows   map[string]*windowState
+	order     []string
+	windowsMu sync.RWMutex
+
+	// tracked holds the last rank pushed to each connected player per window.
+	tracked   map[string]map[string]int
+	trackedMu sync.Mutex
+}
+
+func NewService(cfg *config.Config) (*Service, error) {
+	s := &Service{
+		cfg:        cfg,
+		updateChan: make(chan *RankChange, 256),
+		stopChan:   make(chan struct{}),
+		windows:    make(map[string]*windowState),
+		tracked:    make(map[string]map[string]int),
+	}
+
+	windows, err := windowsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, window := range windows {
+		if err := s.AddWindow(window); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func windowsFromConfig(cfg *config.Config) ([]Window, error) {
+	loc, err := time.LoadLocation(cfg.LeaderboardTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid leaderboard timezone: %w", err)
+	}
+
+	windows := []Window{{Name: AllTimeWindow, Schedule: ScheduleAllTime}}
+	for _, name := range strings.Split(cfg.LeaderboardWindows, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		windows = append(windows, Window{
+			Name:       name,
+			Schedule:   Schedule(name),
+			ResetHour:  cfg.LeaderboardResetHour,
+			ResetDay:   time.Weekday(cfg.LeaderboardWeeklyResetDay),
+			ArchiveTop: cfg.LeaderboardArchiveTop,
+			Location:   loc,
+		})
+	}
+
+	events, err := ParseEventWindows(cfg.LeaderboardEvents, cfg.LeaderboardArchiveTop)
+	if err != nil {
+		return nil, err
+	}
+	return append(windows, events...), nil
+}
+
+// AddWindow registers a new ranking window. Event windows can be added
+// while the service is running.
+func (s *Service) AddWindow(window Window) error {
+	if err := window.validate(); err != nil {
+		return err
+	}
+
+	s.windowsMu.Lock()
+	defer s.windowsMu.Unlock()
+
+	if _, exists := s.windows[window.Name]; exists {
+		return fmt.Errorf("window %s already exists", window.Name)
+	}
+
+	start, end := window.Period(time.Now())
+	ws := &windowState{
+		window:  window,
+		board:   NewBoard(),
+		start:   start,
+		end:     end,
+		history: make([]Standings, 0),
+	}
+	ws.snapshot = s.buildSnapshot(ws)
+	s.windows[window.Name] = ws
+	s.order = append(s.order, window.Name)
+	return nil
+}
+
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.ticker = time.NewTicker(s.cfg.LeaderboardSnapshotInterval)
+
+	go s.snapshotWorker()
+
+	log.Printf("Leaderboard service started with %d windows and %s snapshot interval",
+		len(s.order), s.cfg.LeaderboardSnapshotInterval)
+}
+
+func (s *Service) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return
+	}
+
+	s.running = false
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	close(s.stopChan)
+
+	log.Println("Leaderboard service stopped")
+}
+
+// Updates delivers rank changes for tracked players.
+func (s *Service) Updates() <-chan *RankChange {
+	return s.updateChan
+}
+
+// Submit records progress reported by a validated user_action on every
+// window that is currently open.
+func (s *Service) Submit(playerID string, stage, clicks int) {
+	if playerID == "" {
+		return
+	}
+
+	now := time.Now()
+	s.windowsMu.RLock()
+	defer s.windowsMu.RUnlock()
+
+	for _, ws := range s.windows {
+		if ws.active(now) {
+			ws.board.Submit(playerID, stage, stage, clicks, now)
+		}
+	}
+}
+
+// Track starts pushing rank changes for a connected player.
+func (s *Service) Track(playerID string) {
+	ranks := make(map[string]int)
+	s.windowsMu.RLock()
+	for name, ws := range s.windows {
+		ranks[name] = ws.board.Rank(playerID)
+	}
+	s.windowsMu.RUnlock()
+
+	s.trackedMu.Lock()
+	s.tracked[playerID] = ranks
+	s.trackedMu.Unlock()
+}
+
+func (s *Service) Untrack(playerID string) {
+	s.trackedMu.Lock()
+	defer s.trackedMu.Unlock()
+
+	delete(s.tracked, playerID)
+}
+
+func (s *Service) Windows() []WindowInfo {
+	now := time.Now()
+	s.windowsMu.RLock()
+	defer s.windowsMu.RUnlock()
+
+	infos := make([]WindowInfo, 0, len(s.order))
+	for _, name := range s.order {
+		ws := s.windows[name]
+		infos = append(infos, WindowInfo{
+			Name:        name,
+			Schedule:    ws.window.Schedule,
+			PeriodStart: ws.start,
+			PeriodEnd:   ws.end,
+			Active:      ws.active(now),
+			Closed:      ws.closed,
+			Players:     ws.board.Len(),
+			Archived:    len(ws.history),
+		})
+	}
+	return infos
+}
+
+// Top returns the first limit entries of a window. Requests that fit into
+// the snapshot are answered from it without touching the live board. A
+// negative limit returns no entries.
+func (s *Service) Top(window string, limit int) (*Snapshot, bool) {
+	if limit < 0 {
+		limit = 0
+	}
+
+	s.windowsMu.RLock()
+	defer s.windowsMu.RUnlock()
+
+	ws, ok := s.windows[window]
+	if !ok {
+		return nil, false
+	}
+
+	snap := ws.snapshot
+	if limit <= len(snap.Entries) || len(snap.Entries) < s.cfg.LeaderboardSnapshotSize {
+		if limit > len(snap.Entries) {
+			limit = len(snap.Entries)
+		}
+		result := *snap
+		result.Entries = snap.Entries[:limit]
+		return &result, true
+	}
+
+	result := *snap
+	result.Entries = ws.board.Top(limit)
+	result.TotalPlayers = ws.board.Len()
+	result.TakenAt = time.Now()
+	return &result, true
+}
+
+func (s *Service) Around(window, playerID string, radius int) ([]Entry, bool) {
+	board, ok := s.board(window)
+	if !ok {
+		return nil, false
+	}
+	return board.Around(playerID, radius)
+}
+
+func (s *Service) GetEntry(window, playerID string) (Entry, bool) {
+	board, ok := s.board(window)
+	if !ok {
+		return Entry{}, false
+	}
+	return board.Get(playerID)
+}
+
+// History returns the archived standings of a window, most recent first.
+func (s *Service) History(window string, limit int) ([]Standings, bool) {
+	s.windowsMu.RLock()
+	defer s.windowsMu.RUnlock()
+
+	ws, ok := s.windows[window]
+	if !ok {
+		return nil, false
+	}
+
+	history := make([]Standings, 0, len(ws.history))
+	for i := len(ws.history) - 1; i >= 0 && len(history) < limit; i-- {
+		history = append(history, ws.history[i])
+	}
+	return history, true
+}
+
+func (s *Service) board(window string) (*Board, bool) {
+	s.windowsMu.RLock()
+	defer s.windowsMu.RUnlock()
+
+	ws, ok := s.windows[window]
+	if !ok {
+		return nil, false
+	}
+	return ws.board, true
+}
+
+func (s *Service) snapshotWorker() {
+	for {
+		select {
+		case now := <-s.ticker.C:
+			s.rollover(now)
+			s.refreshSnapshots()
+			s.publishRankChanges()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// rollover archives and resets every window whose period has ended.
+func (s *Service) rollover(now time.Time) {
+	s.windowsMu.Lock()
+	defer s.windowsMu.Unlock()
+
+	for _, name := range s.order {
+		ws := s.windows[name]
+		if ws.closed || ws.end.IsZero() || now.Before(ws.end) {
+			continue
+		}
+
+		s.archive(ws, now)
+		ws.board.Reset()
+		s.forgetRanks(name)
+
+		if ws.window.Schedule == ScheduleEvent {
+			ws.closed = true
+			log.Printf("Leaderboard event window %s closed", name)
+			continue
+		}
+
+		ws.start, ws.end = ws.window.Period(now)
+		log.Printf("Leaderboard window %s reset, next reset at %s", name, ws.end.Format(time.RFC3339))
+	}
+}
+
+func (s *Service) archive(ws *windowState, now time.Time) {
+	entries := ws.board.Top(ws.window.ArchiveTop)
+	winners := make([]Entry, 0)
+	for _, entry := range entries {
+		if entry.Rank == 1 {
+			winners = append(winners, entry)
+		}
+	}
+
+	ws.history = append(ws.history, Standings{
+		Window:      ws.window.Name,
+		PeriodStart: ws.start,
+		PeriodEnd:   ws.end,
+		Winners:     winners,
+		Entries:     entries,
+		Players:     ws.board.Len(),
+		ClosedAt:    now,
+	})
+
+	if len(ws.history) > s.cfg.LeaderboardArchiveSize {
+		ws.history = ws.history[len(ws.history)-s.cfg.LeaderboardArchiveSize:]
+	}
+}
+
+func (s *Service) forgetRanks(window string) {
+	s.trackedMu.Lock()
+	defer s.trackedMu.Unlock()
+
+	for _, ranks := range s.tracked {
+		ranks[window] = 0
+	}
+}
+
+func (s *Service) refreshSnapshots() {
+	s.windowsMu.Lock()
+	defer s.windowsMu.Unlock()
+
+	for _, ws := range s.windows {
+		if dropped := ws.board.Trim(s.cfg.LeaderboardMaxEntries); dropped > 0 {
+			log.Printf("Leaderboard window %s trimmed %d lowest entries", ws.window.Name, dropped)
+		}
+		ws.snapshot = s.buildSnapshot(ws)
+	}
+}
+
+func (s *Service) buildSnapshot(ws *windowState) *Snapshot {
+	return &Snapshot{
+		Window:       ws.window.Name,
+		PeriodStart:  ws.start,
+		PeriodEnd:    ws.end,
+		Entries:      ws.board.Top(s.cfg.LeaderboardSnapshotSize),
+		TotalPlayers: ws.board.Len(),
+		TakenAt:      time.Now(),
+	}
+}
+
+func (s *Service) publishRankChanges() {
+	s.windowsMu.RLock()
+	defer s.windowsMu.RUnlock()
+	s.trackedMu.Lock()
+	defer s.trackedMu.Unlock()
+
+	players := make([]string, 0, len(s.tracked))
+	for playerID := range s.tracked {
+		players = append(players, playerID)
+	}
+	sort.Strings(players)
+
+	now := time.Now()
+	for _, name := range s.order {
+		ws := s.windows[name]
+		total := ws.board.Len()
+
+		for _, playerID := range players {
+			ranks := s.tracked[playerID]
+			entry, ok := ws.board.Get(playerID)
+			if !ok || entry.Rank == ranks[name] {
+				continue
+			}
+
+			change := &RankChange{
+				Window:       name,
+				PlayerID:     playerID,
+				Rank:         entry.Rank,
+				PreviousRank: ranks[name],
+				Score:        entry.Score,
+				TotalPlayers: total,
+				Timestamp:    now,
+			}
+
+			select {
+			case s.updateChan <- change:
+				ranks[name] = entry.Rank
+			default:
+				log.Printf("Leaderboard update channel full, deferring rank change for %s", playerID)
+			}
+		}
+	}
+}
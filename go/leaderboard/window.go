@@ -0,0 +1,135 @@
+package leaderboard
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Schedule string
+
+const (
+	ScheduleAllTime Schedule = "all_time"
+	ScheduleDaily   Schedule = "daily"
+	ScheduleWeekly  Schedule = "weekly"
+	ScheduleEvent   Schedule = "event"
+)
+
+const AllTimeWindow = "all_time"
+
+// Window describes one ranking period. Daily and weekly windows reset on
+// their schedule; event windows run once between Start and End.
+type Window struct {
+	Name       string         `json:"name"`
+	Schedule   Schedule       `json:"schedule"`
+	Start      time.Time      `json:"start,omitempty"`
+	End        time.Time      `json:"end,omitempty"`
+	ResetHour  int            `json:"reset_hour"`
+	ResetDay   time.Weekday   `json:"reset_day"`
+	ArchiveTop int            `json:"archive_top"`
+	Location   *time.Location `json:"-"`
+}
+
+// Standings are the final results of a closed window period.
+type Standings struct {
+	Window      string    `json:"window"`
+	PeriodStart time.Time `json:"period_start"`
+	PeriodEnd   time.Time `json:"period_end"`
+	Winners     []Entry   `json:"winners"`
+	Entries     []Entry   `json:"entries"`
+	Players     int       `json:"players"`
+	ClosedAt    time.Time `json:"closed_at"`
+}
+
+// Period returns the bounds of the period containing now. A zero end means
+// the window never closes.
+func (w *Window) Period(now time.Time) (time.Time, time.Time) {
+	loc := w.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	local := now.In(loc)
+
+	switch w.Schedule {
+	case ScheduleDaily:
+		start := time.Date(local.Year(), local.Month(), local.Day(), w.ResetHour, 0, 0, 0, loc)
+		if local.Before(start) {
+			start = start.AddDate(0, 0, -1)
+		}
+		return start, start.AddDate(0, 0, 1)
+	case ScheduleWeekly:
+		start := time.Date(local.Year(), local.Month(), local.Day(), w.ResetHour, 0, 0, 0, loc)
+		offset := (int(local.Weekday()) - int(w.ResetDay) + 7) % 7
+		start = start.AddDate(0, 0, -offset)
+		if local.Before(start) {
+			start = start.AddDate(0, 0, -7)
+		}
+		return start, start.AddDate(0, 0, 7)
+	case ScheduleEvent:
+		return w.Start, w.End
+	default:
+		return time.Time{}, time.Time{}
+	}
+}
+
+func (w *Window) validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("window name is required")
+	}
+	switch w.Schedule {
+	case ScheduleAllTime, ScheduleDaily, ScheduleWeekly:
+	case ScheduleEvent:
+		if w.Start.IsZero() || !w.End.After(w.Start) {
+			return fmt.Errorf("event window %s needs a start before its end", w.Name)
+		}
+	default:
+		return fmt.Errorf("unknown schedule %q for window %s", w.Schedule, w.Name)
+	}
+	if w.ResetHour < 0 || w.ResetHour > 23 {
+		return fmt.Errorf("reset hour for window %s must be between 0 and 23", w.Name)
+	}
+	return nil
+}
+
+// ParseEventWindows reads event windows from the compact form
+// "name=2026-10-25T00:00:00Z/2026-11-01T00:00:00Z;other=...".
+func ParseEventWindows(spec string, archiveTop int) ([]Window, error) {
+	windows := make([]Window, 0)
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		name, bounds, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid event window %q: expected name=start/end", part)
+		}
+		startRaw, endRaw, ok := strings.Cut(bounds, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid event window %q: expected start/end", part)
+		}
+
+		start, err := time.Parse(time.RFC3339, strings.TrimSpace(startRaw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid start for event window %s: %w", name, err)
+		}
+		end, err := time.Parse(time.RFC3339, strings.TrimSpace(endRaw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid end for event window %s: %w", name, err)
+		}
+
+		window := Window{
+			Name:       strings.TrimSpace(name),
+			Schedule:   ScheduleEvent,
+			Start:      start,
+			End:        end,
+			ArchiveTop: archiveTop,
+		}
+		if err := window.validate(); err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
@@ -0,0 +1,97 @@
+package leaderboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+)
+
+func TestWindowPeriod(t *testing.T) {
+	now := time.Date(2026, 10, 15, 3, 30, 0, 0, time.UTC) // Thursday
+
+	daily := Window{Name: "daily", Schedule: ScheduleDaily, ResetHour: 4}
+	start, end := daily.Period(now)
+	if !start.Equal(time.Date(2026, 10, 14, 4, 0, 0, 0, time.UTC)) || !end.Equal(start.AddDate(0, 0, 1)) {
+		t.Fatalf("daily period = %s..%s", start, end)
+	}
+
+	weekly := Window{Name: "weekly", Schedule: ScheduleWeekly, ResetDay: time.Monday}
+	start, end = weekly.Period(now)
+	if !start.Equal(time.Date(2026, 10, 12, 0, 0, 0, 0, time.UTC)) || !end.Equal(start.AddDate(0, 0, 7)) {
+		t.Fatalf("weekly period = %s..%s", start, end)
+	}
+}
+
+func TestParseEventWindows(t *testing.T) {
+	windows, err := ParseEventWindows("halloween=2026-10-25T00:00:00Z/2026-11-01T00:00:00Z", 5)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(windows) != 1 || windows[0].Name != "halloween" || windows[0].Schedule != ScheduleEvent {
+		t.Fatalf("unexpected windows: %+v", windows)
+	}
+
+	if _, err := ParseEventWindows("broken=2026-11-01T00:00:00Z/2026-10-25T00:00:00Z", 5); err == nil {
+		t.Fatal("expected an error for an event ending before it starts")
+	}
+}
+
+func TestRolloverArchivesFinalStandings(t *testing.T) {
+	cfg := &config.Config{
+		LeaderboardSnapshotSize: 10,
+		LeaderboardTimezone:     "UTC",
+		LeaderboardArchiveSize:  5,
+		LeaderboardArchiveTop:   3,
+	}
+	s, err := NewService(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	now := time.Now()
+	if err := s.AddWindow(Window{
+		Name:       "sprint",
+		Schedule:   ScheduleEvent,
+		Start:      now.Add(-time.Hour),
+		End:        now.Add(time.Hour),
+		ArchiveTop: 3,
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.Submit("alice", 400, 1000)
+	s.Submit("bob", 400, 900)
+	s.Submit("carol", 100, 200)
+
+	s.rollover(now.Add(2 * time.Hour))
+
+	history, ok := s.History("sprint", 10)
+	if !ok || len(history) != 1 {
+		t.Fatalf("history = %+v, want one archived period", history)
+	}
+	if len(history[0].Winners) != 2 || history[0].Players != 3 {
+		t.Fatalf("unexpected standings: %+v", history[0])
+	}
+
+	s.Submit("dave", 999, 999)
+	if _, ok := s.GetEntry("sprint", "dave"); ok {
+		t.Fatal("closed event window should not accept submissions")
+	}
+	if entry, ok := s.GetEntry(AllTimeWindow, "alice"); !ok || entry.Score != 400 {
+		t.Fatalf("all-time board should be unaffected, got %+v", entry)
+	}
+}
+
+func TestTopClampsNegativeLimit(t *testing.T) {
+	s, err := NewService(&config.Config{LeaderboardSnapshotSize: 10, LeaderboardTimezone: "UTC", LeaderboardArchiveSize: 5, LeaderboardArchiveTop: 3})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.Submit("alice", 400, 1000)
+
+	snap, ok := s.Top(AllTimeWindow, -1)
+	if !ok || len(snap.Entries) != 0 {
+		t.Fatalf("a negative limit should return no entries, got %+v", snap)
+	}
+}
@@ -1,13 +1,17 @@
 package llm
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ahpxex/xtion-hackathon/config"
 	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/nudge"
 )
 
 type LLMResponse struct {
@@ -15,28 +19,54 @@ type LLMResponse struct {
 	StateChange bool   `json:"state_change" validate:"required"`
 	NewState    string `json:"new_state,omitempty" validate:"max=50"`
 	Urgency     string `json:"urgency" validate:"required,oneof=low medium high"`
+
+	// Source and Confidence back NewState when it is proposed to the
+	// session's state machine. Providers that know them fill them in.
+	Source     string  `json:"-"`
+	Confidence float64 `json:"-"`
+	// PromptVersion is the template version the message was generated
+	// from, empty when no prompt was used.
+	PromptVersion string `json:"-"`
 }
 
 type AnalysisRequest struct {
 	SessionID     string            `json:"session_id"`
 	UserState     *game.UserState   `json:"user_state"`
 	RecentActions []game.UserAction `json:"recent_actions"`
+	Options       AnalysisOptions   `json:"-"`
 	Timestamp     time.Time         `json:"timestamp"`
 }
 
 type AnalysisResult struct {
-	SessionID     string       `json:"session_id"`
-	Response      *LLMResponse `json:"response"`
-	PreviousState string       `json:"previous_state"`
-	StateChange   bool         `json:"state_change"`
-	Timestamp     time.Time    `json:"timestamp"`
+	SessionID     string               `json:"session_id"`
+	Response      *LLMResponse         `json:"response"`
+	PreviousState string               `json:"previous_state"`
+	StateChange   bool                 `json:"state_change"`
+	Heuristic     *game.Classification `json:"heuristic,omitempty"`
+	Nudge         *nudge.Decision      `json:"nudge,omitempty"`
+	Source        string               `json:"source"`
+	Confidence    float64              `json:"confidence"`
+	Variant       string               `json:"variant,omitempty"`
+	PromptVersion string               `json:"prompt_version,omitempty"`
+	// StreamID names the delta stream the message was sent in, if any.
+	// A streamed result without a Response closes an abandoned stream.
+	StreamID string `json:"stream_id,omitempty"`
+	// Repeated marks a message too close to one the narrator said
+	// recently. Its state still counts, but it is not spoken.
+	Repeated  bool      `json:"repeated,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
 }
 
 type StateAnalyzer struct {
 	cfg          *config.Config
 	client       LLMProvider
+	classifier   *game.Classifier
+	nudges       *nudge.Engine
+	prompts      *PromptStore
 	analysisChan chan *AnalysisRequest
 	resultChan   chan *AnalysisResult
+	deltaChan    chan *StreamDelta
+	streams      atomic.Uint64
 	ticker       *time.Ticker
 	stopChan     chan struct{}
 	mu           sync.RWMutex
@@ -45,17 +75,52 @@ type StateAnalyzer struct {
 	// We coalesce multiple user_action events and only analyze the most recent
 	// request for each session at the configured interval.
 	pendingRequests map[string]*AnalysisRequest
-	pendingMu       sync.Mutex
+	// lastAnalyzed lets sessions with a longer Options.Interval skip ticks.
+	lastAnalyzed map[string]time.Time
+	pendingMu    sync.Mutex
+	// queue and limiter bound the provider calls: due requests wait in
+	// queue for one of the workers, and each call takes a limiter token.
+	queue   *fairQueue
+	limiter *RateLimiter
+	workers int
+	// ctx is cancelled by Stop; calls holds the cancel func of each
+	// session's analysis in flight so Forget can abandon it. forgotten
+	// marks sessions forgotten after their request left the queue but
+	// before the call started.
+	ctx       context.Context
+	cancel    context.CancelFunc
+	calls     map[string]context.CancelFunc
+	forgotten map[string]bool
+	callMu    sync.Mutex
+	// usage accounts the tokens each call spends. Once the budget is
+	// exhausted, fallback answers instead of the provider.
+	usage    *UsageMeter
+	fallback LLMProvider
 }
 
-func NewStateAnalyzer(cfg *config.Config, client LLMProvider) *StateAnalyzer {
+func NewStateAnalyzer(cfg *config.Config, client LLMProvider, classifier *game.Classifier, nudges *nudge.Engine, prompts *PromptStore, usage *UsageMeter) *StateAnalyzer {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &StateAnalyzer{
 		cfg:             cfg,
 		client:          client,
+		classifier:      classifier,
+		nudges:          nudges,
+		prompts:         prompts,
 		analysisChan:    make(chan *AnalysisRequest, 100),
 		resultChan:      make(chan *AnalysisResult, 100),
+		deltaChan:       make(chan *StreamDelta, 256),
 		stopChan:        make(chan struct{}),
 		pendingRequests: make(map[string]*AnalysisRequest),
+		lastAnalyzed:    make(map[string]time.Time),
+		queue:           newFairQueue(),
+		limiter:         NewRateLimiter(cfg.RateLimitRequestsPerMinute, cfg.RateLimitBurst, time.Now()),
+		workers:         cfg.LLMMaxConcurrent,
+		ctx:             ctx,
+		cancel:          cancel,
+		calls:           make(map[string]context.CancelFunc),
+		forgotten:       make(map[string]bool),
+		usage:           usage,
+		fallback:        NewHeuristicProvider(classifier),
 	}
 }
 
@@ -72,8 +137,12 @@ func (sa *StateAnalyzer) Start() {
 
 	go sa.analysisWorker()
 	go sa.tickerWorker()
+	for i := 0; i < sa.workers; i++ {
+		go sa.callWorker()
+	}
 
-	log.Printf("State analyzer started with %s interval", sa.cfg.AnalysisIntervalSeconds)
+	log.Printf("State analyzer started with %s interval, %d workers, %d requests per minute",
+		sa.cfg.AnalysisIntervalSeconds, sa.workers, sa.cfg.RateLimitRequestsPerMinute)
 }
 
 func (sa *StateAnalyzer) Stop() {
@@ -89,6 +158,7 @@ func (sa *StateAnalyzer) Stop() {
 		sa.ticker.Stop()
 	}
 	close(sa.stopChan)
+	sa.cancel()
 
 	log.Println("State analyzer stopped")
 }
@@ -105,6 +175,12 @@ func (sa *StateAnalyzer) GetResults() <-chan *AnalysisResult {
 	return sa.resultChan
 }
 
+// Deltas delivers the pieces of streamed messages. All deltas of a stream
+// are queued before its result.
+func (sa *StateAnalyzer) Deltas() <-chan *StreamDelta {
+	return sa.deltaChan
+}
+
 func (sa *StateAnalyzer) tickerWorker() {
 	for {
 		select {
@@ -130,40 +206,167 @@ func (sa *StateAnalyzer) addPendingRequest(req *AnalysisRequest) {
 	sa.pendingMu.Unlock()
 }
 
-// drainPendingRequests returns and clears the current pending requests.
+// drainPendingRequests returns and clears the pending requests that are
+// due. Requests whose session asks for a longer interval than the ticker
+// stay pending until that interval has passed.
 func (sa *StateAnalyzer) drainPendingRequests() []*AnalysisRequest {
 	sa.pendingMu.Lock()
 	if len(sa.pendingRequests) == 0 {
 		sa.pendingMu.Unlock()
 		return nil
 	}
+	now := time.Now()
+	level := sa.usage.Level(now)
 	requests := make([]*AnalysisRequest, 0, len(sa.pendingRequests))
-	for _, r := range sa.pendingRequests {
+	for id, r := range sa.pendingRequests {
+		if last, ok := sa.lastAnalyzed[id]; ok && now.Sub(last) < sa.interval(r, level) {
+			continue
+		}
 		requests = append(requests, r)
+		sa.lastAnalyzed[id] = now
+		delete(sa.pendingRequests, id)
 	}
-	sa.pendingRequests = make(map[string]*AnalysisRequest)
 	sa.pendingMu.Unlock()
 	return requests
 }
 
+// interval is how long a session waits between analyses: its own
+// interval, stretched while the budget runs low.
+func (sa *StateAnalyzer) interval(req *AnalysisRequest, level BudgetLevel) time.Duration {
+	if level == BudgetNormal {
+		return req.Options.Interval
+	}
+	interval := sa.cfg.AnalysisIntervalSeconds
+	if req.Options.Interval > interval {
+		interval = req.Options.Interval
+	}
+	return time.Duration(float64(interval) * sa.cfg.LLMBudgetIntervalFactor)
+}
+
+// Forget drops the pending request and interval bookkeeping of a session
+// that has ended and cancels its analysis in flight.
+func (sa *StateAnalyzer) Forget(sessionID string) {
+	sa.pendingMu.Lock()
+	delete(sa.pendingRequests, sessionID)
+	delete(sa.lastAnalyzed, sessionID)
+	sa.pendingMu.Unlock()
+
+	sa.queue.remove(sessionID)
+	sa.usage.Forget(sessionID)
+
+	sa.callMu.Lock()
+	if cancel, exists := sa.calls[sessionID]; exists {
+		cancel()
+		delete(sa.calls, sessionID)
+	} else if sa.queue.busy(sessionID) {
+		sa.forgotten[sessionID] = true
+	}
+	sa.callMu.Unlock()
+}
+
+// analyzeRequests hands due requests to the workers. A request still
+// queued from an earlier tick is replaced by the newer one.
 func (sa *StateAnalyzer) analyzeRequests(requests []*AnalysisRequest) {
+	now := time.Now()
 	for _, req := range requests {
-		go func(r *AnalysisRequest) {
-			result, err := sa.analyzeUserState(r)
-			if err != nil {
-				log.Printf("Analysis failed for session %s: %v", r.SessionID, err)
-				return
-			}
+		sa.queue.push(req, now)
+	}
 
-			select {
-			case sa.resultChan <- result:
-			default:
-				log.Printf("Result channel full, dropping analysis for session %s", result.SessionID)
-			}
-		}(req)
+	if stats := sa.QueueStats(); stats.Depth > sa.workers {
+		log.Printf("Analysis demand exceeds capacity: %d sessions queued, oldest waiting %dms, %d in flight",
+			stats.Depth, stats.OldestWaitMs, stats.InFlight)
 	}
 }
 
+func (sa *StateAnalyzer) callWorker() {
+	for {
+		select {
+		case <-sa.queue.ready:
+		case <-sa.stopChan:
+			return
+		}
+
+		req := sa.queue.pop()
+		if req == nil {
+			continue
+		}
+
+		result, err := sa.runCall(req)
+		sa.queue.done(req.SessionID)
+		sa.callMu.Lock()
+		delete(sa.forgotten, req.SessionID)
+		sa.callMu.Unlock()
+		if errors.Is(err, context.Canceled) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Analysis failed for session %s: %v", req.SessionID, err)
+			continue
+		}
+		if result == nil {
+			continue
+		}
+
+		select {
+		case sa.resultChan <- result:
+		default:
+			log.Printf("Result channel full, dropping analysis for session %s", result.SessionID)
+		}
+	}
+}
+
+// runCall analyzes req under a context that ends when the session ends,
+// the analyzer stops, or the call deadline passes.
+func (sa *StateAnalyzer) runCall(req *AnalysisRequest) (*AnalysisResult, error) {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if deadline := sa.callDeadline(req); deadline > 0 {
+		ctx, cancel = context.WithTimeout(sa.ctx, deadline)
+	} else {
+		ctx, cancel = context.WithCancel(sa.ctx)
+	}
+
+	sa.callMu.Lock()
+	if sa.forgotten[req.SessionID] {
+		sa.callMu.Unlock()
+		cancel()
+		return nil, context.Canceled
+	}
+	sa.calls[req.SessionID] = cancel
+	sa.callMu.Unlock()
+
+	defer func() {
+		sa.callMu.Lock()
+		delete(sa.calls, req.SessionID)
+		sa.callMu.Unlock()
+		cancel()
+	}()
+
+	return sa.analyzeUserState(ctx, req)
+}
+
+// callDeadline bounds a call by the session's analysis interval: an answer
+// arriving after the next tick would describe a stale state.
+func (sa *StateAnalyzer) callDeadline(req *AnalysisRequest) time.Duration {
+	interval := sa.cfg.AnalysisIntervalSeconds
+	if req.Options.Interval > interval {
+		interval = req.Options.Interval
+	}
+	return time.Duration(float64(interval) * sa.cfg.LLMDeadlineRatio)
+}
+
+// QueueStats reports how many sessions wait for an analysis and how busy
+// the workers are.
+func (sa *StateAnalyzer) QueueStats() QueueStats {
+	stats := sa.queue.stats(time.Now())
+	stats.Workers = sa.workers
+	stats.RatePerMinute = sa.cfg.RateLimitRequestsPerMinute
+	if sa.workers > 0 {
+		stats.Utilization = float64(stats.InFlight) / float64(sa.workers)
+	}
+	return stats
+}
+
 func (sa *StateAnalyzer) analysisWorker() {
 	for {
 		select {
@@ -176,17 +379,190 @@ func (sa *StateAnalyzer) analysisWorker() {
 	}
 }
 
-func (sa *StateAnalyzer) analyzeUserState(req *AnalysisRequest) (*AnalysisResult, error) {
+func (sa *StateAnalyzer) analyzeUserState(ctx context.Context, req *AnalysisRequest) (*AnalysisResult, error) {
 	if req.UserState == nil {
 		return nil, fmt.Errorf("user state is nil")
 	}
 
-	llmResp, err := sa.client.AnalyzeUserState(req.UserState, req.RecentActions)
+	previousState := req.UserState.CurrentState
+	classification := sa.classifier.Classify(req.UserState, req.RecentActions, time.Now())
+
+	opts := req.Options
+	var nudged *nudge.Decision
+	if decision := sa.nudges.Decide(req.SessionID, req.UserState, time.Now()); decision != nil && decision.Nudge {
+		nudged = decision
+		if nudged.Mode == nudge.ModeInstruct {
+			opts.Nudge = nudged
+		}
+	}
+
+	// Skip the LLM when the rules are confident nothing has changed and
+	// there is no nudge to phrase. An injected nudge is sent on its own.
+	if sa.cfg.HeuristicPrefilter && opts.Nudge == nil &&
+		classification.State == previousState &&
+		classification.Confidence >= sa.cfg.HeuristicSkipConfidence {
+		if nudged == nil {
+			return nil, nil
+		}
+		return &AnalysisResult{
+			SessionID:     req.SessionID,
+			PreviousState: previousState,
+			Heuristic:     classification,
+			Nudge:         nudged,
+			Variant:       req.Options.Variant,
+			Timestamp:     time.Now(),
+		}, nil
+	}
+
+	if opts.Locale == "" {
+		opts.Locale = sa.cfg.PromptLocale
+	}
+	prompt, err := sa.prompts.Current().Render(req.UserState, req.RecentActions, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt: %w", err)
+	}
+	opts.Prompt = prompt
+	opts.OnUsage = func(usage TokenUsage) {
+		sa.usage.Record(req.SessionID, usage, time.Now())
+	}
+
+	client := sa.client
+	switch sa.usage.Level(time.Now()) {
+	case BudgetEconomy:
+		opts.Models = sa.usage.EconomyModels()
+	case BudgetExhausted:
+		client = sa.fallback
+	}
+
+	// The cache is consulted first so that a hit does not take a limiter
+	// token; on a miss the provider is asked without a second lookup.
+	var cached *LLMResponse
+	if cache, ok := client.(*CachingProvider); ok {
+		cached = cache.Lookup(req.UserState, opts)
+		opts.CacheChecked = true
+	}
+
+	// The fallback costs nothing, so it does not wait for the limiter.
+	if cached == nil && client != sa.fallback {
+		if err := sa.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	streaming, canStream := client.(StreamingProvider)
+	if !sa.cfg.LLMStreaming || !canStream {
+		llmResp := cached
+		if llmResp == nil {
+			llmResp, err = client.AnalyzeUserState(ctx, req.UserState, req.RecentActions, opts)
+			if err != nil {
+				return nil, fmt.Errorf("LLM analysis failed: %w", err)
+			}
+		}
+		llmResp, err = sa.regenerate(ctx, client, req, opts, llmResp)
+		if err != nil {
+			return nil, err
+		}
+		return sa.buildResult(req, llmResp, classification, nudged, prompt, ""), nil
+	}
+
+	// Deltas are held back until the first sentence is complete and known
+	// not to repeat an earlier line; a repeat is never shown and is
+	// regenerated below like a non-streamed one.
+	streamID := fmt.Sprintf("%s-%d", req.SessionID, sa.streams.Add(1))
+	seq := 0
+	held, released, suppressed := "", false, false
+	emit := func(text string) {
+		seq++
+		sa.emitDelta(&StreamDelta{SessionID: req.SessionID, StreamID: streamID, Seq: seq, Text: text})
+	}
+	onDelta := func(text string) {
+		switch {
+		case suppressed:
+		case released:
+			emit(text)
+		default:
+			held += text
+			if !endsSentence(held) {
+				return
+			}
+			if sa.repeatsMessage(req.SessionID, held, opts.History) {
+				suppressed = true
+				return
+			}
+			released = true
+			emit(held)
+		}
+	}
+	llmResp := cached
+	if llmResp != nil {
+		onDelta(llmResp.Message)
+	} else {
+		llmResp, err = streaming.AnalyzeUserStateStream(ctx, req.UserState, req.RecentActions, opts, onDelta)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("LLM analysis failed: %w", err)
+		if seq == 0 {
+			return nil, fmt.Errorf("LLM analysis failed: %w", err)
+		}
+		// Deltas are already out; a result without a response tells the
+		// hub to close the stream.
+		log.Printf("Streamed analysis failed for session %s after %d deltas: %v", req.SessionID, seq, err)
+		return &AnalysisResult{
+			SessionID:     req.SessionID,
+			PreviousState: previousState,
+			Variant:       req.Options.Variant,
+			StreamID:      streamID,
+			Timestamp:     time.Now(),
+		}, nil
+	}
+	// A message without a sentence end is still held.
+	if !released && !suppressed && held != "" {
+		if sa.repeats(req.SessionID, llmResp, opts.History) {
+			suppressed = true
+		} else {
+			emit(held)
+		}
 	}
+	if seq == 0 {
+		streamID = ""
+		llmResp, err = sa.regenerate(ctx, client, req, opts, llmResp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sa.buildResult(req, llmResp, classification, nudged, prompt, streamID), nil
+}
 
+// regenerate asks again, up to NarratorRegenerateAttempts times, while the
+// message repeats one of the narrator's recent lines.
+func (sa *StateAnalyzer) regenerate(ctx context.Context, client LLMProvider, req *AnalysisRequest, opts AnalysisOptions, llmResp *LLMResponse) (*LLMResponse, error) {
+	for attempt := 0; attempt < sa.cfg.NarratorRegenerateAttempts && sa.repeats(req.SessionID, llmResp, opts.History); attempt++ {
+		if err := sa.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+		retry := opts
+		retry.Prompt = avoidRepeat(opts.Prompt, llmResp.Message)
+		again, err := client.AnalyzeUserState(ctx, req.UserState, req.RecentActions, retry)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// The first answer still carries a usable state.
+			log.Printf("Regenerating repeated message failed for session %s: %v", req.SessionID, err)
+			break
+		}
+		llmResp = again
+	}
+	return llmResp, nil
+}
+
+func (sa *StateAnalyzer) buildResult(req *AnalysisRequest, llmResp *LLMResponse, classification *game.Classification, nudged *nudge.Decision, prompt *RenderedPrompt, streamID string) *AnalysisResult {
 	previousState := req.UserState.CurrentState
+	// Providers that answer without a prompt, such as the heuristic one,
+	// set their own source.
+	if llmResp.Source == "" {
+		llmResp.PromptVersion = prompt.Version
+	}
+	repeated := sa.repeats(req.SessionID, llmResp, req.Options.History)
+	sa.crossCheck(req.SessionID, llmResp, classification)
 	stateChange := llmResp.NewState != "" && llmResp.NewState != previousState
 
 	result := &AnalysisResult{
@@ -194,54 +570,89 @@ func (sa *StateAnalyzer) analyzeUserState(req *AnalysisRequest) (*AnalysisResult
 		Response:      llmResp,
 		PreviousState: previousState,
 		StateChange:   stateChange,
+		Heuristic:     classification,
+		Nudge:         nudged,
+		Source:        llmResp.Source,
+		Confidence:    llmResp.Confidence,
+		Variant:       req.Options.Variant,
+		PromptVersion: llmResp.PromptVersion,
+		StreamID:      streamID,
+		Repeated:      repeated,
 		Timestamp:     time.Now(),
 	}
 
-	return result, nil
+	return result
 }
 
-func (sa *StateAnalyzer) buildPrompt(userState *game.UserState, recentActions []game.UserAction) string {
-	prompt := fmt.Sprintf(`Analyze the following user state and provide a response in the specified JSON format.
-
-Current State:
-- Stage: %d
-- Clicks: %d  
-- Engagement Rate: %.2f
-- Current State: %s
-
-Recent Actions:
-`, userState.Stage, userState.Clicks, userState.EngagementRate, userState.CurrentState)
-
-	for i, action := range recentActions {
-		prompt += fmt.Sprintf("%d. Stage: %d, Clicks: %d\n",
-			i+1, action.Stage, action.Clicks)
-	}
-
-	prompt += `
-You are analyzing a user playing an existential clicking game. Your responses should be:
-1. Satirical and questioning of instant gratification
-2. Minimalist and thought-provoking  
-3. Match the user's current engagement pattern
-
-Possible states and response themes:
-- "productive": User is actively clicking and progressing
-- "taking_break": High stage but low click activity (e.g., "哈？为什么不试试消费物品？", "Huh? Why are you taking a break?")
-- "disengaged": Low activity across both metrics (e.g., "?", "Still here?")
-- "confused": Erratic or unclear patterns (e.g., "???", "What are you doing?")
-- "obsessed": Excessive clicking relative to progress
-
-Respond with a JSON object:
-{
-  "message": "Your satirical response (max 200 chars)",
-  "state_change": true/false,
-  "new_state": "state if changed",
-  "urgency": "low/medium/high"
+// repeats reports whether a generated message is too close to one of the
+// narrator's recent lines. Canned answers of providers that set their own
+// source are not checked.
+func (sa *StateAnalyzer) repeats(sessionID string, llmResp *LLMResponse, history []string) bool {
+	if llmResp.Source != "" {
+		return false
+	}
+	return sa.repeatsMessage(sessionID, llmResp.Message, history)
 }
 
-The message should be in Chinese or English, preferably Chinese if appropriate.
-`
+func (sa *StateAnalyzer) repeatsMessage(sessionID, message string, history []string) bool {
+	if sa.cfg.NarratorSimilarityThreshold <= 0 || len(history) == 0 {
+		return false
+	}
+	earlier, score := closestMessage(message, history, sa.cfg.NarratorNgramSize)
+	if score < sa.cfg.NarratorSimilarityThreshold {
+		return false
+	}
+	log.Printf("Session %s: message %q repeats %q (similarity %.2f)", sessionID, message, earlier, score)
+	return true
+}
+
+func (sa *StateAnalyzer) emitDelta(delta *StreamDelta) {
+	select {
+	case sa.deltaChan <- delta:
+	default:
+		log.Printf("Delta channel full, dropping delta %d of stream %s", delta.Seq, delta.StreamID)
+	}
+}
+
+// crossCheck compares the LLM's new_state with the heuristic classification.
+// Unknown states are replaced, and confident disagreements are resolved in
+// favour of the rules. It also settles the source and confidence the state
+// is proposed to the session's state machine with.
+func (sa *StateAnalyzer) crossCheck(sessionID string, llmResp *LLMResponse, classification *game.Classification) {
+	if llmResp.Source == "" {
+		llmResp.Source = "llm"
+	}
+	if llmResp.Confidence == 0 {
+		llmResp.Confidence = sa.cfg.StateLLMConfidence
+	}
+
+	if !game.IsNarratorState(llmResp.NewState) {
+		llmResp.NewState = classification.State
+		llmResp.Source = "heuristic"
+		llmResp.Confidence = classification.Confidence
+		return
+	}
+
+	if llmResp.NewState == classification.State {
+		// Agreement between the model and the rules counts as the stronger
+		// of the two.
+		if classification.Confidence > llmResp.Confidence {
+			llmResp.Confidence = classification.Confidence
+		}
+		return
+	}
+
+	if classification.Confidence >= sa.cfg.HeuristicOverrideConfidence {
+		log.Printf("Session %s: LLM state %s overridden by heuristic %s (%.2f, %s)",
+			sessionID, llmResp.NewState, classification.State, classification.Confidence, classification.Reason)
+		llmResp.NewState = classification.State
+		llmResp.Source = "heuristic"
+		llmResp.Confidence = classification.Confidence
+		return
+	}
 
-	return prompt
+	log.Printf("Session %s: LLM state %s disagrees with heuristic %s (%.2f)",
+		sessionID, llmResp.NewState, classification.State, classification.Confidence)
 }
 
 func (sa *StateAnalyzer) getResponseSchema() map[string]interface{} {
@@ -0,0 +1,130 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/nudge"
+)
+
+func testAnalyzer(provider LLMProvider) *StateAnalyzer {
+	cfg := &config.Config{
+		HeuristicBreakIdle:                 20 * time.Second,
+		HeuristicDisengagedIdle:            90 * time.Second,
+		HeuristicObsessedClicksPerSecond:   8,
+		HeuristicProductiveClicksPerSecond: 0.5,
+		HeuristicBreakMinStage:             100,
+		HeuristicObsessedClicksPerStage:    25,
+		HeuristicConfusedVariation:         1.5,
+		HeuristicMinActions:                3,
+		HeuristicPrefilter:                 true,
+		HeuristicSkipConfidence:            0.75,
+		HeuristicOverrideConfidence:        0.85,
+		StateLLMConfidence:                 0.7,
+		RateLimitRequestsPerMinute:         600,
+		RateLimitBurst:                     10,
+		LLMMaxConcurrent:                   2,
+		LLMBudgetIntervalFactor:            2,
+	}
+	prompts, _ := NewPromptStore("", 10)
+	return NewStateAnalyzer(cfg, provider, NewClassifierFromConfig(cfg), nudge.NewEngine(cfg, nudge.Default()), prompts, NewUsageMeter(nil, BudgetSettings{}, 10))
+}
+
+func TestAnalyzerUsesProviderAnswer(t *testing.T) {
+	sp := NewScriptedProvider(&ProviderScript{
+		Default: LLMResponse{Message: "你很投入。", StateChange: true, NewState: game.StateConfused, Urgency: "low"},
+	})
+	sa := testAnalyzer(sp)
+
+	result, err := sa.analyzeUserState(context.Background(), &AnalysisRequest{
+		SessionID: "s1",
+		UserState: &game.UserState{CurrentState: "new"},
+	})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if !result.StateChange || result.Response.NewState != game.StateConfused || result.Source != "llm" || result.Confidence != 0.7 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(sp.Calls()) != 1 {
+		t.Fatalf("expected one provider call, got %d", len(sp.Calls()))
+	}
+	if call := sp.Calls()[0]; call.Options.Prompt == nil || result.PromptVersion != builtinPrompts.Version {
+		t.Fatalf("the rendered prompt and its version should be passed on: %+v", result)
+	}
+}
+
+func TestAnalyzerReplacesUnknownState(t *testing.T) {
+	sa := testAnalyzer(NewScriptedProvider(&ProviderScript{
+		Default: LLMResponse{Message: "你很投入。", StateChange: true, NewState: "analyzed", Urgency: "low"},
+	}))
+
+	result, err := sa.analyzeUserState(context.Background(), &AnalysisRequest{SessionID: "s1", UserState: &game.UserState{CurrentState: "new"}})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if !game.IsNarratorState(result.Response.NewState) || result.Source != "heuristic" {
+		t.Fatalf("unknown states should fall back to the classifier: %+v", result)
+	}
+}
+
+func TestAnalyzerCancelsEndedSession(t *testing.T) {
+	sa := testAnalyzer(&failingProvider{delay: time.Minute})
+	sa.cfg.AnalysisIntervalSeconds = time.Hour
+	sa.cfg.HeuristicPrefilter = false
+	sa.Start()
+	defer sa.Stop()
+
+	sa.analyzeRequests([]*AnalysisRequest{{SessionID: "s1", UserState: &game.UserState{CurrentState: "new"}}})
+	waitFor(t, func() bool { return sa.QueueStats().InFlight == 1 })
+
+	sa.Forget("s1")
+	waitFor(t, func() bool { return sa.QueueStats().InFlight == 0 })
+}
+
+func TestAnalyzerSkipsSessionForgottenBeforeCall(t *testing.T) {
+	sa := testAnalyzer(&failingProvider{delay: time.Minute})
+	sa.cfg.HeuristicPrefilter = false
+
+	// The request has left the queue but its call has not started.
+	sa.queue.push(&AnalysisRequest{SessionID: "s1", UserState: &game.UserState{CurrentState: "new"}}, time.Now())
+	req := sa.queue.pop()
+	sa.Forget("s1")
+
+	start := time.Now()
+	if _, err := sa.runCall(req); !errors.Is(err, context.Canceled) || time.Since(start) > time.Second {
+		t.Fatalf("expected the forgotten session's call to be skipped, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestAnalyzerCallDeadline(t *testing.T) {
+	sa := testAnalyzer(&failingProvider{delay: time.Minute})
+	sa.cfg.AnalysisIntervalSeconds = 100 * time.Millisecond
+	sa.cfg.LLMDeadlineRatio = 0.5
+	sa.cfg.HeuristicPrefilter = false
+
+	if d := sa.callDeadline(&AnalysisRequest{Options: AnalysisOptions{Interval: time.Second}}); d != 500*time.Millisecond {
+		t.Fatalf("a longer session interval should stretch the deadline, got %s", d)
+	}
+
+	start := time.Now()
+	_, err := sa.runCall(&AnalysisRequest{SessionID: "s1", UserState: &game.UserState{CurrentState: "new"}})
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > time.Second {
+		t.Fatalf("expected the call to end at its deadline, got %v after %s", err, time.Since(start))
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
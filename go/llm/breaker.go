@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"sync"
+	"time"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// BreakerSettings tune a circuit breaker: it opens after Failures
+// consecutive failures and lets a single probe through after OpenFor.
+type BreakerSettings struct {
+	Failures int
+	OpenFor  time.Duration
+}
+
+// BreakerStatus is a breaker's state for monitoring.
+type BreakerStatus struct {
+	Provider      string       `json:"provider"`
+	State         BreakerState `json:"state"`
+	Failures      int          `json:"consecutive_failures"`
+	TotalCalls    int64        `json:"total_calls"`
+	TotalFailures int64        `json:"total_failures"`
+	LastError     string       `json:"last_error,omitempty"`
+	LastLatencyMs int64        `json:"last_latency_ms"`
+	OpenedAt      *time.Time   `json:"opened_at,omitempty"`
+}
+
+// Breaker is a circuit breaker for one provider. While open, calls are
+// skipped; once OpenFor has passed it goes half-open and admits one probe,
+// whose outcome closes or reopens it.
+type Breaker struct {
+	settings BreakerSettings
+	status   BreakerStatus
+	openedAt time.Time
+	probing  bool
+	mu       sync.Mutex
+}
+
+func NewBreaker(provider string, settings BreakerSettings) *Breaker {
+	return &Breaker{
+		settings: settings,
+		status:   BreakerStatus{Provider: provider, State: BreakerClosed},
+	}
+}
+
+// Allow reports whether a call may go through now.
+func (b *Breaker) Allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.status.State {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < b.settings.OpenFor {
+			return false
+		}
+		b.status.State = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+func (b *Breaker) Success(latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.status.TotalCalls++
+	b.status.LastLatencyMs = latency.Milliseconds()
+	b.status.Failures = 0
+	b.status.State = BreakerClosed
+	b.status.OpenedAt = nil
+	b.probing = false
+}
+
+func (b *Breaker) Failure(err error, latency time.Duration, now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.status.TotalCalls++
+	b.status.TotalFailures++
+	b.status.LastError = err.Error()
+	b.status.LastLatencyMs = latency.Milliseconds()
+	b.status.Failures++
+
+	if b.status.State == BreakerHalfOpen || b.status.Failures >= b.settings.Failures {
+		b.status.State = BreakerOpen
+		b.openedAt = now
+		openedAt := now
+		b.status.OpenedAt = &openedAt
+	}
+	b.probing = false
+}
+
+// Release gives back a call admitted by Allow that ended without telling
+// anything about the provider, so a half-open breaker can probe again.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+}
+
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.status
+}
@@ -0,0 +1,290 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// CacheSettings configure a CachingProvider.
+type CacheSettings struct {
+	TTL time.Duration
+	// Variety is how many responses are kept per signature. A signature
+	// is answered by the provider until it has that many, and from the
+	// cache in rotation after that.
+	Variety int
+	// MaxKeys bounds the number of signatures; the least recently used
+	// one is evicted to make room.
+	MaxKeys int
+	// StageBand is the width of the stage bands signatures group by.
+	StageBand int
+}
+
+// CacheStats report how well the cache is doing.
+type CacheStats struct {
+	Keys      int     `json:"keys"`
+	Responses int     `json:"responses"`
+	Hits      int64   `json:"hits"`
+	Misses    int64   `json:"misses"`
+	Bypassed  int64   `json:"bypassed"`
+	Expired   int64   `json:"expired"`
+	Evicted   int64   `json:"evicted"`
+	HitRate   float64 `json:"hit_rate"`
+}
+
+type cachedResponse struct {
+	response LLMResponse
+	storedAt time.Time
+}
+
+type cacheEntry struct {
+	responses []cachedResponse
+	next      int
+	usedAt    time.Time
+}
+
+// CachingProvider answers players in near-identical states with responses
+// generated for earlier ones. Requests are grouped by StateSignature. Only
+// generated answers are cached; canned ones, such as the heuristic
+// provider's, and errors pass through.
+type CachingProvider struct {
+	inner    LLMProvider
+	settings CacheSettings
+	entries  map[string]*cacheEntry
+	stats    CacheStats
+	mu       sync.Mutex
+}
+
+func NewCachingProvider(inner LLMProvider, settings CacheSettings) *CachingProvider {
+	if settings.Variety < 1 {
+		settings.Variety = 1
+	}
+	if settings.StageBand < 1 {
+		settings.StageBand = 1
+	}
+	return &CachingProvider{
+		inner:    inner,
+		settings: settings,
+		entries:  make(map[string]*cacheEntry),
+	}
+}
+
+// StateSignature quantizes the inputs that shape a message: the stage
+// band, clicks per stage, click pace, idle time, narrator state, whether
+// the player has started over, and the variant, locale and prompt version
+// the message would be written with.
+func StateSignature(userState *game.UserState, opts AnalysisOptions, stageBand int) string {
+	promptVersion := ""
+	if opts.Prompt != nil {
+		promptVersion = opts.Prompt.Version
+	}
+	return fmt.Sprintf("stage:%d|ratio:%g|pace:%g|idle:%g|state:%s|prestiged:%t|variant:%s|locale:%s|prompt:%s",
+		userState.Stage/stageBand,
+		quantize(userState.EngagementRate, 0.5, 10),
+		quantize(userState.ClicksPerSecond, 1, 10),
+		quantize(userState.IdleSeconds, 15, 120),
+		userState.CurrentState,
+		userState.PrestigeCount > 0,
+		opts.Variant,
+		opts.Locale,
+		promptVersion,
+	)
+}
+
+// quantize rounds v down to a multiple of step, capped at max.
+func quantize(v, step, max float64) float64 {
+	return math.Min(math.Floor(v/step)*step, max)
+}
+
+// Lookup returns a cached response for the request, or nil on a miss.
+// Callers that look up before paying for a provider call, such as the
+// analyzer taking a rate limit token, set AnalysisOptions.CacheChecked on
+// the call that follows a miss.
+func (cp *CachingProvider) Lookup(userState *game.UserState, opts AnalysisOptions) *LLMResponse {
+	key, cacheable := cp.key(userState, opts)
+	if !cacheable {
+		return nil
+	}
+	return cp.lookup(key, opts.History, time.Now())
+}
+
+func (cp *CachingProvider) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	key, cacheable := cp.key(userState, opts)
+	if cacheable && !opts.CacheChecked {
+		if response := cp.lookup(key, opts.History, time.Now()); response != nil {
+			return response, nil
+		}
+	}
+
+	response, err := cp.inner.AnalyzeUserState(ctx, userState, recentActions, opts)
+	if err == nil && cacheable {
+		cp.store(key, response, time.Now())
+	}
+	return response, err
+}
+
+// AnalyzeUserStateStream serves a cached message as a single delta and
+// streams misses through the wrapped provider when it can.
+func (cp *CachingProvider) AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	key, cacheable := cp.key(userState, opts)
+	if cacheable && !opts.CacheChecked {
+		if response := cp.lookup(key, opts.History, time.Now()); response != nil {
+			onDelta(response.Message)
+			return response, nil
+		}
+	}
+
+	var response *LLMResponse
+	var err error
+	if streaming, ok := cp.inner.(StreamingProvider); ok {
+		response, err = streaming.AnalyzeUserStateStream(ctx, userState, recentActions, opts, onDelta)
+	} else {
+		response, err = cp.inner.AnalyzeUserState(ctx, userState, recentActions, opts)
+	}
+	if err == nil && cacheable {
+		cp.store(key, response, time.Now())
+	}
+	return response, err
+}
+
+// key returns the request's signature. Nudge instructions are written for
+// one player's purchase and are never cached. A bypass is counted once, at
+// the lookup.
+func (cp *CachingProvider) key(userState *game.UserState, opts AnalysisOptions) (string, bool) {
+	if userState == nil || opts.Nudge != nil {
+		if !opts.CacheChecked {
+			cp.mu.Lock()
+			cp.stats.Bypassed++
+			cp.mu.Unlock()
+		}
+		return "", false
+	}
+	return StateSignature(userState, opts, cp.settings.StageBand), true
+}
+
+// lookup returns a copy of the next cached response in rotation, skipping
+// lines the player has already heard, or nil on a miss.
+func (cp *CachingProvider) lookup(key string, heard []string, now time.Time) *LLMResponse {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	entry, exists := cp.entries[key]
+	if !exists {
+		cp.stats.Misses++
+		return nil
+	}
+	cp.expire(entry, now)
+	if len(entry.responses) < cp.settings.Variety {
+		cp.stats.Misses++
+		return nil
+	}
+
+	for i := 0; i < len(entry.responses); i++ {
+		cached := entry.responses[(entry.next+i)%len(entry.responses)]
+		if contains(heard, cached.response.Message) {
+			continue
+		}
+		entry.next = (entry.next + i + 1) % len(entry.responses)
+		entry.usedAt = now
+		cp.stats.Hits++
+		response := cached.response
+		return &response
+	}
+	cp.stats.Misses++
+	return nil
+}
+
+func (cp *CachingProvider) store(key string, response *LLMResponse, now time.Time) {
+	if response == nil || response.Source != "" {
+		return
+	}
+
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	entry, exists := cp.entries[key]
+	if !exists {
+		if cp.settings.MaxKeys > 0 && len(cp.entries) >= cp.settings.MaxKeys {
+			cp.evictOldest()
+		}
+		entry = &cacheEntry{}
+		cp.entries[key] = entry
+	}
+	entry.usedAt = now
+	cached := cachedResponse{response: *response, storedAt: now}
+	if len(entry.responses) < cp.settings.Variety {
+		entry.responses = append(entry.responses, cached)
+		return
+	}
+	// A full entry only gets a fresh answer when every cached one was
+	// already heard; it replaces the next one in rotation.
+	entry.responses[entry.next] = cached
+	entry.next = (entry.next + 1) % len(entry.responses)
+}
+
+// expire drops responses older than the TTL. The caller must hold the lock.
+func (cp *CachingProvider) expire(entry *cacheEntry, now time.Time) {
+	if cp.settings.TTL <= 0 {
+		return
+	}
+	kept := entry.responses[:0]
+	for _, cached := range entry.responses {
+		if now.Sub(cached.storedAt) < cp.settings.TTL {
+			kept = append(kept, cached)
+		} else {
+			cp.stats.Expired++
+		}
+	}
+	entry.responses = kept
+	if entry.next >= len(kept) {
+		entry.next = 0
+	}
+}
+
+// evictOldest removes the least recently used signature. The caller must
+// hold the lock.
+func (cp *CachingProvider) evictOldest() {
+	oldestKey := ""
+	var oldest time.Time
+	for key, entry := range cp.entries {
+		if oldestKey == "" || entry.usedAt.Before(oldest) {
+			oldestKey, oldest = key, entry.usedAt
+		}
+	}
+	if oldestKey != "" {
+		delete(cp.entries, oldestKey)
+		cp.stats.Evicted++
+	}
+}
+
+func (cp *CachingProvider) Stats() CacheStats {
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+
+	stats := cp.stats
+	stats.Keys = len(cp.entries)
+	for _, entry := range cp.entries {
+		stats.Responses += len(entry.responses)
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+	return stats
+}
+
+func (cp *CachingProvider) TestConnection(ctx context.Context) error {
+	return cp.inner.TestConnection(ctx)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
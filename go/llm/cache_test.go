@@ -0,0 +1,107 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/nudge"
+)
+
+func TestStateSignatureBuckets(t *testing.T) {
+	a := &game.UserState{Stage: 120, Clicks: 300, EngagementRate: 2.2, ClicksPerSecond: 1.4, CurrentState: game.StateProductive}
+	b := &game.UserState{Stage: 140, Clicks: 380, EngagementRate: 2.4, ClicksPerSecond: 1.9, IdleSeconds: 3, CurrentState: game.StateProductive}
+	if StateSignature(a, AnalysisOptions{}, 50) != StateSignature(b, AnalysisOptions{}, 50) {
+		t.Fatal("near-identical states should share a signature")
+	}
+
+	b.CurrentState = game.StateObsessed
+	if StateSignature(a, AnalysisOptions{}, 50) == StateSignature(b, AnalysisOptions{}, 50) {
+		t.Fatal("a different narrator state should change the signature")
+	}
+	if StateSignature(a, AnalysisOptions{}, 50) == StateSignature(a, AnalysisOptions{Prompt: &RenderedPrompt{Version: "v2"}}, 50) {
+		t.Fatal("a new prompt version should change the signature")
+	}
+}
+
+func TestCachingProviderRotates(t *testing.T) {
+	inner := NewScriptedProvider(DefaultProviderScript())
+	cp := NewCachingProvider(inner, CacheSettings{TTL: time.Minute, Variety: 2, MaxKeys: 10, StageBand: 50})
+	state := &game.UserState{Stage: 10, CurrentState: game.StateProductive}
+
+	for i := 0; i < 6; i++ {
+		if _, err := cp.AnalyzeUserState(context.Background(), state, nil, AnalysisOptions{}); err != nil {
+			t.Fatalf("call %d failed: %v", i, err)
+		}
+	}
+	if len(inner.Calls()) != 2 {
+		t.Fatalf("the provider should only fill the entry, called %d times", len(inner.Calls()))
+	}
+	stats := cp.Stats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.Keys != 1 || stats.Responses != 2 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// A line the player already heard is not served again.
+	heard := []string{DefaultProviderScript().Default.Message}
+	if _, err := cp.AnalyzeUserState(context.Background(), state, nil, AnalysisOptions{History: heard}); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(inner.Calls()) != 3 {
+		t.Fatal("a signature whose lines were all heard should go to the provider")
+	}
+
+	if _, err := cp.AnalyzeUserState(context.Background(), state, nil, AnalysisOptions{Nudge: &nudge.Decision{}}); err != nil {
+		t.Fatalf("call failed: %v", err)
+	}
+	if len(inner.Calls()) != 4 || cp.Stats().Bypassed != 1 {
+		t.Fatal("nudge instructions should bypass the cache")
+	}
+}
+
+func TestCachingProviderExpiresAndEvicts(t *testing.T) {
+	cp := NewCachingProvider(NewScriptedProvider(DefaultProviderScript()), CacheSettings{TTL: time.Minute, Variety: 1, MaxKeys: 1, StageBand: 50})
+	now := time.Now()
+
+	cp.store("a", &LLMResponse{Message: "一"}, now)
+	if cp.lookup("a", nil, now.Add(2*time.Minute)) != nil || cp.Stats().Expired != 1 {
+		t.Fatal("responses older than the TTL should expire")
+	}
+
+	cp.store("a", &LLMResponse{Message: "一"}, now)
+	cp.store("b", &LLMResponse{Message: "二"}, now)
+	if stats := cp.Stats(); stats.Keys != 1 || stats.Evicted != 1 || cp.lookup("b", nil, now) == nil {
+		t.Fatalf("the oldest signature should make room: %+v", stats)
+	}
+
+	cp.store("c", &LLMResponse{Message: "三", Source: "heuristic"}, now)
+	if cp.lookup("c", nil, now) != nil {
+		t.Fatal("canned answers should not be cached")
+	}
+}
+
+func TestAnalyzerCacheHitsSkipLimiter(t *testing.T) {
+	inner := NewScriptedProvider(DefaultProviderScript())
+	cp := NewCachingProvider(inner, CacheSettings{TTL: time.Minute, Variety: 1, MaxKeys: 10, StageBand: 50})
+	sa := testAnalyzer(cp)
+	sa.cfg.HeuristicPrefilter = false
+	// One token, and the next one a minute away.
+	sa.limiter = NewRateLimiter(1, 1, time.Now())
+
+	req := &AnalysisRequest{SessionID: "s1", UserState: &game.UserState{Stage: 10, CurrentState: game.StateProductive}}
+	if _, err := sa.analyzeUserState(context.Background(), req); err != nil {
+		t.Fatalf("miss failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		if _, err := sa.analyzeUserState(ctx, req); err != nil {
+			t.Fatalf("hit %d waited for the limiter: %v", i, err)
+		}
+	}
+	if stats := cp.Stats(); len(inner.Calls()) != 1 || stats.Hits != 3 || stats.Misses != 1 {
+		t.Fatalf("expected one provider call and three hits, got %d calls and %+v", len(inner.Calls()), stats)
+	}
+}
@@ -0,0 +1,151 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// ChainLink is one provider of a fallback chain. A call taking longer than
+// Budget counts as a failure; zero means no budget.
+type ChainLink struct {
+	Name     string
+	Provider LLMProvider
+	Budget   time.Duration
+}
+
+type chainLink struct {
+	ChainLink
+	breaker *Breaker
+}
+
+// FallbackProvider tries an ordered chain of providers, e.g. DeepSeek, then
+// a local model, then the heuristic lines, and answers with the first that
+// succeeds. Each provider sits behind its own circuit breaker so a failing
+// one is skipped instead of costing every request its timeout.
+type FallbackProvider struct {
+	links []*chainLink
+}
+
+func NewFallbackProvider(settings BreakerSettings, links ...ChainLink) (*FallbackProvider, error) {
+	if len(links) == 0 {
+		return nil, fmt.Errorf("fallback chain has no providers")
+	}
+
+	fp := &FallbackProvider{}
+	for _, link := range links {
+		fp.links = append(fp.links, &chainLink{
+			ChainLink: link,
+			breaker:   NewBreaker(link.Name, settings),
+		})
+	}
+	return fp, nil
+}
+
+func (fp *FallbackProvider) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	return fp.analyze(ctx, userState, recentActions, opts, nil)
+}
+
+// AnalyzeUserStateStream streams from providers that support it. A provider
+// that fails before its first delta is replaced by the next one; once
+// deltas are out, the failure is returned so the caller can close the
+// stream instead of appending another provider's text to it.
+func (fp *FallbackProvider) AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	return fp.analyze(ctx, userState, recentActions, opts, onDelta)
+}
+
+func (fp *FallbackProvider) analyze(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	var failures []string
+	streamed := false
+	if onDelta != nil {
+		deliver := onDelta
+		onDelta = func(text string) {
+			streamed = true
+			deliver(text)
+		}
+	}
+	for i, link := range fp.links {
+		if !link.breaker.Allow(time.Now()) {
+			failures = append(failures, link.Name+": circuit open")
+			continue
+		}
+
+		start := time.Now()
+		response, err := link.call(ctx, userState, recentActions, opts, onDelta)
+		latency := time.Since(start)
+		if err != nil {
+			// A cancelled caller says nothing about the provider's health
+			// and leaves nobody to answer.
+			if ctx.Err() != nil {
+				link.breaker.Release()
+				return nil, ctx.Err()
+			}
+			link.breaker.Failure(err, latency, time.Now())
+			if streamed {
+				return nil, fmt.Errorf("%s failed mid-stream: %w", link.Name, err)
+			}
+			failures = append(failures, fmt.Sprintf("%s: %v", link.Name, err))
+			continue
+		}
+
+		link.breaker.Success(latency)
+		if i > 0 {
+			log.Printf("Analysis answered by fallback provider %s after: %s", link.Name, strings.Join(failures, "; "))
+		}
+		return response, nil
+	}
+
+	return nil, fmt.Errorf("all providers failed: %s", strings.Join(failures, "; "))
+}
+
+// call runs the provider within its latency budget, streaming when asked
+// to and the provider can.
+func (l *chainLink) call(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	callCtx := ctx
+	if l.Budget > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, l.Budget)
+		defer cancel()
+	}
+
+	var response *LLMResponse
+	var err error
+	if streaming, ok := l.Provider.(StreamingProvider); ok && onDelta != nil {
+		response, err = streaming.AnalyzeUserStateStream(callCtx, userState, recentActions, opts, onDelta)
+	} else {
+		response, err = l.Provider.AnalyzeUserState(callCtx, userState, recentActions, opts)
+	}
+	if err != nil && ctx.Err() == nil && callCtx.Err() != nil {
+		return nil, fmt.Errorf("exceeded latency budget of %s", l.Budget)
+	}
+	return response, err
+}
+
+// TestConnection checks every provider and succeeds if any of them is
+// reachable.
+func (fp *FallbackProvider) TestConnection(ctx context.Context) error {
+	var failures []string
+	for _, link := range fp.links {
+		if err := link.Provider.TestConnection(ctx); err != nil {
+			log.Printf("Warning: provider %s connection test failed: %v", link.Name, err)
+			failures = append(failures, fmt.Sprintf("%s: %v", link.Name, err))
+		}
+	}
+	if len(failures) == len(fp.links) {
+		return fmt.Errorf("no provider reachable: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// Breakers reports the breaker state of every provider in chain order.
+func (fp *FallbackProvider) Breakers() []BreakerStatus {
+	statuses := make([]BreakerStatus, len(fp.links))
+	for i, link := range fp.links {
+		statuses[i] = link.breaker.Status()
+	}
+	return statuses
+}
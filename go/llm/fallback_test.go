@@ -0,0 +1,189 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// failingProvider fails every call, optionally after a delay that ends
+// early when the context is done.
+type failingProvider struct {
+	delay time.Duration
+	calls int
+}
+
+func (fp *failingProvider) AnalyzeUserState(ctx context.Context, _ *game.UserState, _ []game.UserAction, _ AnalysisOptions) (*LLMResponse, error) {
+	fp.calls++
+	select {
+	case <-time.After(fp.delay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	return nil, errors.New("upstream unavailable")
+}
+
+func (fp *failingProvider) TestConnection(context.Context) error {
+	return errors.New("upstream unavailable")
+}
+
+// brokenStreamProvider streams one chunk and then fails.
+type brokenStreamProvider struct {
+	failingProvider
+}
+
+func (bp *brokenStreamProvider) AnalyzeUserStateStream(_ context.Context, _ *game.UserState, _ []game.UserAction, _ AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	bp.calls++
+	onDelta("你还")
+	return nil, errors.New("connection reset")
+}
+
+func TestBreakerOpensAndProbes(t *testing.T) {
+	now := time.Now()
+	b := NewBreaker("deepseek", BreakerSettings{Failures: 2, OpenFor: 30 * time.Second})
+
+	b.Failure(errors.New("boom"), 0, now)
+	if !b.Allow(now) {
+		t.Fatal("one failure should not open the breaker")
+	}
+	b.Failure(errors.New("boom"), 0, now)
+	if b.Allow(now.Add(time.Second)) || b.Status().State != BreakerOpen {
+		t.Fatalf("two failures should open the breaker, got %+v", b.Status())
+	}
+
+	later := now.Add(31 * time.Second)
+	if !b.Allow(later) || b.Allow(later) {
+		t.Fatal("a half-open breaker should admit exactly one probe")
+	}
+	b.Failure(errors.New("boom"), 0, later)
+	if b.Status().State != BreakerOpen {
+		t.Fatal("a failed probe should reopen the breaker")
+	}
+
+	muchLater := later.Add(31 * time.Second)
+	b.Allow(muchLater)
+	b.Success(time.Millisecond)
+	if status := b.Status(); status.State != BreakerClosed || status.Failures != 0 || status.OpenedAt != nil {
+		t.Fatalf("a successful probe should close the breaker, got %+v", status)
+	}
+}
+
+func TestFallbackSkipsOpenProvider(t *testing.T) {
+	primary := &failingProvider{}
+	backup := NewScriptedProvider(DefaultProviderScript())
+	chain, err := NewFallbackProvider(BreakerSettings{Failures: 2, OpenFor: time.Minute},
+		ChainLink{Name: "deepseek", Provider: primary},
+		ChainLink{Name: "scripted", Provider: backup},
+	)
+	if err != nil {
+		t.Fatalf("failed to build chain: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		resp, err := chain.AnalyzeUserState(context.Background(), &game.UserState{}, nil, AnalysisOptions{})
+		if err != nil || resp == nil {
+			t.Fatalf("call %d: fallback should answer: %v", i, err)
+		}
+	}
+
+	if primary.calls != 2 {
+		t.Fatalf("primary should be skipped once its breaker opens, called %d times", primary.calls)
+	}
+	if len(backup.Calls()) != 4 {
+		t.Fatalf("backup should answer every call, answered %d", len(backup.Calls()))
+	}
+
+	statuses := chain.Breakers()
+	if statuses[0].State != BreakerOpen || statuses[1].State != BreakerClosed {
+		t.Fatalf("unexpected breaker states: %+v", statuses)
+	}
+}
+
+func TestFallbackLatencyBudget(t *testing.T) {
+	slow := &failingProvider{delay: 200 * time.Millisecond}
+	chain, _ := NewFallbackProvider(BreakerSettings{Failures: 1, OpenFor: time.Minute},
+		ChainLink{Name: "slow", Provider: slow, Budget: 20 * time.Millisecond},
+		ChainLink{Name: "heuristic", Provider: NewScriptedProvider(DefaultProviderScript())},
+	)
+
+	start := time.Now()
+	if _, err := chain.AnalyzeUserState(context.Background(), &game.UserState{}, nil, AnalysisOptions{}); err != nil {
+		t.Fatalf("fallback should answer: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Fatalf("the slow provider should be abandoned at its budget, took %s", elapsed)
+	}
+	if chain.Breakers()[0].LastError == "" {
+		t.Fatal("the overrun should be recorded as a failure")
+	}
+}
+
+func TestFallbackAllFail(t *testing.T) {
+	chain, _ := NewFallbackProvider(BreakerSettings{Failures: 3, OpenFor: time.Minute},
+		ChainLink{Name: "a", Provider: &failingProvider{}},
+		ChainLink{Name: "b", Provider: &failingProvider{}},
+	)
+	if _, err := chain.AnalyzeUserState(context.Background(), &game.UserState{}, nil, AnalysisOptions{}); err == nil {
+		t.Fatal("expected an error when every provider fails")
+	}
+	if err := chain.TestConnection(context.Background()); err == nil {
+		t.Fatal("expected TestConnection to fail when no provider is reachable")
+	}
+}
+
+func TestFallbackStopsWhenCancelled(t *testing.T) {
+	backup := NewScriptedProvider(DefaultProviderScript())
+	chain, _ := NewFallbackProvider(BreakerSettings{Failures: 1, OpenFor: time.Minute},
+		ChainLink{Name: "slow", Provider: &failingProvider{delay: time.Second}},
+		ChainLink{Name: "scripted", Provider: backup},
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := chain.AnalyzeUserState(ctx, &game.UserState{}, nil, AnalysisOptions{}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the caller's deadline, got %v", err)
+	}
+	if len(backup.Calls()) != 0 {
+		t.Fatal("a cancelled call should not fall through to the next provider")
+	}
+	if status := chain.Breakers()[0]; status.State != BreakerClosed || status.TotalFailures != 0 {
+		t.Fatalf("cancellation should not count against the provider: %+v", status)
+	}
+}
+
+func TestFallbackStopsAfterPartialStream(t *testing.T) {
+	primary := &brokenStreamProvider{}
+	backup := NewScriptedProvider(DefaultProviderScript())
+	chain, err := NewFallbackProvider(BreakerSettings{Failures: 2, OpenFor: time.Minute},
+		ChainLink{Name: "deepseek", Provider: primary},
+		ChainLink{Name: "scripted", Provider: backup},
+	)
+	if err != nil {
+		t.Fatalf("failed to build chain: %v", err)
+	}
+
+	var deltas []string
+	_, err = chain.AnalyzeUserStateStream(context.Background(), &game.UserState{}, nil, AnalysisOptions{}, func(text string) {
+		deltas = append(deltas, text)
+	})
+	if err == nil || len(backup.Calls()) != 0 || len(deltas) != 1 {
+		t.Fatalf("a provider failing mid-stream must not be followed by another, got %q, %d backup calls (%v)", deltas, len(backup.Calls()), err)
+	}
+
+	// A provider that fails before its first delta is still replaced.
+	chain, _ = NewFallbackProvider(BreakerSettings{Failures: 2, OpenFor: time.Minute},
+		ChainLink{Name: "deepseek", Provider: &failingProvider{}},
+		ChainLink{Name: "scripted", Provider: backup},
+	)
+	deltas = nil
+	resp, err := chain.AnalyzeUserStateStream(context.Background(), &game.UserState{}, nil, AnalysisOptions{}, func(text string) {
+		deltas = append(deltas, text)
+	})
+	if err != nil || strings.Join(deltas, "") != resp.Message {
+		t.Fatalf("the backup should stream the whole answer, got %q for %+v (%v)", deltas, resp, err)
+	}
+}
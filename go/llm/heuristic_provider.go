@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// NewClassifierFromConfig builds the heuristic classifier with the
+// thresholds overridden by configuration.
+func NewClassifierFromConfig(cfg *config.Config) *game.Classifier {
+	thresholds := game.DefaultClassifierThresholds()
+	thresholds.BreakIdle = cfg.HeuristicBreakIdle
+	thresholds.DisengagedIdle = cfg.HeuristicDisengagedIdle
+	thresholds.BreakMinStage = cfg.HeuristicBreakMinStage
+	thresholds.ObsessedClicksPerSecond = cfg.HeuristicObsessedClicksPerSecond
+	thresholds.ObsessedClicksPerStage = cfg.HeuristicObsessedClicksPerStage
+	thresholds.ProductiveClicksPerSecond = cfg.HeuristicProductiveClicksPerSecond
+	thresholds.ConfusedIntervalVariation = cfg.HeuristicConfusedVariation
+	thresholds.MinActions = cfg.HeuristicMinActions
+	return game.NewClassifier(thresholds)
+}
+
+// NewStateMachineRulesFromConfig builds the narrator state machine rules
+// with the dwell time and thresholds overridden by configuration.
+func NewStateMachineRulesFromConfig(cfg *config.Config) game.StateMachineRules {
+	rules := game.DefaultStateMachineRules()
+	rules.DefaultDwell = cfg.StateMinDwell
+	rules.MinConfidence = cfg.StateMinConfidence
+	rules.HistorySize = cfg.StateHistorySize
+	return rules
+}
+
+// HeuristicProvider answers analysis requests with the rule-based classifier
+// and scripted lines, so the narrator keeps working without an API key.
+type HeuristicProvider struct {
+	classifier *game.Classifier
+	calls      atomic.Int64
+}
+
+func NewHeuristicProvider(classifier *game.Classifier) *HeuristicProvider {
+	return &HeuristicProvider{classifier: classifier}
+}
+
+func (hp *HeuristicProvider) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	classification := hp.classifier.Classify(userState, recentActions, time.Now())
+
+	urgency := "low"
+	switch classification.State {
+	case game.StateDisengaged, game.StateObsessed:
+		urgency = "medium"
+	}
+
+	previous := ""
+	if userState != nil {
+		previous = userState.CurrentState
+	}
+
+	message := game.GetStateResponse(classification.State, int(hp.calls.Add(1)))
+	if opts.Nudge != nil && opts.Nudge.Message != "" {
+		message = opts.Nudge.Message
+	}
+
+	return &LLMResponse{
+		Message:     message,
+		StateChange: classification.State != previous,
+		NewState:    classification.State,
+		Urgency:     urgency,
+		Source:      "heuristic",
+		Confidence:  classification.Confidence,
+	}, nil
+}
+
+func (hp *HeuristicProvider) TestConnection(ctx context.Context) error {
+	return nil
+}
@@ -1,14 +1,63 @@
 package llm
 
 import (
+	"context"
+	"time"
+
 	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/nudge"
 )
 
-// LLMProvider interface defines the contract for LLM providers
+// AnalysisOptions carries per-session overrides, such as those of an
+// experiment variant. Zero values keep the configured defaults.
+type AnalysisOptions struct {
+	Variant      string
+	SystemPrompt string
+	Temperature  *float64
+	Interval     time.Duration
+	// Nudge asks the provider to work a purchase nudge into this reply.
+	Nudge *nudge.Decision
+	// Inventory and Locale feed the prompt templates; Prompt is the
+	// rendered result, filled in by the analyzer.
+	Inventory map[int]int
+	Locale    string
+	Prompt    *RenderedPrompt
+	// History is what the narrator last said to the session, oldest
+	// first. Providers send it as earlier assistant turns.
+	History []string
+	// Models replace providers' configured models, keyed by provider
+	// name, e.g. with cheaper ones when the budget runs low. Providers
+	// without an entry keep their own.
+	Models map[string]string
+	// OnUsage, if set, receives the tokens a call spent as soon as the
+	// provider knows them, whether or not its answer can be used.
+	OnUsage func(TokenUsage)
+	// CacheChecked tells a CachingProvider that its Lookup already
+	// missed for this request, so the wrapped provider is asked directly.
+	CacheChecked bool
+}
+
+// LLMProvider interface defines the contract for LLM providers. Providers
+// must stop work and return once ctx is done.
 type LLMProvider interface {
-	AnalyzeUserState(userState *game.UserState, recentActions []game.UserAction) (*LLMResponse, error)
-	TestConnection() error
+	AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error)
+	TestConnection(ctx context.Context) error
 }
 
 // Ensure DeepSeekClient implements the LLMProvider interface
-var _ LLMProvider = (*DeepSeekClient)(nil)
\ No newline at end of file
+var _ LLMProvider = (*DeepSeekClient)(nil)
+var _ LLMProvider = (*OpenAIClient)(nil)
+var _ LLMProvider = (*OllamaClient)(nil)
+var _ LLMProvider = (*HeuristicProvider)(nil)
+var _ LLMProvider = (*ScriptedProvider)(nil)
+var _ LLMProvider = (*RecordingProvider)(nil)
+var _ LLMProvider = (*ReplayProvider)(nil)
+var _ LLMProvider = (*FallbackProvider)(nil)
+var _ LLMProvider = (*CachingProvider)(nil)
+
+var _ StreamingProvider = (*DeepSeekClient)(nil)
+var _ StreamingProvider = (*OpenAIClient)(nil)
+var _ StreamingProvider = (*ScriptedProvider)(nil)
+var _ StreamingProvider = (*RecordingProvider)(nil)
+var _ StreamingProvider = (*FallbackProvider)(nil)
+var _ StreamingProvider = (*CachingProvider)(nil)
@@ -0,0 +1,225 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+type OllamaRequest struct {
+	Model    string            `json:"model"`
+	Messages []DeepSeekMessage `json:"messages"`
+	Stream   bool              `json:"stream"`
+	Format   string            `json:"format,omitempty"`
+	Options  *OllamaOptions    `json:"options,omitempty"`
+}
+
+type OllamaOptions struct {
+	Temperature float64 `json:"temperature"`
+	NumPredict  int     `json:"num_predict,omitempty"`
+}
+
+type OllamaResponse struct {
+	Model           string          `json:"model"`
+	Message         DeepSeekMessage `json:"message"`
+	Done            bool            `json:"done"`
+	Error           string          `json:"error,omitempty"`
+	PromptEvalCount int             `json:"prompt_eval_count"`
+	EvalCount       int             `json:"eval_count"`
+}
+
+type ollamaTags struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+type ollamaPullResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// OllamaClient runs the narrator on a local Ollama server through its
+// native chat API, so no hosted API is needed.
+type OllamaClient struct {
+	client  *http.Client
+	cfg     *config.Config
+	baseURL string
+	model   string
+}
+
+func NewOllamaClient(cfg *config.Config) (*OllamaClient, error) {
+	base, err := url.Parse(cfg.OllamaBaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid Ollama base URL %q", cfg.OllamaBaseURL)
+	}
+
+	return &OllamaClient{
+		client:  &http.Client{Timeout: cfg.OllamaTimeout},
+		cfg:     cfg,
+		baseURL: strings.TrimRight(cfg.OllamaBaseURL, "/"),
+		model:   cfg.OllamaModel,
+	}, nil
+}
+
+func (oc *OllamaClient) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	if userState == nil {
+		return nil, fmt.Errorf("user state is nil")
+	}
+
+	prompts, err := promptsFor(userState, recentActions, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt: %w", err)
+	}
+
+	temperature := oc.cfg.LLMTemperature
+	if opts.Temperature != nil {
+		temperature = *opts.Temperature
+	}
+
+	model := oc.model
+	if economy := opts.Models["ollama"]; economy != "" {
+		model = economy
+	}
+
+	requestData := OllamaRequest{
+		Model:    model,
+		Messages: chatMessages(prompts, opts.History),
+		Stream:   false,
+		Format:   "json",
+		Options: &OllamaOptions{
+			Temperature: temperature,
+			NumPredict:  oc.cfg.LLMMaxTokens,
+		},
+	}
+
+	var chatResp OllamaResponse
+	if err := oc.post(ctx, "/api/chat", requestData, &chatResp); err != nil {
+		return nil, fmt.Errorf("Ollama chat failed: %w", err)
+	}
+	if chatResp.Error != "" {
+		return nil, fmt.Errorf("Ollama chat failed: %s", chatResp.Error)
+	}
+	reportUsage(opts, model, chatResp.PromptEvalCount, chatResp.EvalCount)
+
+	return parseLLMResponse(extractJSON(chatResp.Message.Content))
+}
+
+// ollamaProbeTimeout bounds the model check of TestConnection. A pull is
+// bounded by OllamaPullTimeout instead.
+var ollamaProbeTimeout = 10 * time.Second
+
+// TestConnection checks that Ollama is running and has the model, pulling
+// it first when OllamaPull is set.
+func (oc *OllamaClient) TestConnection(ctx context.Context) error {
+	probeCtx, cancel := context.WithTimeout(ctx, ollamaProbeTimeout)
+	available, err := oc.hasModel(probeCtx)
+	cancel()
+	if err != nil {
+		return fmt.Errorf("connection test failed: %w", err)
+	}
+	if available {
+		log.Printf("Ollama connection test successful: %s serves %s", oc.baseURL, oc.model)
+		return nil
+	}
+
+	if !oc.cfg.OllamaPull {
+		return fmt.Errorf("model %s is not available, run `ollama pull %s`", oc.model, oc.model)
+	}
+
+	log.Printf("Pulling Ollama model %s, this may take a while", oc.model)
+	pullCtx, pullCancel := context.WithTimeout(ctx, oc.cfg.OllamaPullTimeout)
+	defer pullCancel()
+
+	var pullResp ollamaPullResponse
+	// The pull can outlast the chat timeout, so it runs on its own client.
+	client := &http.Client{Timeout: oc.cfg.OllamaPullTimeout}
+	if err := oc.postWith(pullCtx, client, "/api/pull", map[string]interface{}{"model": oc.model, "stream": false}, &pullResp); err != nil {
+		return fmt.Errorf("failed to pull model %s: %w", oc.model, err)
+	}
+	if pullResp.Error != "" || pullResp.Status != "success" {
+		return fmt.Errorf("failed to pull model %s: %s%s", oc.model, pullResp.Status, pullResp.Error)
+	}
+
+	log.Printf("Pulled Ollama model %s", oc.model)
+	return nil
+}
+
+// hasModel reports whether the model is installed. A name without a tag
+// matches its ":latest" tag, as it does for the Ollama CLI.
+func (oc *OllamaClient) hasModel(ctx context.Context) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, oc.baseURL+"/api/tags", nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := oc.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var tags ollamaTags
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return false, fmt.Errorf("failed to decode model list: %w", err)
+	}
+
+	want := oc.model
+	if !strings.Contains(want, ":") {
+		want += ":latest"
+	}
+	for _, m := range tags.Models {
+		if m.Name == oc.model || m.Name == want {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (oc *OllamaClient) post(ctx context.Context, path string, payload, out interface{}) error {
+	return oc.postWith(ctx, oc.client, path, payload, out)
+}
+
+func (oc *OllamaClient) postWith(ctx context.Context, client *http.Client, path string, payload, out interface{}) error {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, oc.baseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// fakeOllama serves /api/tags, /api/pull and /api/chat, installing the
+// model when it is pulled.
+func fakeOllama(t *testing.T, installed bool) (*httptest.Server, *int) {
+	pulls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			if installed {
+				w.Write([]byte(`{"models":[{"name":"qwen2.5:latest"}]}`))
+			} else {
+				w.Write([]byte(`{"models":[]}`))
+			}
+		case "/api/pull":
+			pulls++
+			installed = true
+			w.Write([]byte(`{"status":"success"}`))
+		case "/api/chat":
+			var req OllamaRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Format != "json" || req.Stream {
+				t.Errorf("unexpected chat request %+v: %v", req, err)
+			}
+			w.Write([]byte(`{"model":"qwen2.5","done":true,"message":{"role":"assistant",` +
+				`"content":"{\"message\":\"慢一点也没关系。\",\"state_change\":true,\"new_state\":\"obsessed\",\"urgency\":\"medium\"}"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server, &pulls
+}
+
+func testOllamaConfig(baseURL string, pull bool) *config.Config {
+	return &config.Config{
+		LLMMaxTokens:      150,
+		LLMTemperature:    0.7,
+		OllamaBaseURL:     baseURL,
+		OllamaModel:       "qwen2.5",
+		OllamaPull:        pull,
+		OllamaTimeout:     5 * time.Second,
+		OllamaPullTimeout: time.Minute,
+	}
+}
+
+func TestOllamaChat(t *testing.T) {
+	server, _ := fakeOllama(t, true)
+	defer server.Close()
+
+	client, err := NewOllamaClient(testOllamaConfig(server.URL, false))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if err := client.TestConnection(context.Background()); err != nil {
+		t.Fatalf("an installed model should pass the check: %v", err)
+	}
+
+	resp, err := client.AnalyzeUserState(context.Background(), &game.UserState{}, nil, AnalysisOptions{})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if resp.NewState != game.StateObsessed || resp.Urgency != "medium" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+}
+
+func TestOllamaTestConnectionPullsMissingModel(t *testing.T) {
+	server, pulls := fakeOllama(t, false)
+	defer server.Close()
+
+	client, _ := NewOllamaClient(testOllamaConfig(server.URL, false))
+	if err := client.TestConnection(context.Background()); err == nil {
+		t.Fatal("a missing model should fail when pulling is disabled")
+	}
+
+	client, _ = NewOllamaClient(testOllamaConfig(server.URL, true))
+	if err := client.TestConnection(context.Background()); err != nil || *pulls != 1 {
+		t.Fatalf("expected one pull, got %d (%v)", *pulls, err)
+	}
+}
+
+func TestOllamaPullOutlastsProbeTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/pull":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(`{"status":"success"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer func(timeout time.Duration) { ollamaProbeTimeout = timeout }(ollamaProbeTimeout)
+	ollamaProbeTimeout = 50 * time.Millisecond
+
+	client, _ := NewOllamaClient(testOllamaConfig(server.URL, true))
+	if err := client.TestConnection(context.Background()); err != nil {
+		t.Fatalf("a pull slower than the probe timeout should succeed: %v", err)
+	}
+
+	cfg := testOllamaConfig(server.URL, true)
+	cfg.OllamaPullTimeout = 100 * time.Millisecond
+	client, _ = NewOllamaClient(cfg)
+	if err := client.TestConnection(context.Background()); err == nil {
+		t.Fatal("a pull slower than OllamaPullTimeout should fail")
+	}
+}
@@ -0,0 +1,246 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// OpenAIClient talks to any server implementing the OpenAI chat completions
+// API, such as vLLM, LM Studio or the llama.cpp server. DeepSeek speaks the
+// same format, so the request and response types are shared with it.
+type OpenAIClient struct {
+	client     *http.Client
+	cfg        *config.Config
+	baseURL    string
+	model      string
+	apiKey     string
+	authHeader string
+	authScheme string
+	headers    map[string]string
+}
+
+type openAIModels struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+func NewOpenAIClient(cfg *config.Config) (*OpenAIClient, error) {
+	base, err := url.Parse(cfg.OpenAICompatBaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid OpenAI-compatible base URL %q", cfg.OpenAICompatBaseURL)
+	}
+
+	headers, err := parseHeaders(cfg.OpenAICompatHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	model := cfg.OpenAICompatModel
+	if model == "" {
+		model = cfg.LLMModel
+	}
+
+	return &OpenAIClient{
+		client:     &http.Client{Timeout: cfg.OpenAICompatTimeout},
+		cfg:        cfg,
+		baseURL:    strings.TrimRight(cfg.OpenAICompatBaseURL, "/"),
+		model:      model,
+		apiKey:     cfg.OpenAICompatAPIKey,
+		authHeader: cfg.OpenAICompatAuthHeader,
+		authScheme: cfg.OpenAICompatAuthScheme,
+		headers:    headers,
+	}, nil
+}
+
+// parseHeaders reads extra request headers written as
+// "Name=value,Other=value".
+func parseHeaders(raw string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid header %q, expected Name=value", pair)
+		}
+		headers[name] = strings.TrimSpace(value)
+	}
+	return headers, nil
+}
+
+func (oc *OpenAIClient) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	return oc.analyze(ctx, userState, recentActions, opts, nil)
+}
+
+// AnalyzeUserStateStream requests a streamed completion and hands the
+// message text to onDelta as it arrives.
+func (oc *OpenAIClient) AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	return oc.analyze(ctx, userState, recentActions, opts, onDelta)
+}
+
+func (oc *OpenAIClient) analyze(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	if userState == nil {
+		return nil, fmt.Errorf("user state is nil")
+	}
+
+	prompts, err := promptsFor(userState, recentActions, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render prompt: %w", err)
+	}
+
+	temperature := oc.cfg.LLMTemperature
+	if opts.Temperature != nil {
+		temperature = *opts.Temperature
+	}
+
+	model := oc.model
+	if economy := opts.Models["openai"]; economy != "" {
+		model = economy
+	}
+
+	requestData := DeepSeekRequest{
+		Model:       model,
+		Messages:    chatMessages(prompts, opts.History),
+		Stream:      onDelta != nil,
+		MaxTokens:   oc.cfg.LLMMaxTokens,
+		Temperature: temperature,
+	}
+	// Not every compatible server understands response_format; the prompt
+	// asks for JSON either way.
+	if oc.cfg.OpenAICompatJSONMode {
+		requestData.ResponseFormat = &DeepSeekResponseFormat{Type: "json_object"}
+	}
+	if onDelta != nil {
+		requestData.StreamOptions = &chatStreamOptions{IncludeUsage: true}
+	}
+
+	jsonData, err := json.Marshal(requestData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := oc.newRequest(ctx, http.MethodPost, "/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := oc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("chat completions call failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("chat completions error: %d, response: %s", resp.StatusCode, string(body))
+	}
+
+	if onDelta != nil {
+		content, usage, err := readChatStream(resp.Body, newMessageStreamer(onDelta).Write)
+		if usage != nil {
+			reportUsage(opts, model, usage.PromptTokens, usage.CompletionTokens)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("chat completions stream failed: %w", err)
+		}
+		return parseLLMResponse(extractJSON(content))
+	}
+
+	var chatResp DeepSeekResponse
+	if err := json.NewDecoder(resp.Body).Decode(&chatResp); err != nil {
+		return nil, fmt.Errorf("failed to decode chat completions response: %w", err)
+	}
+	reportUsage(opts, model, chatResp.Usage.PromptTokens, chatResp.Usage.CompletionTokens)
+
+	if len(chatResp.Choices) == 0 {
+		return nil, fmt.Errorf("no response choices returned")
+	}
+
+	return parseLLMResponse(extractJSON(chatResp.Choices[0].Message.Content))
+}
+
+// TestConnection lists the server's models, which every compatible server
+// supports without spending tokens, and warns when the model is missing.
+func (oc *OpenAIClient) TestConnection(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := oc.newRequest(ctx, http.MethodGet, "/models", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create test request: %w", err)
+	}
+
+	resp, err := oc.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("connection test failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("connection test failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var models openAIModels
+	if err := json.NewDecoder(resp.Body).Decode(&models); err != nil {
+		return fmt.Errorf("failed to decode model list: %w", err)
+	}
+
+	for _, m := range models.Data {
+		if m.ID == oc.model {
+			log.Printf("OpenAI-compatible connection test successful: %s serves %s", oc.baseURL, oc.model)
+			return nil
+		}
+	}
+	log.Printf("Warning: %s does not list model %s among %d models", oc.baseURL, oc.model, len(models.Data))
+	return nil
+}
+
+func (oc *OpenAIClient) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, oc.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if oc.apiKey != "" {
+		value := oc.apiKey
+		if oc.authScheme != "" {
+			value = oc.authScheme + " " + oc.apiKey
+		}
+		req.Header.Set(oc.authHeader, value)
+	}
+	for name, value := range oc.headers {
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// extractJSON trims anything around the outermost JSON object, since local
+// models without a JSON mode like to wrap their answer in prose or code
+// fences.
+func extractJSON(content string) string {
+	start := strings.Index(content, "{")
+	end := strings.LastIndex(content, "}")
+	if start < 0 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func testOpenAIConfig(baseURL string) *config.Config {
+	return &config.Config{
+		LLMModel:               "fallback-model",
+		LLMMaxTokens:           150,
+		LLMTemperature:         0.7,
+		OpenAICompatBaseURL:    baseURL,
+		OpenAICompatModel:      "local-model",
+		OpenAICompatAPIKey:     "secret",
+		OpenAICompatAuthHeader: "X-Api-Key",
+		OpenAICompatHeaders:    "X-Team=narrator, X-Trace = on",
+		OpenAICompatTimeout:    5 * time.Second,
+	}
+}
+
+func TestOpenAIClientSendsConfiguredRequest(t *testing.T) {
+	var got DeepSeekRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		if r.Header.Get("X-Api-Key") != "secret" || r.Header.Get("X-Team") != "narrator" || r.Header.Get("X-Trace") != "on" {
+			t.Errorf("missing auth or extra headers: %v", r.Header)
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("bad request body: %v", err)
+		}
+		w.Write([]byte(`{"choices":[{"message":{"role":"assistant","content":"` +
+			"```json\\n" + `{\"message\":\"停一停也很好。\",\"state_change\":true,\"new_state\":\"taking_break\",\"urgency\":\"low\"}` +
+			"\\n```" + `"}}]}`))
+	}))
+	defer server.Close()
+
+	client, err := NewOpenAIClient(testOpenAIConfig(server.URL + "/v1/"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// Another provider's economy model must not reach this one.
+	opts := AnalysisOptions{History: []string{"你还在这里。"}, Models: map[string]string{"deepseek": "deepseek-chat"}}
+	resp, err := client.AnalyzeUserState(context.Background(), &game.UserState{Stage: 10}, nil, opts)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if resp.NewState != game.StateTakingBreak || resp.Message != "停一停也很好。" {
+		t.Fatalf("unexpected response: %+v", resp)
+	}
+	if len(got.Messages) == 3 && (got.Messages[1].Role != "assistant" || got.Messages[1].Content != "你还在这里。") {
+		t.Fatalf("earlier lines should be sent as assistant turns: %+v", got.Messages)
+	}
+	if got.Model != "local-model" || got.ResponseFormat != nil || len(got.Messages) != 3 {
+		t.Fatalf("unexpected request: %+v", got)
+	}
+}
+
+func TestOpenAIClientRejectsBadConfig(t *testing.T) {
+	if _, err := NewOpenAIClient(testOpenAIConfig("localhost:8000")); err == nil {
+		t.Fatal("expected an error for a base URL without scheme")
+	}
+
+	cfg := testOpenAIConfig("http://localhost:8000/v1")
+	cfg.OpenAICompatHeaders = "no-equals-sign"
+	if _, err := NewOpenAIClient(cfg); err == nil {
+		t.Fatal("expected an error for a malformed header")
+	}
+}
@@ -0,0 +1,229 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// Recording is one request/response pair, stored one per line.
+type Recording struct {
+	Key           string            `json:"key"`
+	UserState     game.UserState    `json:"user_state"`
+	RecentActions []game.UserAction `json:"recent_actions"`
+	Variant       string            `json:"variant,omitempty"`
+	Nudge         string            `json:"nudge,omitempty"`
+	Response      *LLMResponse      `json:"response,omitempty"`
+	Error         string            `json:"error,omitempty"`
+	RecordedAt    time.Time         `json:"recorded_at"`
+}
+
+// recordingKey identifies a request by the inputs that do not depend on
+// the wall clock, so a replayed session matches its recording even though
+// idle times and timestamps differ.
+func recordingKey(userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) string {
+	type action struct{ Stage, Clicks int }
+	key := struct {
+		Stage, Clicks, Prestige int
+		State, Variant, Prompt  string
+		Nudge                   string
+		Actions                 []action
+	}{
+		Stage:    userState.Stage,
+		Clicks:   userState.Clicks,
+		Prestige: userState.PrestigeCount,
+		State:    userState.CurrentState,
+		Variant:  opts.Variant,
+		Prompt:   opts.SystemPrompt,
+	}
+	if opts.Nudge != nil {
+		key.Nudge = opts.Nudge.Message
+	}
+	for _, a := range recentActions {
+		key.Actions = append(key.Actions, action{a.Stage, a.Clicks})
+	}
+
+	data, _ := json.Marshal(key)
+	h := fnv.New64a()
+	h.Write(data)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// RecordingProvider wraps another provider and appends every request and
+// its outcome to a JSON Lines file that a ReplayProvider can serve back.
+type RecordingProvider struct {
+	inner LLMProvider
+	file  *os.File
+	mu    sync.Mutex
+}
+
+func NewRecordingProvider(inner LLMProvider, path string) (*RecordingProvider, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording file: %w", err)
+	}
+	return &RecordingProvider{inner: inner, file: file}, nil
+}
+
+func (rp *RecordingProvider) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	response, err := rp.inner.AnalyzeUserState(ctx, userState, recentActions, opts)
+	return rp.record(userState, recentActions, opts, response, err)
+}
+
+// AnalyzeUserStateStream streams through the wrapped provider when it can
+// and records the final response.
+func (rp *RecordingProvider) AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	var response *LLMResponse
+	var err error
+	if streaming, ok := rp.inner.(StreamingProvider); ok {
+		response, err = streaming.AnalyzeUserStateStream(ctx, userState, recentActions, opts, onDelta)
+	} else {
+		response, err = rp.inner.AnalyzeUserState(ctx, userState, recentActions, opts)
+	}
+	return rp.record(userState, recentActions, opts, response, err)
+}
+
+func (rp *RecordingProvider) record(userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, response *LLMResponse, err error) (*LLMResponse, error) {
+	if userState == nil {
+		return response, err
+	}
+
+	rec := Recording{
+		Key:           recordingKey(userState, recentActions, opts),
+		UserState:     *userState,
+		RecentActions: recentActions,
+		Variant:       opts.Variant,
+		Response:      response,
+		RecordedAt:    time.Now(),
+	}
+	if opts.Nudge != nil {
+		rec.Nudge = opts.Nudge.Message
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+
+	if writeErr := rp.write(rec); writeErr != nil {
+		return response, fmt.Errorf("failed to record analysis: %w", writeErr)
+	}
+	return response, err
+}
+
+func (rp *RecordingProvider) write(rec Recording) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	_, err = rp.file.Write(append(data, '\n'))
+	return err
+}
+
+func (rp *RecordingProvider) TestConnection(ctx context.Context) error {
+	return rp.inner.TestConnection(ctx)
+}
+
+// Close flushes and closes the recording file.
+func (rp *RecordingProvider) Close() error {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+
+	return rp.file.Close()
+}
+
+// ReplayProvider serves recorded responses back. Requests are matched by
+// key; recordings sharing a key are served in recorded order and the last
+// one repeats. Unmatched requests fail when strict, otherwise they take the
+// recordings in file order.
+type ReplayProvider struct {
+	recordings []Recording
+	byKey      map[string][]int
+	served     map[string]int
+	next       int
+	strict     bool
+	mu         sync.Mutex
+}
+
+func LoadReplayProvider(path string, strict bool) (*ReplayProvider, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording file: %w", err)
+	}
+	defer file.Close()
+
+	rp := &ReplayProvider{
+		byKey:  make(map[string][]int),
+		served: make(map[string]int),
+		strict: strict,
+	}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 4*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec Recording
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("recording line %d: %w", line, err)
+		}
+		rp.byKey[rec.Key] = append(rp.byKey[rec.Key], len(rp.recordings))
+		rp.recordings = append(rp.recordings, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read recording file: %w", err)
+	}
+	if len(rp.recordings) == 0 {
+		return nil, fmt.Errorf("recording file %s is empty", path)
+	}
+	return rp, nil
+}
+
+func (rp *ReplayProvider) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	if userState == nil {
+		return nil, fmt.Errorf("user state is nil")
+	}
+
+	key := recordingKey(userState, recentActions, opts)
+
+	rp.mu.Lock()
+	var rec Recording
+	if indexes, exists := rp.byKey[key]; exists {
+		n := rp.served[key]
+		if n >= len(indexes) {
+			n = len(indexes) - 1
+		}
+		rp.served[key]++
+		rec = rp.recordings[indexes[n]]
+	} else if rp.strict {
+		rp.mu.Unlock()
+		return nil, fmt.Errorf("no recording for request %s (stage %d, state %s)", key, userState.Stage, userState.CurrentState)
+	} else {
+		rec = rp.recordings[rp.next%len(rp.recordings)]
+		rp.next++
+	}
+	rp.mu.Unlock()
+
+	if rec.Error != "" {
+		return nil, fmt.Errorf("recorded error: %s", rec.Error)
+	}
+	if rec.Response == nil {
+		return nil, fmt.Errorf("recording %s has no response", rec.Key)
+	}
+	response := *rec.Response
+	return &response, nil
+}
+
+func (rp *ReplayProvider) TestConnection(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,48 @@
+package llm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "analyses.jsonl")
+	recorder, err := NewRecordingProvider(NewScriptedProvider(DefaultProviderScript()), path)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	busy := &game.UserState{Stage: 40, Clicks: 900, ClicksPerSecond: 12, CurrentState: "new"}
+	calm := &game.UserState{Stage: 40, Clicks: 50, CurrentState: "new"}
+	for _, state := range []*game.UserState{busy, calm} {
+		if _, err := recorder.AnalyzeUserState(context.Background(), state, nil, AnalysisOptions{}); err != nil {
+			t.Fatalf("recorded analysis failed: %v", err)
+		}
+	}
+	if err := recorder.Close(); err != nil {
+		t.Fatalf("failed to close recorder: %v", err)
+	}
+
+	replay, err := LoadReplayProvider(path, true)
+	if err != nil {
+		t.Fatalf("failed to load replay: %v", err)
+	}
+
+	// Idle time and rates are not part of the key, so a later run with the
+	// same progress matches.
+	resp, err := replay.AnalyzeUserState(context.Background(), &game.UserState{Stage: 40, Clicks: 50, IdleSeconds: 7, CurrentState: "new"}, nil, AnalysisOptions{})
+	if err != nil || resp.NewState != game.StateProductive {
+		t.Fatalf("expected the calm recording, got %+v (%v)", resp, err)
+	}
+	resp, err = replay.AnalyzeUserState(context.Background(), busy, nil, AnalysisOptions{})
+	if err != nil || resp.NewState != game.StateObsessed {
+		t.Fatalf("expected the busy recording, got %+v (%v)", resp, err)
+	}
+
+	if _, err := replay.AnalyzeUserState(context.Background(), &game.UserState{Stage: 1}, nil, AnalysisOptions{}); err == nil {
+		t.Fatal("strict replay should fail for unrecorded requests")
+	}
+}
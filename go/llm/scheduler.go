@@ -0,0 +1,202 @@
+package llm
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by all analyses. Tokens refill at
+// perMinute per minute and at most burst accumulate while idle.
+type RateLimiter struct {
+	every  time.Duration
+	burst  float64
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func NewRateLimiter(perMinute, burst int, now time.Time) *RateLimiter {
+	if perMinute < 1 {
+		perMinute = 1
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		every:  time.Minute / time.Duration(perMinute),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   now,
+	}
+}
+
+// Reserve takes a token if one is available and returns zero, otherwise it
+// returns how long until the next token without taking anything.
+func (rl *RateLimiter) Reserve(now time.Time) time.Duration {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if elapsed := now.Sub(rl.last); elapsed > 0 {
+		rl.tokens += float64(elapsed) / float64(rl.every)
+		if rl.tokens > rl.burst {
+			rl.tokens = rl.burst
+		}
+		rl.last = now
+	}
+
+	if rl.tokens >= 1 {
+		rl.tokens--
+		return 0
+	}
+	return time.Duration((1 - rl.tokens) * float64(rl.every))
+}
+
+// Wait blocks until a token is taken or ctx is done.
+func (rl *RateLimiter) Wait(ctx context.Context) error {
+	for {
+		wait := rl.Reserve(time.Now())
+		if wait == 0 {
+			return nil
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// QueueStats reports the analysis backlog for monitoring.
+type QueueStats struct {
+	Depth         int     `json:"depth"`
+	InFlight      int     `json:"in_flight"`
+	Workers       int     `json:"workers"`
+	RatePerMinute int     `json:"rate_per_minute"`
+	OldestWaitMs  int64   `json:"oldest_wait_ms"`
+	Processed     int64   `json:"processed"`
+	Replaced      int64   `json:"replaced"`
+	Utilization   float64 `json:"utilization"`
+}
+
+type queuedRequest struct {
+	req        *AnalysisRequest
+	enqueuedAt time.Time
+}
+
+// fairQueue holds at most one request per session and serves sessions in
+// the order they first queued. A newer request replaces the queued one in
+// place, so a chatty session cannot push others back, and a session is not
+// served again while its previous analysis is still running.
+type fairQueue struct {
+	order     []string
+	queued    map[string]*queuedRequest
+	inFlight  map[string]bool
+	processed int64
+	replaced  int64
+	ready     chan struct{}
+	mu        sync.Mutex
+}
+
+func newFairQueue() *fairQueue {
+	return &fairQueue{
+		queued:   make(map[string]*queuedRequest),
+		inFlight: make(map[string]bool),
+		ready:    make(chan struct{}, 1),
+	}
+}
+
+func (q *fairQueue) push(req *AnalysisRequest, now time.Time) {
+	q.mu.Lock()
+	if existing, exists := q.queued[req.SessionID]; exists {
+		existing.req = req
+		q.replaced++
+	} else {
+		q.queued[req.SessionID] = &queuedRequest{req: req, enqueuedAt: now}
+		q.order = append(q.order, req.SessionID)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// pop returns the oldest queued request whose session is not in flight and
+// marks that session busy until done is called.
+func (q *fairQueue) pop() *AnalysisRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, id := range q.order {
+		if q.inFlight[id] {
+			continue
+		}
+		entry := q.queued[id]
+		q.order = append(q.order[:i:i], q.order[i+1:]...)
+		delete(q.queued, id)
+		q.inFlight[id] = true
+		if len(q.order) > 0 {
+			q.signal()
+		}
+		return entry.req
+	}
+	return nil
+}
+
+func (q *fairQueue) done(sessionID string) {
+	q.mu.Lock()
+	delete(q.inFlight, sessionID)
+	q.processed++
+	pending := len(q.order) > 0
+	q.mu.Unlock()
+	if pending {
+		q.signal()
+	}
+}
+
+// busy reports whether the session's analysis has been popped and is not
+// done yet.
+func (q *fairQueue) busy(sessionID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.inFlight[sessionID]
+}
+
+func (q *fairQueue) remove(sessionID string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exists := q.queued[sessionID]; !exists {
+		return
+	}
+	delete(q.queued, sessionID)
+	for i, id := range q.order {
+		if id == sessionID {
+			q.order = append(q.order[:i:i], q.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (q *fairQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *fairQueue) stats(now time.Time) QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := QueueStats{
+		Depth:     len(q.order),
+		InFlight:  len(q.inFlight),
+		Processed: q.processed,
+		Replaced:  q.replaced,
+	}
+	if len(q.order) > 0 {
+		stats.OldestWaitMs = now.Sub(q.queued[q.order[0]].enqueuedAt).Milliseconds()
+	}
+	return stats
+}
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func TestRateLimiterRefills(t *testing.T) {
+	now := time.Now()
+	rl := NewRateLimiter(60, 2, now)
+
+	if rl.Reserve(now) != 0 || rl.Reserve(now) != 0 {
+		t.Fatal("the burst should be available at once")
+	}
+	if wait := rl.Reserve(now); wait != time.Second {
+		t.Fatalf("expected to wait one second for the next token, got %s", wait)
+	}
+	if rl.Reserve(now.Add(500*time.Millisecond)) == 0 {
+		t.Fatal("half a token should not be enough")
+	}
+	if rl.Reserve(now.Add(time.Second)) != 0 {
+		t.Fatal("a token should have refilled after one second")
+	}
+	if rl.Reserve(now.Add(time.Hour)) != 0 || rl.Reserve(now.Add(time.Hour)) != 0 || rl.Reserve(now.Add(time.Hour)) == 0 {
+		t.Fatal("tokens should not accumulate beyond the burst")
+	}
+}
+
+func TestRateLimiterWaitStops(t *testing.T) {
+	rl := NewRateLimiter(1, 1, time.Now())
+	rl.Reserve(time.Now())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := rl.Wait(ctx); err != context.Canceled {
+		t.Fatalf("Wait should give up when cancelled, got %v", err)
+	}
+}
+
+func TestFairQueueOrder(t *testing.T) {
+	q := newFairQueue()
+	now := time.Now()
+
+	q.push(&AnalysisRequest{SessionID: "a"}, now)
+	q.push(&AnalysisRequest{SessionID: "b"}, now)
+	newer := &AnalysisRequest{SessionID: "a", Timestamp: now.Add(time.Second)}
+	q.push(newer, now.Add(time.Second))
+
+	if stats := q.stats(now); stats.Depth != 2 || stats.Replaced != 1 {
+		t.Fatalf("a session should hold one slot, got %+v", stats)
+	}
+
+	first := q.pop()
+	if first != newer {
+		t.Fatalf("the replaced request should keep its place, got %+v", first)
+	}
+
+	// "a" queues again while its analysis is in flight; "b" goes first
+	// and "a" waits until it is done.
+	q.push(&AnalysisRequest{SessionID: "a"}, now)
+	if req := q.pop(); req.SessionID != "b" {
+		t.Fatalf("expected b, got %s", req.SessionID)
+	}
+	if req := q.pop(); req != nil {
+		t.Fatalf("a session in flight should not be served again, got %+v", req)
+	}
+	q.done("a")
+	if req := q.pop(); req == nil || req.SessionID != "a" {
+		t.Fatalf("expected a after it finished, got %+v", req)
+	}
+
+	q.push(&AnalysisRequest{SessionID: "c"}, now)
+	q.remove("c")
+	if stats := q.stats(now); stats.Depth != 0 || stats.InFlight != 2 {
+		t.Fatalf("unexpected stats after remove: %+v", stats)
+	}
+}
+
+func TestAnalyzerBoundsConcurrency(t *testing.T) {
+	sp := NewScriptedProvider(DefaultProviderScript())
+	sa := testAnalyzer(sp)
+	sa.cfg.AnalysisIntervalSeconds = time.Hour
+	sa.cfg.HeuristicPrefilter = false
+	sa.Start()
+	defer sa.Stop()
+
+	var requests []*AnalysisRequest
+	for _, id := range []string{"s1", "s2", "s3", "s4", "s5"} {
+		requests = append(requests, &AnalysisRequest{SessionID: id, UserState: &game.UserState{CurrentState: "new"}})
+	}
+	sa.analyzeRequests(requests)
+
+	for i := 0; i < len(requests); i++ {
+		select {
+		case <-sa.GetResults():
+		case <-time.After(2 * time.Second):
+			t.Fatalf("only %d of %d analyses finished", i, len(requests))
+		}
+	}
+	if stats := sa.QueueStats(); stats.Processed != 5 || stats.Depth != 0 || stats.Workers != 2 {
+		t.Fatalf("unexpected queue stats: %+v", stats)
+	}
+}
@@ -0,0 +1,195 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// ScriptCondition selects user states for a scripted rule. Zero values do
+// not restrict.
+type ScriptCondition struct {
+	MinStage           int      `json:"min_stage,omitempty"`
+	MaxStage           int      `json:"max_stage,omitempty"`
+	MinClicks          int      `json:"min_clicks,omitempty"`
+	MinIdleSeconds     float64  `json:"min_idle_seconds,omitempty"`
+	MinClicksPerSecond float64  `json:"min_clicks_per_second,omitempty"`
+	States             []string `json:"states,omitempty"`
+}
+
+func (c ScriptCondition) matches(state *game.UserState) bool {
+	if state.Stage < c.MinStage || (c.MaxStage > 0 && state.Stage > c.MaxStage) {
+		return false
+	}
+	if state.Clicks < c.MinClicks || state.IdleSeconds < c.MinIdleSeconds {
+		return false
+	}
+	if state.ClicksPerSecond < c.MinClicksPerSecond {
+		return false
+	}
+	if len(c.States) == 0 {
+		return true
+	}
+	for _, s := range c.States {
+		if s == state.CurrentState {
+			return true
+		}
+	}
+	return false
+}
+
+// ScriptRule answers with Response when its condition matches.
+type ScriptRule struct {
+	Name     string          `json:"name"`
+	When     ScriptCondition `json:"when"`
+	Response LLMResponse     `json:"response"`
+}
+
+// ProviderScript is the rule list of a ScriptedProvider. The first matching
+// rule answers; Default answers when none does.
+type ProviderScript struct {
+	Rules   []ScriptRule `json:"rules"`
+	Default LLMResponse  `json:"default"`
+}
+
+// DefaultProviderScript mirrors the classifier's idle and click-rate
+// thresholds with fixed lines.
+func DefaultProviderScript() *ProviderScript {
+	return &ProviderScript{
+		Rules: []ScriptRule{
+			{
+				Name:     "disengaged",
+				When:     ScriptCondition{MinIdleSeconds: 90},
+				Response: LLMResponse{Message: "还在吗？", StateChange: true, NewState: game.StateDisengaged, Urgency: "medium"},
+			},
+			{
+				Name:     "taking_break",
+				When:     ScriptCondition{MinIdleSeconds: 20, MinStage: 100},
+				Response: LLMResponse{Message: "停下来也很好，反正它不会跑掉。", StateChange: true, NewState: game.StateTakingBreak, Urgency: "low"},
+			},
+			{
+				Name:     "obsessed",
+				When:     ScriptCondition{MinClicksPerSecond: 8},
+				Response: LLMResponse{Message: "慢一点，按钮不会因此更爱你。", StateChange: true, NewState: game.StateObsessed, Urgency: "medium"},
+			},
+		},
+		Default: LLMResponse{Message: "你一直在往前走，可前面究竟有什么？", StateChange: true, NewState: game.StateProductive, Urgency: "low"},
+	}
+}
+
+// LoadProviderScript reads a script from path, or returns
+// DefaultProviderScript when path is empty.
+func LoadProviderScript(path string) (*ProviderScript, error) {
+	if path == "" {
+		return DefaultProviderScript(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read provider script: %w", err)
+	}
+
+	var script ProviderScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse provider script: %w", err)
+	}
+	if err := script.Validate(); err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
+
+func (s *ProviderScript) Validate() error {
+	responses := make([]LLMResponse, 0, len(s.Rules)+1)
+	for _, r := range s.Rules {
+		responses = append(responses, r.Response)
+	}
+	responses = append(responses, s.Default)
+
+	for i := range responses {
+		name := "default"
+		if i < len(s.Rules) {
+			name = s.Rules[i].Name
+		}
+		if err := validateResponse(&responses[i]); err != nil {
+			return fmt.Errorf("provider script rule %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ScriptedCall is one request a ScriptedProvider answered.
+type ScriptedCall struct {
+	UserState game.UserState
+	Options   AnalysisOptions
+	Rule      string
+}
+
+// ScriptedProvider answers from a fixed rule list without any network,
+// for tests and offline development. It keeps the calls it answered.
+type ScriptedProvider struct {
+	script *ProviderScript
+	calls  []ScriptedCall
+	mu     sync.Mutex
+}
+
+func NewScriptedProvider(script *ProviderScript) *ScriptedProvider {
+	return &ScriptedProvider{script: script}
+}
+
+func (sp *ScriptedProvider) AnalyzeUserState(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	if userState == nil {
+		return nil, fmt.Errorf("user state is nil")
+	}
+
+	rule := "default"
+	response := sp.script.Default
+	for _, r := range sp.script.Rules {
+		if r.When.matches(userState) {
+			rule = r.Name
+			response = r.Response
+			break
+		}
+	}
+	if opts.Nudge != nil && opts.Nudge.Message != "" {
+		response.Message = opts.Nudge.Message
+	}
+
+	sp.mu.Lock()
+	sp.calls = append(sp.calls, ScriptedCall{UserState: *userState, Options: opts, Rule: rule})
+	sp.mu.Unlock()
+
+	return &response, nil
+}
+
+// AnalyzeUserStateStream answers like AnalyzeUserState and hands the
+// message to onDelta one character at a time, so streaming clients can be
+// exercised offline.
+func (sp *ScriptedProvider) AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	response, err := sp.AnalyzeUserState(ctx, userState, recentActions, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range response.Message {
+		onDelta(string(r))
+	}
+	return response, nil
+}
+
+// Calls returns the requests answered so far.
+func (sp *ScriptedProvider) Calls() []ScriptedCall {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	calls := make([]ScriptedCall, len(sp.calls))
+	copy(calls, sp.calls)
+	return calls
+}
+
+func (sp *ScriptedProvider) TestConnection(ctx context.Context) error {
+	return nil
+}
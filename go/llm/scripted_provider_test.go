@@ -0,0 +1,45 @@
+package llm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/nudge"
+)
+
+func TestScriptedProviderPicksFirstMatchingRule(t *testing.T) {
+	sp := NewScriptedProvider(DefaultProviderScript())
+
+	cases := []struct {
+		state game.UserState
+		want  string
+	}{
+		{game.UserState{IdleSeconds: 120}, game.StateDisengaged},
+		{game.UserState{IdleSeconds: 30, Stage: 200}, game.StateTakingBreak},
+		{game.UserState{IdleSeconds: 30, Stage: 50}, game.StateProductive},
+		{game.UserState{ClicksPerSecond: 10}, game.StateObsessed},
+	}
+	for _, c := range cases {
+		resp, err := sp.AnalyzeUserState(context.Background(), &c.state, nil, AnalysisOptions{})
+		if err != nil || resp.NewState != c.want {
+			t.Fatalf("state %+v: got %+v (%v), want %s", c.state, resp, err, c.want)
+		}
+	}
+
+	resp, _ := sp.AnalyzeUserState(context.Background(), &game.UserState{}, nil, AnalysisOptions{Nudge: &nudge.Decision{Message: "要不要试试商店里的东西？"}})
+	if resp.Message != "要不要试试商店里的东西？" {
+		t.Fatalf("a nudge should replace the scripted line, got %s", resp.Message)
+	}
+	if calls := sp.Calls(); len(calls) != 5 || calls[0].Rule != "disengaged" {
+		t.Fatalf("unexpected calls: %+v", calls)
+	}
+}
+
+func TestProviderScriptValidatesLines(t *testing.T) {
+	script := DefaultProviderScript()
+	script.Rules[0].Response.Message = "你已经到了第 3 阶段"
+	if err := script.Validate(); err == nil {
+		t.Fatal("lines with numbers or metrics should be rejected")
+	}
+}
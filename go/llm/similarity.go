@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ngramSimilarity compares two messages by their character n-grams and
+// returns the Dice coefficient, from 0 for nothing shared to 1 for the same
+// n-grams. Spaces and punctuation are ignored, so a reworded ending does
+// not hide a repeated line. Characters rather than words are compared
+// because the narrator mostly speaks Chinese, which has no spaces.
+func ngramSimilarity(a, b string, n int) float64 {
+	ga, gb := ngrams(a, n), ngrams(b, n)
+	if len(ga) == 0 || len(gb) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for gram := range ga {
+		if gb[gram] {
+			shared++
+		}
+	}
+	return 2 * float64(shared) / float64(len(ga)+len(gb))
+}
+
+func ngrams(s string, n int) map[string]bool {
+	if n < 1 {
+		n = 1
+	}
+
+	runes := make([]rune, 0, len(s))
+	for _, r := range s {
+		if unicode.IsSpace(r) || unicode.IsPunct(r) || unicode.IsSymbol(r) {
+			continue
+		}
+		runes = append(runes, unicode.ToLower(r))
+	}
+
+	grams := make(map[string]bool)
+	if len(runes) == 0 {
+		return grams
+	}
+	if len(runes) < n {
+		grams[string(runes)] = true
+		return grams
+	}
+	for i := 0; i+n <= len(runes); i++ {
+		grams[string(runes[i:i+n])] = true
+	}
+	return grams
+}
+
+// closestMessage returns the earlier message most similar to message and
+// their similarity.
+func closestMessage(message string, history []string, n int) (string, float64) {
+	closest, best := "", 0.0
+	for _, earlier := range history {
+		if score := ngramSimilarity(message, earlier, n); score > best {
+			closest, best = earlier, score
+		}
+	}
+	return closest, best
+}
+
+// avoidRepeat extends a prompt for a second attempt after the model
+// repeated itself.
+func avoidRepeat(prompt *RenderedPrompt, draft string) *RenderedPrompt {
+	retry := *prompt
+	retry.User += fmt.Sprintf("\n\nYour draft %q is too close to something you already said. Write a different sentence with new imagery and wording.", draft)
+	return &retry
+}
+
+// endsSentence reports whether text contains a complete sentence.
+func endsSentence(text string) bool {
+	return strings.ContainsAny(text, "。！？…!?\n")
+}
@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func TestNgramSimilarity(t *testing.T) {
+	if score := ngramSimilarity("你还在这里。", "你还在 这里！", 2); score != 1 {
+		t.Fatalf("punctuation should not count, got %.2f", score)
+	}
+	if score := ngramSimilarity("慢一点，按钮不会因此更爱你。", "停下来也很好，反正它不会跑掉。", 2); score > 0.2 {
+		t.Fatalf("different lines should score low, got %.2f", score)
+	}
+	if score := ngramSimilarity("你", "你", 2); score != 1 {
+		t.Fatalf("lines shorter than n should still compare, got %.2f", score)
+	}
+	if score := ngramSimilarity("", "你", 2); score != 0 {
+		t.Fatalf("empty lines share nothing, got %.2f", score)
+	}
+}
+
+// sequenceProvider answers with its messages in turn and keeps the user
+// prompts it was sent.
+type sequenceProvider struct {
+	messages []string
+	prompts  []string
+	mu       sync.Mutex
+}
+
+func (sp *sequenceProvider) AnalyzeUserState(_ context.Context, _ *game.UserState, _ []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	message := sp.messages[len(sp.prompts)%len(sp.messages)]
+	sp.prompts = append(sp.prompts, opts.Prompt.User)
+	return &LLMResponse{Message: message, StateChange: true, NewState: game.StateProductive, Urgency: "low"}, nil
+}
+
+func (sp *sequenceProvider) TestConnection(context.Context) error {
+	return nil
+}
+
+// streamingSequenceProvider streams its messages one character at a time.
+type streamingSequenceProvider struct {
+	sequenceProvider
+}
+
+func (sp *streamingSequenceProvider) AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error) {
+	response, err := sp.AnalyzeUserState(ctx, userState, recentActions, opts)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range response.Message {
+		onDelta(string(r))
+	}
+	return response, nil
+}
+
+func TestAnalyzerRegeneratesRepeatedMessage(t *testing.T) {
+	sp := &sequenceProvider{messages: []string{"你还在这里。", "风停了，你也可以停。"}}
+	sa := testAnalyzer(sp)
+	sa.cfg.NarratorNgramSize = 2
+	sa.cfg.NarratorSimilarityThreshold = 0.6
+	sa.cfg.NarratorRegenerateAttempts = 1
+
+	result, err := sa.analyzeUserState(context.Background(), &AnalysisRequest{
+		SessionID: "s1",
+		UserState: &game.UserState{CurrentState: "new"},
+		Options:   AnalysisOptions{History: []string{"你还在这里吗？"}},
+	})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if len(sp.prompts) != 2 || !strings.Contains(sp.prompts[1], "你还在这里。") {
+		t.Fatalf("the repeated draft should be regenerated with a hint, prompts: %q", sp.prompts)
+	}
+	if result.Repeated || result.Response.Message != "风停了，你也可以停。" {
+		t.Fatalf("expected the regenerated message, got %+v", result)
+	}
+}
+
+func TestAnalyzerMarksPersistentRepeat(t *testing.T) {
+	sp := &sequenceProvider{messages: []string{"你还在这里。"}}
+	sa := testAnalyzer(sp)
+	sa.cfg.NarratorNgramSize = 2
+	sa.cfg.NarratorSimilarityThreshold = 0.6
+	sa.cfg.NarratorRegenerateAttempts = 1
+
+	result, err := sa.analyzeUserState(context.Background(), &AnalysisRequest{
+		SessionID: "s1",
+		UserState: &game.UserState{CurrentState: "new"},
+		Options:   AnalysisOptions{History: []string{"你还在这里。"}},
+	})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if !result.Repeated || !result.StateChange {
+		t.Fatalf("a message that keeps repeating should be held back but keep its state: %+v", result)
+	}
+}
+
+func TestAnalyzerHoldsBackStreamedRepeat(t *testing.T) {
+	sp := &streamingSequenceProvider{sequenceProvider{messages: []string{"你还在这里。还在。", "风停了，你也可以停。"}}}
+	sa := testAnalyzer(sp)
+	sa.cfg.LLMStreaming = true
+	sa.cfg.NarratorNgramSize = 2
+	sa.cfg.NarratorSimilarityThreshold = 0.6
+	sa.cfg.NarratorRegenerateAttempts = 1
+
+	result, err := sa.analyzeUserState(context.Background(), &AnalysisRequest{
+		SessionID: "s1",
+		UserState: &game.UserState{CurrentState: "new"},
+		Options:   AnalysisOptions{History: []string{"你还在这里吗？"}},
+	})
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	select {
+	case delta := <-sa.Deltas():
+		t.Fatalf("a repeated first sentence must not be streamed, got %+v", delta)
+	default:
+	}
+	if result.StreamID != "" || result.Repeated || result.Response.Message != "风停了，你也可以停。" {
+		t.Fatalf("expected the regenerated message outside a stream, got %+v", result)
+	}
+}
@@ -0,0 +1,179 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// StreamingProvider is implemented by providers that can hand out the
+// narrator message while it is being generated. onDelta receives pieces of
+// the message text in order; the returned response is the complete one.
+type StreamingProvider interface {
+	LLMProvider
+	AnalyzeUserStateStream(ctx context.Context, userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions, onDelta func(string)) (*LLMResponse, error)
+}
+
+// StreamDelta is a piece of a narrator message that is still being
+// generated. Seq starts at 1 within a stream.
+type StreamDelta struct {
+	SessionID string `json:"session_id"`
+	StreamID  string `json:"stream_id"`
+	Seq       int    `json:"seq"`
+	Text      string `json:"text"`
+}
+
+type chatStreamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+	Usage *ChatUsage `json:"usage,omitempty"`
+}
+
+// chatStreamOptions asks for the token usage in the last chunk of a
+// stream.
+type chatStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// readChatStream reads an OpenAI-style server-sent event stream of chat
+// completion chunks, passing each content fragment to onContent, and
+// returns the whole content and the usage, if the server sent it.
+func readChatStream(body io.Reader, onContent func(string)) (string, *ChatUsage, error) {
+	var content strings.Builder
+	var usage *ChatUsage
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "data:") {
+			// Blank separators, comments and event names carry no content.
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return content.String(), usage, nil
+		}
+
+		var chunk chatStreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return "", usage, fmt.Errorf("failed to decode stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return "", usage, fmt.Errorf("stream error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			content.WriteString(choice.Delta.Content)
+			onContent(choice.Delta.Content)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", usage, fmt.Errorf("failed to read stream: %w", err)
+	}
+	return content.String(), usage, nil
+}
+
+// messageStreamer picks the value of the "message" field out of a JSON
+// object arriving in fragments and passes the decoded text on as soon as
+// it is complete enough to decode.
+type messageStreamer struct {
+	buf     string
+	pos     int
+	inValue bool
+	done    bool
+	onText  func(string)
+}
+
+func newMessageStreamer(onText func(string)) *messageStreamer {
+	return &messageStreamer{onText: onText}
+}
+
+func (ms *messageStreamer) Write(fragment string) {
+	if ms.done {
+		return
+	}
+	ms.buf += fragment
+
+	if !ms.inValue {
+		key := strings.Index(ms.buf, `"message"`)
+		if key < 0 {
+			return
+		}
+		rest := strings.TrimLeft(ms.buf[key+len(`"message"`):], " \t\r\n")
+		if !strings.HasPrefix(rest, ":") {
+			return
+		}
+		rest = strings.TrimLeft(rest[1:], " \t\r\n")
+		if !strings.HasPrefix(rest, `"`) {
+			return
+		}
+		ms.inValue = true
+		ms.pos = len(ms.buf) - len(rest) + 1
+	}
+
+	var text strings.Builder
+	for ms.pos < len(ms.buf) {
+		c := ms.buf[ms.pos]
+		if c == '"' {
+			ms.done = true
+			break
+		}
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(ms.buf[ms.pos:])
+			if r == utf8.RuneError && size <= 1 && !utf8.FullRuneInString(ms.buf[ms.pos:]) {
+				break
+			}
+			text.WriteString(ms.buf[ms.pos : ms.pos+size])
+			ms.pos += size
+			continue
+		}
+
+		// Escapes are decoded only once they have fully arrived.
+		if ms.pos+1 >= len(ms.buf) {
+			break
+		}
+		size := 2
+		if ms.buf[ms.pos+1] == 'u' {
+			size = 6
+			if ms.pos+size > len(ms.buf) {
+				break
+			}
+			// A high surrogate needs its low half to decode.
+			if code, err := strconv.ParseUint(ms.buf[ms.pos+2:ms.pos+6], 16, 16); err == nil && code >= 0xD800 && code < 0xDC00 {
+				size = 12
+				if ms.pos+size > len(ms.buf) {
+					break
+				}
+			}
+		}
+		var decoded string
+		if err := json.Unmarshal([]byte(`"`+ms.buf[ms.pos:ms.pos+size]+`"`), &decoded); err != nil {
+			decoded = ms.buf[ms.pos : ms.pos+size]
+		}
+		text.WriteString(decoded)
+		ms.pos += size
+	}
+
+	if text.Len() > 0 {
+		ms.onText(text.String())
+	}
+}
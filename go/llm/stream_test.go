@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func TestMessageStreamerDecodesFragments(t *testing.T) {
+	content := `{"message":"慢一点，\"按钮\"\n不会跑 😀","state_change":true,"urgency":"low"}`
+
+	// Feed the content in every split size, including ones that cut
+	// through escapes and multi-byte characters.
+	for size := 1; size <= 8; size++ {
+		var got strings.Builder
+		ms := newMessageStreamer(func(text string) { got.WriteString(text) })
+		for i := 0; i < len(content); i += size {
+			end := i + size
+			if end > len(content) {
+				end = len(content)
+			}
+			ms.Write(content[i:end])
+		}
+		if want := "慢一点，\"按钮\"\n不会跑 😀"; got.String() != want {
+			t.Fatalf("split %d: got %q, want %q", size, got.String(), want)
+		}
+	}
+}
+
+func TestReadChatStream(t *testing.T) {
+	body := strings.Join([]string{
+		": keep-alive",
+		`data: {"choices":[{"delta":{"role":"assistant"}}]}`,
+		"",
+		`data: {"choices":[{"delta":{"content":"{\"message\":\"你"}}]}`,
+		"",
+		`data: {"choices":[{"delta":{"content":"好\",\"state_change\":false,\"urgency\":\"low\"}"}}]}`,
+		"",
+		`data: {"choices":[],"usage":{"prompt_tokens":120,"completion_tokens":18,"total_tokens":138}}`,
+		"",
+		"data: [DONE]",
+		"",
+	}, "\n")
+
+	var fragments []string
+	content, usage, err := readChatStream(strings.NewReader(body), func(s string) { fragments = append(fragments, s) })
+	if err != nil {
+		t.Fatalf("failed to read stream: %v", err)
+	}
+	if usage == nil || usage.PromptTokens != 120 || usage.CompletionTokens != 18 {
+		t.Fatalf("expected the usage of the last chunk, got %+v", usage)
+	}
+	if len(fragments) != 2 || content != `{"message":"你好","state_change":false,"urgency":"low"}` {
+		t.Fatalf("unexpected content %q from %d fragments", content, len(fragments))
+	}
+
+	if _, _, err := readChatStream(strings.NewReader(`data: {"error":{"message":"overloaded"}}`), func(string) {}); err == nil {
+		t.Fatal("expected a stream error to fail the read")
+	}
+}
+
+func TestOpenAIClientStreams(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, piece := range []string{`{\"message\":\"停`, `一停\",\"state_change\":true,`, `\"new_state\":\"taking_break\",\"urgency\":\"low\"}`} {
+			w.Write([]byte(`data: {"choices":[{"delta":{"content":"` + piece + `"}}]}` + "\n\n"))
+		}
+		w.Write([]byte("data: [DONE]\n\n"))
+	}))
+	defer server.Close()
+
+	client, err := NewOpenAIClient(testOpenAIConfig(server.URL))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	var deltas []string
+	resp, err := client.AnalyzeUserStateStream(context.Background(), &game.UserState{}, nil, AnalysisOptions{}, func(s string) {
+		deltas = append(deltas, s)
+	})
+	if err != nil {
+		t.Fatalf("streamed analysis failed: %v", err)
+	}
+	if resp.Message != "停一停" || strings.Join(deltas, "") != "停一停" || len(deltas) != 2 {
+		t.Fatalf("unexpected response %+v from deltas %q", resp, deltas)
+	}
+}
+
+func TestAnalyzerStreamsDeltasBeforeResult(t *testing.T) {
+	sa := testAnalyzer(NewScriptedProvider(&ProviderScript{
+		Default: LLMResponse{Message: "你好呀。再见", StateChange: true, NewState: game.StateConfused, Urgency: "low"},
+	}))
+	sa.cfg.LLMStreaming = true
+	sa.cfg.AnalysisIntervalSeconds = time.Hour
+	sa.cfg.HeuristicPrefilter = false
+	sa.Start()
+	defer sa.Stop()
+
+	sa.analyzeRequests([]*AnalysisRequest{{SessionID: "s1", UserState: &game.UserState{CurrentState: "new"}}})
+
+	var result *AnalysisResult
+	select {
+	case result = <-sa.GetResults():
+	case <-time.After(2 * time.Second):
+		t.Fatal("no result")
+	}
+	if result.StreamID == "" {
+		t.Fatalf("expected a streamed result: %+v", result)
+	}
+
+	var text strings.Builder
+	for seq := 1; seq <= 3; seq++ {
+		select {
+		case delta := <-sa.Deltas():
+			if delta.StreamID != result.StreamID || delta.Seq != seq {
+				t.Fatalf("unexpected delta %+v", delta)
+			}
+			text.WriteString(delta.Text)
+		default:
+			t.Fatalf("delta %d should be queued before the result", seq)
+		}
+	}
+	if text.String() != result.Response.Message {
+		t.Fatalf("deltas %q do not add up to %q", text.String(), result.Response.Message)
+	}
+}
@@ -0,0 +1,358 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+//go:embed prompts/*.tmpl
+var builtinTemplates embed.FS
+
+const (
+	systemTemplateFile = "system.tmpl"
+	userTemplateFile   = "user.tmpl"
+)
+
+// PromptData is what the prompt templates are executed with.
+//
+//   - UserState is the session's game.UserState: Stage, Clicks,
+//     EngagementRate, ClicksPerSecond, IdleSeconds, PrestigeCount and the rest.
+//   - RecentActions are the latest actions, oldest first.
+//   - Purchases are the items bought this session, by item ID.
+//   - Locale is the language the message should be written for, e.g. "zh-CN".
+//   - Variant is the session's experiment variant, empty outside experiments.
+//   - Nudge is the purchase nudge instruction, empty when there is none.
+//
+// Besides the text/template builtins, templates may use add and hasPrefix.
+type PromptData struct {
+	UserState     *game.UserState
+	RecentActions []PromptAction
+	Purchases     []PromptPurchase
+	Locale        string
+	Variant       string
+	Nudge         string
+}
+
+type PromptAction struct {
+	Stage      int
+	Clicks     int
+	AgoSeconds float64
+}
+
+type PromptPurchase struct {
+	ItemID   int
+	Category string
+	Count    int
+}
+
+// RenderedPrompt is the text sent for one call and the version of the
+// templates that produced it.
+type RenderedPrompt struct {
+	System  string
+	User    string
+	Version string
+}
+
+// PromptSet is one loaded version of the templates.
+type PromptSet struct {
+	Version  string    `json:"version"`
+	LoadedAt time.Time `json:"loaded_at"`
+	System   string    `json:"system"`
+	User     string    `json:"user"`
+
+	system *template.Template
+	user   *template.Template
+}
+
+var templateFuncs = template.FuncMap{
+	"add":       func(a, b int) int { return a + b },
+	"hasPrefix": strings.HasPrefix,
+}
+
+// parsePromptSet parses and validates a pair of templates. The version is
+// derived from their content, so the same files always get the same one.
+func parsePromptSet(systemSrc, userSrc string, now time.Time) (*PromptSet, error) {
+	system, err := template.New(systemTemplateFile).Funcs(templateFuncs).Option("missingkey=error").Parse(systemSrc)
+	if err != nil {
+		return nil, fmt.Errorf("system prompt template: %w", err)
+	}
+	user, err := template.New(userTemplateFile).Funcs(templateFuncs).Option("missingkey=error").Parse(userSrc)
+	if err != nil {
+		return nil, fmt.Errorf("user prompt template: %w", err)
+	}
+
+	sum := sha256.Sum256([]byte(systemSrc + "\x00" + userSrc))
+	set := &PromptSet{
+		Version:  hex.EncodeToString(sum[:])[:12],
+		LoadedAt: now,
+		System:   systemSrc,
+		User:     userSrc,
+		system:   system,
+		user:     user,
+	}
+	if err := set.validate(); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// validate renders the templates with sample data. Both must produce text,
+// and the user prompt must pass a nudge instruction on, or instructed
+// purchase nudges would silently vanish.
+func (ps *PromptSet) validate() error {
+	const sentinel = "NUDGE-INSTRUCTION-CHECK"
+	data := &PromptData{
+		UserState:     &game.UserState{Stage: 120, Clicks: 340, CurrentState: game.StateProductive},
+		RecentActions: []PromptAction{{Stage: 100, Clicks: 300, AgoSeconds: 12}},
+		Purchases:     []PromptPurchase{{ItemID: 1, Category: game.GetItemCategory(1), Count: 1}},
+		Locale:        "zh-CN",
+		Nudge:         sentinel,
+	}
+
+	system, user, err := ps.execute(data)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(system) == "" || strings.TrimSpace(user) == "" {
+		return fmt.Errorf("prompt templates must not render empty")
+	}
+	if !strings.Contains(user, sentinel) {
+		return fmt.Errorf("user prompt template must include {{.Nudge}}")
+	}
+	return nil
+}
+
+func (ps *PromptSet) execute(data *PromptData) (string, string, error) {
+	var system, user bytes.Buffer
+	if err := ps.system.Execute(&system, data); err != nil {
+		return "", "", fmt.Errorf("system prompt template: %w", err)
+	}
+	if err := ps.user.Execute(&user, data); err != nil {
+		return "", "", fmt.Errorf("user prompt template: %w", err)
+	}
+	return system.String(), user.String(), nil
+}
+
+// Render builds the prompts for one call. A variant's system prompt
+// replaces the system template.
+func (ps *PromptSet) Render(userState *game.UserState, recentActions []game.UserAction, opts AnalysisOptions) (*RenderedPrompt, error) {
+	locale := opts.Locale
+	if locale == "" {
+		locale = defaultLocale
+	}
+	data := &PromptData{
+		UserState: userState,
+		Locale:    locale,
+		Variant:   opts.Variant,
+	}
+	now := time.Now()
+	for _, a := range recentActions {
+		data.RecentActions = append(data.RecentActions, PromptAction{
+			Stage:      a.Stage,
+			Clicks:     a.Clicks,
+			AgoSeconds: now.Sub(a.ServerTimestamp).Seconds(),
+		})
+	}
+	for itemID, count := range opts.Inventory {
+		data.Purchases = append(data.Purchases, PromptPurchase{ItemID: itemID, Category: game.GetItemCategory(itemID), Count: count})
+	}
+	sort.Slice(data.Purchases, func(i, j int) bool { return data.Purchases[i].ItemID < data.Purchases[j].ItemID })
+	if opts.Nudge != nil {
+		data.Nudge = opts.Nudge.Instruction
+	}
+
+	system, user, err := ps.execute(data)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SystemPrompt != "" {
+		system = opts.SystemPrompt
+	}
+	return &RenderedPrompt{System: system, User: user, Version: ps.Version}, nil
+}
+
+const defaultLocale = "zh-CN"
+
+// builtinPrompts are the embedded templates, used when no prompt directory
+// is configured and by providers called outside the analyzer.
+var builtinPrompts = func() *PromptSet {
+	system, _ := builtinTemplates.ReadFile("prompts/" + systemTemplateFile)
+	user, _ := builtinTemplates.ReadFile("prompts/" + userTemplateFile)
+	set, err := parsePromptSet(string(system), string(user), time.Now())
+	if err != nil {
+		panic(fmt.Sprintf("built-in prompt templates: %v", err))
+	}
+	return set
+}()
+
+// PromptStore holds the active templates and the versions loaded before
+// them. Templates come from dir, or from the built-in set when dir is
+// empty; Reload swaps in edited files only if they validate.
+type PromptStore struct {
+	dir      string
+	current  *PromptSet
+	history  []*PromptSet
+	keep     int
+	modTimes map[string]time.Time
+	mu       sync.RWMutex
+}
+
+func NewPromptStore(dir string, keep int) (*PromptStore, error) {
+	ps := &PromptStore{dir: dir, keep: keep}
+	if dir == "" {
+		ps.current = builtinPrompts
+		ps.history = []*PromptSet{builtinPrompts}
+		return ps, nil
+	}
+	if err := ps.Reload(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Reload reads the templates from disk. On any error the active set stays
+// in place. Loading unchanged files keeps the current version.
+func (ps *PromptStore) Reload() error {
+	if ps.dir == "" {
+		return nil
+	}
+
+	modTimes, err := ps.statFiles()
+	if err != nil {
+		return err
+	}
+	set, err := ps.readSet()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	// A broken edit is reported once rather than on every check.
+	ps.modTimes = modTimes
+	if err != nil {
+		return err
+	}
+	if ps.current != nil && ps.current.Version == set.Version {
+		return nil
+	}
+	ps.current = set
+	ps.history = append(ps.history, set)
+	if ps.keep > 0 && len(ps.history) > ps.keep {
+		ps.history = ps.history[len(ps.history)-ps.keep:]
+	}
+	log.Printf("Loaded prompt templates version %s from %s", set.Version, ps.dir)
+	return nil
+}
+
+func (ps *PromptStore) readSet() (*PromptSet, error) {
+	system, err := os.ReadFile(filepath.Join(ps.dir, systemTemplateFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read system prompt template: %w", err)
+	}
+	user, err := os.ReadFile(filepath.Join(ps.dir, userTemplateFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user prompt template: %w", err)
+	}
+	return parsePromptSet(string(system), string(user), time.Now())
+}
+
+func (ps *PromptStore) statFiles() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+	for _, name := range []string{systemTemplateFile, userTemplateFile} {
+		info, err := os.Stat(filepath.Join(ps.dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("prompt template %s: %w", name, err)
+		}
+		modTimes[name] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+// Changed reports whether a template file was modified since the last load.
+func (ps *PromptStore) Changed() bool {
+	if ps.dir == "" {
+		return false
+	}
+	modTimes, err := ps.statFiles()
+	if err != nil {
+		return false
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for name, t := range modTimes {
+		if !t.Equal(ps.modTimes[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the templates whenever their files change, checking every
+// interval until stop is closed.
+func (ps *PromptStore) Watch(interval time.Duration, stop <-chan struct{}) {
+	if ps.dir == "" || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if !ps.Changed() {
+				continue
+			}
+			if err := ps.Reload(); err != nil {
+				log.Printf("Prompt templates not reloaded, keeping version %s: %v", ps.Current().Version, err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (ps *PromptStore) Current() *PromptSet {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	return ps.current
+}
+
+// Version returns a loaded set by version, for auditing which templates
+// produced a message.
+func (ps *PromptStore) Version(version string) (*PromptSet, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for _, set := range ps.history {
+		if set.Version == version {
+			return set, true
+		}
+	}
+	return nil, false
+}
+
+// History lists the loaded versions, oldest first.
+func (ps *PromptStore) History() []*PromptSet {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	history := make([]*PromptSet, len(ps.history))
+	copy(history, ps.history)
+	return history
+}
@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/nudge"
+)
+
+func TestBuiltinPromptsRender(t *testing.T) {
+	prompt, err := builtinPrompts.Render(
+		&game.UserState{Stage: 42, Clicks: 99, IdleSeconds: 7},
+		[]game.UserAction{{Stage: 40, Clicks: 90, ServerTimestamp: time.Now()}},
+		AnalysisOptions{
+			Inventory: map[int]int{1: 2},
+			Nudge:     &nudge.Decision{Instruction: "请轻轻提一下商店。"},
+		},
+	)
+	if err != nil {
+		t.Fatalf("failed to render: %v", err)
+	}
+
+	for _, want := range []string{"- Stage: 42", "1. Stage: 40, Clicks: 90", "auto_clicker (item 1) x2", "请轻轻提一下商店。", "Chinese preferred"} {
+		if !strings.Contains(prompt.User, want) {
+			t.Errorf("user prompt is missing %q:\n%s", want, prompt.User)
+		}
+	}
+	if !strings.Contains(prompt.System, "JSON") || prompt.Version != builtinPrompts.Version {
+		t.Fatalf("unexpected system prompt or version: %+v", prompt)
+	}
+
+	override, _ := builtinPrompts.Render(&game.UserState{}, nil, AnalysisOptions{SystemPrompt: "variant persona"})
+	if override.System != "variant persona" {
+		t.Fatalf("a variant's system prompt should replace the template, got %q", override.System)
+	}
+}
+
+func TestPromptSetValidation(t *testing.T) {
+	if _, err := parsePromptSet("persona", "{{.Missing}}", time.Now()); err == nil {
+		t.Fatal("unknown fields should fail validation")
+	}
+	if _, err := parsePromptSet("persona", "state {{.UserState.Stage}}", time.Now()); err == nil {
+		t.Fatal("a user template without the nudge should fail validation")
+	}
+	if _, err := parsePromptSet("persona", "{{if .Nudge}", time.Now()); err == nil {
+		t.Fatal("a syntax error should fail parsing")
+	}
+
+	a, err := parsePromptSet("persona", "{{.Nudge}}", time.Now())
+	if err != nil {
+		t.Fatalf("valid templates rejected: %v", err)
+	}
+	b, _ := parsePromptSet("persona", "{{.Nudge}}", time.Now())
+	c, _ := parsePromptSet("persona 2", "{{.Nudge}}", time.Now())
+	if a.Version != b.Version || a.Version == c.Version {
+		t.Fatalf("versions should follow content: %s %s %s", a.Version, b.Version, c.Version)
+	}
+}
+
+func TestPromptStoreReload(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string, mod time.Time) {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, mod, mod)
+	}
+
+	start := time.Now().Add(-time.Hour)
+	write(systemTemplateFile, "persona", start)
+	write(userTemplateFile, "stage {{.UserState.Stage}} {{.Nudge}}", start)
+
+	store, err := NewPromptStore(dir, 10)
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	first := store.Current().Version
+	if store.Changed() {
+		t.Fatal("nothing changed yet")
+	}
+
+	write(userTemplateFile, "{{.Broken", start.Add(time.Minute))
+	if !store.Changed() {
+		t.Fatal("the edit should be noticed")
+	}
+	if err := store.Reload(); err == nil || store.Current().Version != first {
+		t.Fatalf("a broken edit should keep the current version, got %v", err)
+	}
+	if store.Changed() {
+		t.Fatal("a broken edit should be reported only once")
+	}
+
+	write(userTemplateFile, "level {{.UserState.Stage}} {{.Nudge}}", start.Add(2*time.Minute))
+	if err := store.Reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	if store.Current().Version == first || len(store.History()) != 2 {
+		t.Fatalf("expected a new version in the history, got %+v", store.History())
+	}
+	if old, ok := store.Version(first); !ok || !strings.Contains(old.User, "stage") {
+		t.Fatal("earlier versions should stay available")
+	}
+}
@@ -0,0 +1,405 @@
+package llm
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChatUsage is the token count of an OpenAI-style chat completion.
+type ChatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// TokenUsage is what one provider call spent.
+type TokenUsage struct {
+	Model            string `json:"model"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+}
+
+// reportUsage passes a call's token count to the caller, if it asked.
+func reportUsage(opts AnalysisOptions, model string, promptTokens, completionTokens int) {
+	if opts.OnUsage == nil || promptTokens+completionTokens == 0 {
+		return
+	}
+	opts.OnUsage(TokenUsage{Model: model, PromptTokens: promptTokens, CompletionTokens: completionTokens})
+}
+
+// ModelPrice is the cost of a model in USD per million tokens.
+type ModelPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// defaultPricing covers the hosted models the server ships with. Models
+// missing here and from LLM_PRICING are counted at no cost.
+var defaultPricing = map[string]ModelPrice{
+	"deepseek-chat":     {Input: 0.27, Output: 1.10},
+	"deepseek-reasoner": {Input: 0.55, Output: 2.19},
+}
+
+// ParsePricing reads "model=input/output,..." prices on top of the
+// defaults.
+func ParsePricing(spec string) (map[string]ModelPrice, error) {
+	pricing := make(map[string]ModelPrice, len(defaultPricing))
+	for model, price := range defaultPricing {
+		pricing[model] = price
+	}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		model, prices, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid price %q, want model=input/output", part)
+		}
+		input, output, ok := strings.Cut(prices, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid price %q, want model=input/output", part)
+		}
+		in, err := strconv.ParseFloat(strings.TrimSpace(input), 64)
+		if err != nil || in < 0 {
+			return nil, fmt.Errorf("invalid input price for %s: %q", model, input)
+		}
+		out, err := strconv.ParseFloat(strings.TrimSpace(output), 64)
+		if err != nil || out < 0 {
+			return nil, fmt.Errorf("invalid output price for %s: %q", model, output)
+		}
+		pricing[strings.TrimSpace(model)] = ModelPrice{Input: in, Output: out}
+	}
+	return pricing, nil
+}
+
+// BudgetLevel is how far spending has gone into the budget. Each level
+// keeps the degradations of the ones before it.
+type BudgetLevel string
+
+const (
+	BudgetNormal BudgetLevel = "normal"
+	// BudgetStretched lengthens analysis intervals.
+	BudgetStretched BudgetLevel = "stretched"
+	// BudgetEconomy also switches to the cheaper model.
+	BudgetEconomy BudgetLevel = "economy"
+	// BudgetExhausted stops provider calls; the template lines of the
+	// heuristic provider answer instead.
+	BudgetExhausted BudgetLevel = "exhausted"
+)
+
+// BudgetSettings are spending limits in USD. A zero limit is not enforced.
+// StretchAt and EconomyAt are the fractions of a limit at which those
+// levels begin; the budget is exhausted at the limit itself. Models are
+// the cheaper models used in economy, keyed by provider name.
+type BudgetSettings struct {
+	Daily     float64           `json:"daily_usd"`
+	Monthly   float64           `json:"monthly_usd"`
+	StretchAt float64           `json:"stretch_at"`
+	EconomyAt float64           `json:"economy_at"`
+	Models    map[string]string `json:"economy_models"`
+}
+
+// ParseBudgetModels reads "provider=model,..." economy models.
+func ParseBudgetModels(spec string) (map[string]string, error) {
+	models := make(map[string]string)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		provider, model, ok := strings.Cut(part, "=")
+		if !ok || strings.TrimSpace(provider) == "" || strings.TrimSpace(model) == "" {
+			return nil, fmt.Errorf("invalid economy model %q, want provider=model", part)
+		}
+		models[strings.TrimSpace(provider)] = strings.TrimSpace(model)
+	}
+	return models, nil
+}
+
+// UsageTotals add up calls, tokens and estimated cost.
+type UsageTotals struct {
+	Calls            int64   `json:"calls"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	CostUSD          float64 `json:"cost_usd"`
+}
+
+func (ut *UsageTotals) add(call CallUsage) {
+	ut.Calls++
+	ut.PromptTokens += int64(call.PromptTokens)
+	ut.CompletionTokens += int64(call.CompletionTokens)
+	ut.CostUSD += call.CostUSD
+}
+
+// CallUsage is one accounted provider call.
+type CallUsage struct {
+	SessionID        string    `json:"session_id"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	CostUSD          float64   `json:"cost_usd"`
+	Time             time.Time `json:"time"`
+}
+
+// UsagePoint is the usage of one hour or day, starting at Start.
+type UsagePoint struct {
+	Start time.Time `json:"start"`
+	UsageTotals
+}
+
+// SessionUsage is the usage of one live session.
+type SessionUsage struct {
+	SessionID string `json:"session_id"`
+	UsageTotals
+}
+
+// BudgetStatus is the spend against the limits.
+type BudgetStatus struct {
+	BudgetSettings
+	TodayUSD     float64     `json:"today_usd"`
+	ThisMonthUSD float64     `json:"this_month_usd"`
+	Level        BudgetLevel `json:"level"`
+}
+
+// UsageReport is the accounting shown by the usage endpoint.
+type UsageReport struct {
+	Total       UsageTotals            `json:"total"`
+	Budget      BudgetStatus           `json:"budget"`
+	Models      map[string]UsageTotals `json:"models"`
+	Pricing     map[string]ModelPrice  `json:"pricing"`
+	Hourly      []UsagePoint           `json:"hourly"`
+	Daily       []UsagePoint           `json:"daily"`
+	Sessions    []SessionUsage         `json:"sessions"`
+	RecentCalls []CallUsage            `json:"recent_calls"`
+}
+
+const (
+	usageHoursKept = 48
+	usageDaysKept  = 92
+	// usageTopSessions is how many of the most expensive live sessions
+	// the report lists.
+	usageTopSessions = 10
+)
+
+// UsageMeter accounts the tokens spent by provider calls, globally, per
+// model and per session, and estimates their cost. Days and months are
+// counted in UTC. Nothing survives a restart.
+type UsageMeter struct {
+	pricing   map[string]ModelPrice
+	budget    BudgetSettings
+	total     UsageTotals
+	models    map[string]*UsageTotals
+	sessions  map[string]*UsageTotals
+	hourly    []UsagePoint
+	daily     []UsagePoint
+	calls     []CallUsage
+	keepCalls int
+	level     BudgetLevel
+	unpriced  map[string]bool
+	mu        sync.Mutex
+}
+
+func NewUsageMeter(pricing map[string]ModelPrice, budget BudgetSettings, keepCalls int) *UsageMeter {
+	if pricing == nil {
+		pricing = defaultPricing
+	}
+	return &UsageMeter{
+		pricing:   pricing,
+		budget:    budget,
+		models:    make(map[string]*UsageTotals),
+		sessions:  make(map[string]*UsageTotals),
+		keepCalls: keepCalls,
+		level:     BudgetNormal,
+		unpriced:  make(map[string]bool),
+	}
+}
+
+// Cost estimates what a call cost.
+func (um *UsageMeter) Cost(usage TokenUsage) float64 {
+	price := um.pricing[usage.Model]
+	return (float64(usage.PromptTokens)*price.Input + float64(usage.CompletionTokens)*price.Output) / 1e6
+}
+
+// Record accounts a call made for a session.
+func (um *UsageMeter) Record(sessionID string, usage TokenUsage, now time.Time) CallUsage {
+	call := CallUsage{
+		SessionID:        sessionID,
+		Model:            usage.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		CostUSD:          um.Cost(usage),
+		Time:             now,
+	}
+
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	if _, priced := um.pricing[usage.Model]; !priced && !um.unpriced[usage.Model] {
+		um.unpriced[usage.Model] = true
+		log.Printf("No price for model %s, its calls are counted at no cost", usage.Model)
+	}
+
+	um.total.add(call)
+	if um.models[call.Model] == nil {
+		um.models[call.Model] = &UsageTotals{}
+	}
+	um.models[call.Model].add(call)
+	if um.sessions[sessionID] == nil {
+		um.sessions[sessionID] = &UsageTotals{}
+	}
+	um.sessions[sessionID].add(call)
+
+	um.hourly = addToSeries(um.hourly, now.UTC().Truncate(time.Hour), call, usageHoursKept)
+	um.daily = addToSeries(um.daily, startOfDay(now), call, usageDaysKept)
+
+	um.calls = append(um.calls, call)
+	if um.keepCalls > 0 && len(um.calls) > um.keepCalls {
+		um.calls = um.calls[len(um.calls)-um.keepCalls:]
+	}
+
+	um.updateLevel(now)
+	return call
+}
+
+func addToSeries(series []UsagePoint, start time.Time, call CallUsage, keep int) []UsagePoint {
+	if n := len(series); n == 0 || series[n-1].Start.Before(start) {
+		series = append(series, UsagePoint{Start: start})
+	}
+	series[len(series)-1].add(call)
+	if len(series) > keep {
+		series = series[len(series)-keep:]
+	}
+	return series
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Level reports how far spending has gone into the budget.
+func (um *UsageMeter) Level(now time.Time) BudgetLevel {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	return um.updateLevel(now)
+}
+
+// EconomyModels returns the models used at the economy level. The map is
+// shared and must not be modified.
+func (um *UsageMeter) EconomyModels() map[string]string {
+	return um.budget.Models
+}
+
+// updateLevel recomputes the budget level, which also drops back when a
+// new day or month begins. The caller must hold the lock.
+func (um *UsageMeter) updateLevel(now time.Time) BudgetLevel {
+	today, month := um.spend(now)
+
+	used := 0.0
+	if um.budget.Daily > 0 {
+		used = today / um.budget.Daily
+	}
+	if um.budget.Monthly > 0 && month/um.budget.Monthly > used {
+		used = month / um.budget.Monthly
+	}
+
+	level := BudgetNormal
+	switch {
+	case used >= 1:
+		level = BudgetExhausted
+	case um.budget.EconomyAt > 0 && used >= um.budget.EconomyAt:
+		level = BudgetEconomy
+	case um.budget.StretchAt > 0 && used >= um.budget.StretchAt:
+		level = BudgetStretched
+	}
+	if level != um.level {
+		log.Printf("LLM budget level %s -> %s (today $%.4f, this month $%.4f)", um.level, level, today, month)
+		um.level = level
+	}
+	return level
+}
+
+// spend returns the cost of today and of this month so far. The caller
+// must hold the lock.
+func (um *UsageMeter) spend(now time.Time) (float64, float64) {
+	day := startOfDay(now)
+	month := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	today, thisMonth := 0.0, 0.0
+	for _, point := range um.daily {
+		if point.Start.Equal(day) {
+			today = point.CostUSD
+		}
+		if !point.Start.Before(month) {
+			thisMonth += point.CostUSD
+		}
+	}
+	return today, thisMonth
+}
+
+// Session returns the usage of a live session.
+func (um *UsageMeter) Session(sessionID string) (UsageTotals, bool) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	totals, exists := um.sessions[sessionID]
+	if !exists {
+		return UsageTotals{}, false
+	}
+	return *totals, true
+}
+
+// Forget drops the per-session totals of a session that has ended. Its
+// calls stay in the global, model and time series totals.
+func (um *UsageMeter) Forget(sessionID string) {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	delete(um.sessions, sessionID)
+}
+
+func (um *UsageMeter) Report(now time.Time) UsageReport {
+	um.mu.Lock()
+	defer um.mu.Unlock()
+
+	today, month := um.spend(now)
+	report := UsageReport{
+		Total: um.total,
+		Budget: BudgetStatus{
+			BudgetSettings: um.budget,
+			TodayUSD:       today,
+			ThisMonthUSD:   month,
+			Level:          um.updateLevel(now),
+		},
+		Models:      make(map[string]UsageTotals, len(um.models)),
+		Pricing:     um.pricing,
+		Hourly:      append([]UsagePoint(nil), um.hourly...),
+		Daily:       append([]UsagePoint(nil), um.daily...),
+		RecentCalls: append([]CallUsage(nil), um.calls...),
+	}
+	for model, totals := range um.models {
+		report.Models[model] = *totals
+	}
+
+	for id, totals := range um.sessions {
+		report.Sessions = append(report.Sessions, SessionUsage{SessionID: id, UsageTotals: *totals})
+	}
+	sort.Slice(report.Sessions, func(i, j int) bool {
+		if report.Sessions[i].CostUSD != report.Sessions[j].CostUSD {
+			return report.Sessions[i].CostUSD > report.Sessions[j].CostUSD
+		}
+		return report.Sessions[i].PromptTokens+report.Sessions[i].CompletionTokens >
+			report.Sessions[j].PromptTokens+report.Sessions[j].CompletionTokens
+	})
+	if len(report.Sessions) > usageTopSessions {
+		report.Sessions = report.Sessions[:usageTopSessions]
+	}
+	return report
+}
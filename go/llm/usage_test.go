@@ -0,0 +1,115 @@
+package llm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func TestParsePricing(t *testing.T) {
+	pricing, err := ParsePricing("gpt-4o-mini=0.15/0.60, deepseek-chat = 0.5/2")
+	if err != nil {
+		t.Fatalf("failed to parse pricing: %v", err)
+	}
+	if pricing["gpt-4o-mini"] != (ModelPrice{Input: 0.15, Output: 0.6}) || pricing["deepseek-chat"].Output != 2 {
+		t.Fatalf("unexpected pricing: %+v", pricing)
+	}
+	if _, exists := pricing["deepseek-reasoner"]; !exists {
+		t.Fatal("configured prices should extend the defaults")
+	}
+	if _, err := ParsePricing("gpt-4o-mini=0.15"); err == nil {
+		t.Fatal("expected a price without an output half to fail")
+	}
+}
+
+func TestUsageMeterBudgetLevels(t *testing.T) {
+	// 1000 tokens cost a dollar.
+	um := NewUsageMeter(map[string]ModelPrice{"m": {Input: 1000, Output: 1000}},
+		BudgetSettings{Daily: 1, StretchAt: 0.8, EconomyAt: 0.9}, 2)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	um.Record("s1", TokenUsage{Model: "m", PromptTokens: 700, CompletionTokens: 100}, now)
+	if level := um.Level(now); level != BudgetStretched {
+		t.Fatalf("80%% of the budget should stretch intervals, got %s", level)
+	}
+	um.Record("s2", TokenUsage{Model: "m", PromptTokens: 100}, now.Add(time.Hour))
+	if level := um.Level(now.Add(time.Hour)); level != BudgetEconomy {
+		t.Fatalf("90%% of the budget should switch models, got %s", level)
+	}
+	um.Record("s1", TokenUsage{Model: "unpriced", PromptTokens: 5000}, now.Add(time.Hour))
+	um.Record("s2", TokenUsage{Model: "m", CompletionTokens: 100}, now.Add(time.Hour))
+	if level := um.Level(now.Add(time.Hour)); level != BudgetExhausted {
+		t.Fatalf("the full budget should stop provider calls, got %s", level)
+	}
+	if level := um.Level(now.Add(24 * time.Hour)); level != BudgetNormal {
+		t.Fatalf("a new day should start a new daily budget, got %s", level)
+	}
+
+	report := um.Report(now.Add(time.Hour))
+	if report.Total.Calls != 4 || report.Models["unpriced"].CostUSD != 0 || report.Budget.TodayUSD < 0.999 {
+		t.Fatalf("unexpected totals: %+v", report)
+	}
+	if len(report.Hourly) != 2 || report.Hourly[1].Calls != 3 || len(report.Daily) != 1 {
+		t.Fatalf("unexpected series: %+v %+v", report.Hourly, report.Daily)
+	}
+	if len(report.RecentCalls) != 2 || len(report.Sessions) != 2 {
+		t.Fatalf("expected the last two calls and both sessions: %+v", report)
+	}
+
+	um.Forget("s1")
+	if _, exists := um.Session("s1"); exists {
+		t.Fatal("an ended session should be forgotten")
+	}
+}
+
+// meteredProvider reports a fixed token count for every call and keeps the
+// models it was asked for.
+type meteredProvider struct {
+	tokens int
+	models []string
+}
+
+func (mp *meteredProvider) AnalyzeUserState(_ context.Context, _ *game.UserState, _ []game.UserAction, opts AnalysisOptions) (*LLMResponse, error) {
+	mp.models = append(mp.models, opts.Models["deepseek"])
+	reportUsage(opts, "m", mp.tokens, 0)
+	return &LLMResponse{Message: "你很投入。", StateChange: true, NewState: game.StateProductive, Urgency: "low"}, nil
+}
+
+func (mp *meteredProvider) TestConnection(context.Context) error {
+	return nil
+}
+
+func TestAnalyzerDegradesWithBudget(t *testing.T) {
+	mp := &meteredProvider{tokens: 450}
+	sa := testAnalyzer(mp)
+	sa.cfg.AnalysisIntervalSeconds = 10 * time.Second
+	sa.usage = NewUsageMeter(map[string]ModelPrice{"m": {Input: 1000}}, BudgetSettings{
+		Daily: 1, StretchAt: 0.4, EconomyAt: 0.9, Models: map[string]string{"deepseek": "cheap"},
+	}, 10)
+
+	req := &AnalysisRequest{SessionID: "s1", UserState: &game.UserState{CurrentState: "new"}}
+	for i := 0; i < 3; i++ {
+		if _, err := sa.analyzeUserState(context.Background(), req); err != nil {
+			t.Fatalf("analysis %d failed: %v", i, err)
+		}
+	}
+	if len(mp.models) != 3 || mp.models[0] != "" || mp.models[2] != "cheap" {
+		t.Fatalf("the cheaper model should be used near the budget, got %q", mp.models)
+	}
+	if interval := sa.interval(req, BudgetStretched); interval != 20*time.Second {
+		t.Fatalf("a stretched budget should lengthen intervals, got %s", interval)
+	}
+
+	result, err := sa.analyzeUserState(context.Background(), req)
+	if err != nil {
+		t.Fatalf("analysis failed: %v", err)
+	}
+	if len(mp.models) != 3 || result.Source != "heuristic" {
+		t.Fatalf("an exhausted budget should answer with template lines: %+v", result)
+	}
+	if usage, _ := sa.usage.Session("s1"); usage.Calls != 3 || usage.PromptTokens != 1350 {
+		t.Fatalf("unexpected session usage: %+v", usage)
+	}
+}
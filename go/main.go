@@ -2,16 +2,28 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+	"github.com/ahpxex/xtion-hackathon/experiment"
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/idle"
+	"github.com/ahpxex/xtion-hackathon/leaderboard"
 	"github.com/ahpxex/xtion-hackathon/llm"
+	"github.com/ahpxex/xtion-hackathon/narrative"
+	"github.com/ahpxex/xtion-hackathon/nudge"
+	"github.com/ahpxex/xtion-hackathon/quest"
 	"github.com/ahpxex/xtion-hackathon/storage"
 	"github.com/ahpxex/xtion-hackathon/websocket"
 	"github.com/gin-gonic/gin"
@@ -24,6 +36,20 @@ type Application struct {
 	storage   *storage.MemoryStore
 	llmClient llm.LLMProvider
 	analyzer  *llm.StateAnalyzer
+	recorder  *llm.RecordingProvider
+	chain     *llm.FallbackProvider
+	cache     *llm.CachingProvider
+	usage     *llm.UsageMeter
+	prompts   *llm.PromptStore
+	stopWatch chan struct{}
+	board     *leaderboard.Service
+	events    *events.Dispatcher
+	narrative *narrative.Engine
+	exp       *experiment.Experiment
+	metrics   *experiment.Collector
+	quests    *quest.Service
+	idle      *idle.Watcher
+	nudges    *nudge.Engine
 	hub       *websocket.Hub
 }
 
@@ -49,21 +75,243 @@ func NewApplication() (*Application, error) {
 }
 
 func (app *Application) setupComponents() error {
-	app.storage = storage.NewMemoryStore(app.cfg.HistoryWindowSize)
+	app.storage = storage.NewMemoryStore(app.cfg.HistoryWindowSize, app.cfg.TimelineSize)
+	app.storage.GetStateManager().SetMachineRules(llm.NewStateMachineRulesFromConfig(app.cfg))
 
-	app.llmClient = llm.NewDeepSeekClient(app.cfg)
+	classifier := llm.NewClassifierFromConfig(app.cfg)
 
-	if err := app.llmClient.TestConnection(); err != nil {
-		log.Printf("Warning: DeepSeek connection test failed: %v", err)
+	chain, err := app.newProviderChain(classifier)
+	if err != nil {
+		return err
+	}
+	app.chain = chain
+	app.llmClient = chain
+
+	if app.cfg.LLMRecordPath != "" {
+		recorder, err := llm.NewRecordingProvider(app.llmClient, app.cfg.LLMRecordPath)
+		if err != nil {
+			return fmt.Errorf("failed to start recording: %w", err)
+		}
+		app.recorder = recorder
+		app.llmClient = recorder
+		log.Printf("Recording analyses to %s", app.cfg.LLMRecordPath)
+	}
+
+	if app.cfg.LLMCacheEnabled {
+		app.cache = llm.NewCachingProvider(app.llmClient, llm.CacheSettings{
+			TTL:       app.cfg.LLMCacheTTL,
+			Variety:   app.cfg.LLMCacheVariety,
+			MaxKeys:   app.cfg.LLMCacheMaxKeys,
+			StageBand: app.cfg.LLMCacheStageBand,
+		})
+		app.llmClient = app.cache
+		log.Printf("Caching responses for %s, %d per state signature", app.cfg.LLMCacheTTL, app.cfg.LLMCacheVariety)
+	}
+
+	if err := app.llmClient.TestConnection(context.Background()); err != nil {
+		log.Printf("Warning: %s connection test failed: %v", app.cfg.LLMProvider, err)
 		log.Println("Server will continue, but LLM analysis may not work")
 	}
 
-	app.analyzer = llm.NewStateAnalyzer(app.cfg, app.llmClient)
-	app.hub = websocket.NewHub(app.cfg, app.storage.GetStateManager(), app.analyzer)
+	policy, err := nudge.Load(app.cfg.NudgePolicyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load nudge policy: %w", err)
+	}
+	app.nudges = nudge.NewEngine(app.cfg, policy)
+
+	app.prompts, err = llm.NewPromptStore(app.cfg.PromptDir, app.cfg.PromptHistorySize)
+	if err != nil {
+		return fmt.Errorf("failed to load prompt templates: %w", err)
+	}
+	log.Printf("Using prompt templates version %s", app.prompts.Current().Version)
+
+	pricing, err := llm.ParsePricing(app.cfg.LLMPricing)
+	if err != nil {
+		return fmt.Errorf("failed to parse LLM pricing: %w", err)
+	}
+	economy, err := llm.ParseBudgetModels(app.cfg.LLMBudgetModels)
+	if err != nil {
+		return fmt.Errorf("failed to parse economy models: %w", err)
+	}
+	app.usage = llm.NewUsageMeter(pricing, llm.BudgetSettings{
+		Daily:     app.cfg.LLMBudgetDaily,
+		Monthly:   app.cfg.LLMBudgetMonthly,
+		StretchAt: app.cfg.LLMBudgetStretchAt,
+		EconomyAt: app.cfg.LLMBudgetEconomyAt,
+		Models:    economy,
+	}, app.cfg.LLMUsageCallsKept)
+	if app.cfg.LLMBudgetDaily+app.cfg.LLMBudgetMonthly > 0 && app.cfg.LLMBudgetEconomyAt > 0 {
+		if model, ok := app.providerModel(app.cfg.LLMProvider); ok && (economy[app.cfg.LLMProvider] == "" || economy[app.cfg.LLMProvider] == model) {
+			log.Printf("Warning: LLM_BUDGET_MODELS has no cheaper model for %s, the economy level will keep %s", app.cfg.LLMProvider, model)
+		}
+	}
+
+	app.analyzer = llm.NewStateAnalyzer(app.cfg, app.llmClient, classifier, app.nudges, app.prompts, app.usage)
+	board, err := leaderboard.NewService(app.cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create leaderboard: %w", err)
+	}
+	app.board = board
+
+	script := &narrative.Script{}
+	if app.cfg.NarrativeEnabled {
+		script, err = narrative.LoadScript(app.cfg.NarrativeScriptPath)
+		if err != nil {
+			return fmt.Errorf("failed to load narrative script: %w", err)
+		}
+	}
+	app.narrative = narrative.NewEngine(app.cfg, script)
+
+	app.exp, err = experiment.Load(app.cfg.ExperimentPath)
+	if err != nil {
+		return fmt.Errorf("failed to load experiment: %w", err)
+	}
+	app.metrics = experiment.NewCollector(app.exp)
+	log.Printf("Experiment %s running with %d variants", app.exp.Name, len(app.exp.Variants))
+
+	app.quests, err = quest.NewService(app.cfg, app.storage.GetPlayerStore(), quest.DefaultTemplates())
+	if err != nil {
+		return fmt.Errorf("failed to create quest service: %w", err)
+	}
+
+	app.idle = idle.NewWatcher(app.cfg)
+
+	app.events = events.NewDispatcher(app.cfg.EventQueueSize)
+	app.subscribeEvents()
+
+	app.hub = websocket.NewHub(app.cfg, app.storage.GetStateManager(), app.storage.GetPlayerStore(), app.analyzer, app.board, app.events, app.narrative, app.exp, app.quests, app.idle)
 
 	return nil
 }
 
+// newProviderChain puts the configured provider first and the fallbacks
+// after it, each behind its own circuit breaker.
+func (app *Application) newProviderChain(classifier *game.Classifier) (*llm.FallbackProvider, error) {
+	budgets, err := parseBudgets(app.cfg.LLMLatencyBudgets)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{app.cfg.LLMProvider}
+	seen := map[string]bool{app.cfg.LLMProvider: true}
+	for _, name := range strings.Split(app.cfg.LLMFallbacks, ",") {
+		if name = strings.TrimSpace(name); name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	links := make([]llm.ChainLink, 0, len(names))
+	for _, name := range names {
+		provider, err := app.newProvider(name, classifier)
+		if err != nil {
+			return nil, err
+		}
+		budget := app.cfg.LLMLatencyBudget
+		if b, exists := budgets[name]; exists {
+			budget = b
+		}
+		links = append(links, llm.ChainLink{Name: name, Provider: provider, Budget: budget})
+	}
+	log.Printf("LLM provider chain: %s", strings.Join(names, " -> "))
+
+	return llm.NewFallbackProvider(llm.BreakerSettings{
+		Failures: app.cfg.LLMBreakerFailures,
+		OpenFor:  app.cfg.LLMBreakerOpen,
+	}, links...)
+}
+
+func (app *Application) newProvider(name string, classifier *game.Classifier) (llm.LLMProvider, error) {
+	switch name {
+	case "heuristic":
+		log.Println("Using heuristic narrator, no LLM calls will be made")
+		return llm.NewHeuristicProvider(classifier), nil
+	case "openai":
+		client, err := llm.NewOpenAIClient(app.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OpenAI-compatible client: %w", err)
+		}
+		log.Printf("Using OpenAI-compatible endpoint %s", app.cfg.OpenAICompatBaseURL)
+		return client, nil
+	case "ollama":
+		client, err := llm.NewOllamaClient(app.cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Ollama client: %w", err)
+		}
+		log.Printf("Using local Ollama model %s at %s", app.cfg.OllamaModel, app.cfg.OllamaBaseURL)
+		return client, nil
+	case "scripted":
+		script, err := llm.LoadProviderScript(app.cfg.LLMScriptPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load provider script: %w", err)
+		}
+		log.Printf("Using scripted narrator with %d rules", len(script.Rules))
+		return llm.NewScriptedProvider(script), nil
+	case "replay":
+		replay, err := llm.LoadReplayProvider(app.cfg.LLMReplayPath, app.cfg.LLMReplayStrict)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load replay: %w", err)
+		}
+		log.Printf("Replaying recorded analyses from %s", app.cfg.LLMReplayPath)
+		return replay, nil
+	case "deepseek":
+		return llm.NewDeepSeekClient(app.cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %s", name)
+	}
+}
+
+// providerModel returns the model a provider is configured with, for the
+// providers that call one.
+func (app *Application) providerModel(name string) (string, bool) {
+	switch name {
+	case "deepseek":
+		return app.cfg.LLMModel, true
+	case "openai":
+		if app.cfg.OpenAICompatModel != "" {
+			return app.cfg.OpenAICompatModel, true
+		}
+		return app.cfg.LLMModel, true
+	case "ollama":
+		return app.cfg.OllamaModel, true
+	default:
+		return "", false
+	}
+}
+
+// parseBudgets reads per-provider latency budgets written as
+// "ollama=60,deepseek=10" in seconds.
+func parseBudgets(raw string) (map[string]time.Duration, error) {
+	budgets := make(map[string]time.Duration)
+	for _, pair := range strings.Split(raw, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		seconds, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid latency budget %q, expected provider=seconds", pair)
+		}
+		budgets[strings.TrimSpace(name)] = time.Duration(seconds) * time.Second
+	}
+	return budgets, nil
+}
+
+// subscribeEvents wires the subsystems that react to game and hub events.
+// Synchronous subscribers run while the hub holds its locks and must not
+// call back into the hub.
+func (app *Application) subscribeEvents() {
+	events.Subscribe(app.events, "leaderboard", func(e events.ActionApplied) {
+		app.board.Submit(e.PlayerID, e.Stage, e.Clicks)
+	})
+	app.narrative.Subscribe(app.events)
+	app.metrics.Subscribe(app.events)
+	app.quests.Subscribe(app.events)
+	app.idle.Subscribe(app.events)
+	app.nudges.Subscribe(app.events)
+}
+
 func (app *Application) setupRoutes() error {
 	gin.SetMode(gin.ReleaseMode)
 	app.router = gin.New()
@@ -72,6 +320,29 @@ func (app *Application) setupRoutes() error {
 
 	app.router.GET("/health", app.healthHandler)
 	app.router.GET("/ws", gin.WrapH(http.HandlerFunc(app.hub.HandleWebSocket)))
+	app.router.GET("/nudges/policy", app.nudgePolicyHandler)
+	app.router.GET("/experiments", app.experimentHandler)
+	app.router.GET("/experiments/report", app.experimentReportHandler)
+	app.router.GET("/quests", app.questsHandler)
+	app.router.GET("/quests/players/:player_id", app.playerQuestsHandler)
+	app.router.GET("/leaderboard", app.leaderboardTopHandler)
+	app.router.GET("/leaderboard/players/:player_id", app.leaderboardAroundHandler)
+	app.router.GET("/leaderboard/windows", app.leaderboardWindowsHandler)
+	app.router.GET("/leaderboard/windows/:window/history", app.leaderboardHistoryHandler)
+
+	// Session exports, prompts and provider internals are for operators only.
+	admin := app.router.Group("/", adminMiddleware(app.cfg.AdminToken))
+	admin.GET("/sessions/:session_id", app.sessionExportHandler)
+	admin.GET("/sessions/:session_id/timeline", app.sessionTimelineHandler)
+	admin.GET("/sessions/:session_id/beats", app.sessionBeatsHandler)
+	admin.GET("/sessions/:session_id/nudges", app.sessionNudgesHandler)
+	admin.GET("/sessions/:session_id/usage", app.sessionUsageHandler)
+	admin.GET("/prompts", app.promptsHandler)
+	admin.GET("/prompts/:version", app.promptVersionHandler)
+	admin.GET("/llm/breakers", app.breakersHandler)
+	admin.GET("/llm/queue", app.analysisQueueHandler)
+	admin.GET("/llm/cache", app.cacheHandler)
+	admin.GET("/llm/usage", app.usageHandler)
 
 	return nil
 }
@@ -84,6 +355,214 @@ func (app *Application) healthHandler(c *gin.Context) {
 	})
 }
 
+func (app *Application) sessionExportHandler(c *gin.Context) {
+	data, err := app.storage.ExportSessionData(c.Param("session_id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// sessionTimelineHandler serves a session's event timeline. Supported
+// filters: types (comma separated), after (sequence number) and limit.
+func (app *Application) sessionTimelineHandler(c *gin.Context) {
+	session, exists := app.storage.GetStateManager().GetSession(c.Param("session_id"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	query := game.TimelineQuery{
+		AfterSeq: uint64(queryInt(c, "after", 0, 0, math.MaxInt)),
+		Limit:    queryInt(c, "limit", 100, 1, app.cfg.TimelineSize),
+	}
+	if types := c.Query("types"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			query.Types = append(query.Types, game.EventType(strings.TrimSpace(t)))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": session.ID,
+		"events":     session.Timeline(query),
+	})
+}
+
+func (app *Application) sessionBeatsHandler(c *gin.Context) {
+	sessionID := c.Param("session_id")
+	seen, exists := app.narrative.Seen(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "session not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"beats":      seen,
+	})
+}
+
+func (app *Application) sessionNudgesHandler(c *gin.Context) {
+	sessionID := c.Param("session_id")
+	decisions, exists := app.nudges.Decisions(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no nudge decisions for session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"decisions":  decisions,
+	})
+}
+
+func (app *Application) sessionUsageHandler(c *gin.Context) {
+	sessionID := c.Param("session_id")
+	usage, exists := app.usage.Session(sessionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no token usage for session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"usage":      usage,
+	})
+}
+
+func (app *Application) nudgePolicyHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.nudges.Policy())
+}
+
+func (app *Application) promptsHandler(c *gin.Context) {
+	type version struct {
+		Version  string    `json:"version"`
+		LoadedAt time.Time `json:"loaded_at"`
+	}
+	history := app.prompts.History()
+	versions := make([]version, len(history))
+	for i, set := range history {
+		versions[i] = version{Version: set.Version, LoadedAt: set.LoadedAt}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"current":  app.prompts.Current().Version,
+		"dir":      app.cfg.PromptDir,
+		"versions": versions,
+	})
+}
+
+func (app *Application) promptVersionHandler(c *gin.Context) {
+	set, exists := app.prompts.Version(c.Param("version"))
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "prompt version not found"})
+		return
+	}
+	c.JSON(http.StatusOK, set)
+}
+
+func (app *Application) breakersHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"providers": app.chain.Breakers()})
+}
+
+func (app *Application) analysisQueueHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.analyzer.QueueStats())
+}
+
+func (app *Application) cacheHandler(c *gin.Context) {
+	if app.cache == nil {
+		c.JSON(http.StatusOK, gin.H{"enabled": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"enabled": true, "stats": app.cache.Stats()})
+}
+
+func (app *Application) usageHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.usage.Report(time.Now()))
+}
+
+func (app *Application) experimentHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.exp)
+}
+
+func (app *Application) experimentReportHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.metrics.Report(time.Now()))
+}
+
+func (app *Application) questsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"quests": app.quests.Quests()})
+}
+
+func (app *Application) playerQuestsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, app.quests.PlayerStatus(c.Param("player_id")))
+}
+
+func (app *Application) leaderboardTopHandler(c *gin.Context) {
+	window := c.DefaultQuery("window", leaderboard.AllTimeWindow)
+	limit := queryInt(c, "limit", 10, 1, app.cfg.LeaderboardSnapshotSize)
+
+	snapshot, ok := app.board.Top(window, limit)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown leaderboard window"})
+		return
+	}
+	c.JSON(http.StatusOK, snapshot)
+}
+
+func (app *Application) leaderboardAroundHandler(c *gin.Context) {
+	window := c.DefaultQuery("window", leaderboard.AllTimeWindow)
+	playerID := c.Param("player_id")
+	radius := queryInt(c, "radius", 5, 0, 50)
+
+	entries, ok := app.board.Around(window, playerID, radius)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "player not ranked"})
+		return
+	}
+
+	entry, _ := app.board.GetEntry(window, playerID)
+	c.JSON(http.StatusOK, gin.H{
+		"window":  window,
+		"player":  entry,
+		"entries": entries,
+	})
+}
+
+func (app *Application) leaderboardWindowsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"windows": app.board.Windows()})
+}
+
+func (app *Application) leaderboardHistoryHandler(c *gin.Context) {
+	window := c.Param("window")
+	limit := queryInt(c, "limit", 10, 1, app.cfg.LeaderboardArchiveSize)
+
+	history, ok := app.board.History(window, limit)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "unknown leaderboard window"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"window":  window,
+		"history": history,
+	})
+}
+
+// queryInt reads an integer query parameter, falling back to def when it is
+// missing or malformed and clamping it to [min, max].
+func queryInt(c *gin.Context, key string, def, min, max int) int {
+	value, err := strconv.Atoi(c.DefaultQuery(key, strconv.Itoa(def)))
+	if err != nil {
+		value = def
+	}
+	if value < min {
+		value = min
+	}
+	if value > max {
+		value = max
+	}
+	return value
+}
+
 func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
@@ -99,6 +578,26 @@ func corsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// adminMiddleware requires "Authorization: Bearer <token>". Without a
+// configured token the admin endpoints are disabled.
+func adminMiddleware(token string) gin.HandlerFunc {
+	if token == "" {
+		log.Println("Warning: ADMIN_TOKEN is not set, admin endpoints are disabled")
+	}
+	return func(c *gin.Context) {
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "admin endpoints are disabled"})
+			return
+		}
+		presented, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(presented), []byte(token)) != 1 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid admin token"})
+			return
+		}
+		c.Next()
+	}
+}
+
 func (app *Application) Start() error {
 	app.server = &http.Server{
 		Addr:         fmt.Sprintf(":%d", app.cfg.ServerPort),
@@ -109,6 +608,12 @@ func (app *Application) Start() error {
 
 	go app.hub.Run()
 	go app.analyzer.Start()
+	app.stopWatch = make(chan struct{})
+	go app.prompts.Watch(app.cfg.PromptReloadInterval, app.stopWatch)
+	app.board.Start()
+	app.narrative.Start()
+	app.quests.Start()
+	app.idle.Start()
 
 	go func() {
 		log.Printf("Starting server on port %d", app.cfg.ServerPort)
@@ -123,6 +628,14 @@ func (app *Application) Start() error {
 	return nil
 }
 
+func (app *Application) reloadPrompts() {
+	if err := app.prompts.Reload(); err != nil {
+		log.Printf("Prompt templates not reloaded, keeping version %s: %v", app.prompts.Current().Version, err)
+		return
+	}
+	log.Printf("Prompt templates at version %s", app.prompts.Current().Version)
+}
+
 func (app *Application) Shutdown() error {
 	log.Println("Shutting down application...")
 
@@ -131,6 +644,37 @@ func (app *Application) Shutdown() error {
 		log.Println("State analyzer stopped")
 	}
 
+	if app.stopWatch != nil {
+		close(app.stopWatch)
+	}
+
+	if app.recorder != nil {
+		if err := app.recorder.Close(); err != nil {
+			log.Printf("Failed to close recording: %v", err)
+		}
+	}
+
+	if app.events != nil {
+		app.events.Stop()
+	}
+
+	if app.narrative != nil {
+		app.narrative.Stop()
+	}
+
+	if app.quests != nil {
+		app.quests.Stop()
+	}
+
+	if app.idle != nil {
+		app.idle.Stop()
+	}
+
+	if app.board != nil {
+		app.board.Stop()
+		log.Println("Leaderboard service stopped")
+	}
+
 	if app.hub != nil {
 		app.hub.CleanupInactiveSessions()
 		log.Println("WebSocket hub cleaned up")
@@ -167,8 +711,11 @@ func main() {
 	}
 
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	// SIGHUP reloads the prompt templates; anything else shuts down.
+	for sig := <-quit; sig == syscall.SIGHUP; sig = <-quit {
+		app.reloadPrompts()
+	}
 
 	if err := app.Shutdown(); err != nil {
 		log.Printf("Error during shutdown: %v", err)
@@ -0,0 +1,326 @@
+package narrative
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+)
+
+// Delivery is a beat that should be spoken to a session.
+type Delivery struct {
+	SessionID string    `json:"session_id"`
+	PlayerID  string    `json:"player_id"`
+	BeatID    string    `json:"beat_id"`
+	State     string    `json:"state"`
+	Message   string    `json:"message"`
+	Time      time.Time `json:"time"`
+}
+
+// SeenBeat records how often a session has been shown a beat.
+type SeenBeat struct {
+	BeatID  string    `json:"beat_id"`
+	Count   int       `json:"count"`
+	FirstAt time.Time `json:"first_at"`
+	LastAt  time.Time `json:"last_at"`
+}
+
+type beatProgress struct {
+	// fired counts thresholds passed, including the ones skipped because
+	// the session started beyond them.
+	fired int
+	seen  SeenBeat
+}
+
+type sessionProgress struct {
+	playerID   string
+	startedAt  time.Time
+	stage      int
+	observed   bool
+	purchases  map[string]int
+	beats      map[string]*beatProgress
+	lastBeatAt time.Time
+}
+
+// Engine plays scripted story beats. It follows sessions through the event
+// dispatcher, checks stage and purchase triggers as events arrive and
+// time-in-session triggers on a ticker, and emits at most one beat per
+// session per check so that simultaneous milestones are spread out.
+type Engine struct {
+	cfg        *config.Config
+	script     *Script
+	deliveries chan *Delivery
+	stopChan   chan struct{}
+	ticker     *time.Ticker
+	running    bool
+	mu         sync.RWMutex
+
+	sessions   map[string]*sessionProgress
+	sessionsMu sync.Mutex
+}
+
+func NewEngine(cfg *config.Config, script *Script) *Engine {
+	return &Engine{
+		cfg:        cfg,
+		script:     script,
+		deliveries: make(chan *Delivery, 256),
+		stopChan:   make(chan struct{}),
+		sessions:   make(map[string]*sessionProgress),
+	}
+}
+
+// Subscribe registers the engine's event handlers. They only touch engine
+// state, so they are safe to run synchronously under the hub's locks.
+func (e *Engine) Subscribe(d *events.Dispatcher) {
+	events.Subscribe(d, "narrative", e.onSessionCreated)
+	events.Subscribe(d, "narrative", e.onActionApplied)
+	events.Subscribe(d, "narrative", e.onPurchaseApplied)
+	events.Subscribe(d, "narrative", e.onSessionEnded)
+}
+
+func (e *Engine) Start() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.running {
+		return
+	}
+
+	e.running = true
+	e.ticker = time.NewTicker(e.cfg.NarrativeTickInterval)
+
+	go e.tickWorker()
+
+	log.Printf("Narrative engine started with %d beats", len(e.script.Beats))
+}
+
+func (e *Engine) Stop() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.running {
+		return
+	}
+
+	e.running = false
+	if e.ticker != nil {
+		e.ticker.Stop()
+	}
+	close(e.stopChan)
+
+	log.Println("Narrative engine stopped")
+}
+
+// Deliveries emits beats that are due.
+func (e *Engine) Deliveries() <-chan *Delivery {
+	return e.deliveries
+}
+
+// Seen lists the beats a session has been shown, in script order.
+func (e *Engine) Seen(sessionID string) ([]SeenBeat, bool) {
+	e.sessionsMu.Lock()
+	defer e.sessionsMu.Unlock()
+
+	progress, exists := e.sessions[sessionID]
+	if !exists {
+		return nil, false
+	}
+
+	seen := make([]SeenBeat, 0)
+	for _, beat := range e.script.Beats {
+		if bp, ok := progress.beats[beat.ID]; ok && bp.seen.Count > 0 {
+			seen = append(seen, bp.seen)
+		}
+	}
+	return seen, true
+}
+
+func (e *Engine) tickWorker() {
+	for {
+		select {
+		case <-e.ticker.C:
+			e.checkAll(time.Now())
+		case <-e.stopChan:
+			return
+		}
+	}
+}
+
+func (e *Engine) onSessionCreated(event events.SessionCreated) {
+	e.sessionsMu.Lock()
+	defer e.sessionsMu.Unlock()
+
+	e.sessions[event.SessionID] = &sessionProgress{
+		playerID:  event.PlayerID,
+		startedAt: event.Time,
+		purchases: make(map[string]int),
+		beats:     make(map[string]*beatProgress),
+	}
+}
+
+func (e *Engine) onSessionEnded(event events.SessionEnded) {
+	e.sessionsMu.Lock()
+	defer e.sessionsMu.Unlock()
+
+	delete(e.sessions, event.SessionID)
+}
+
+func (e *Engine) onActionApplied(event events.ActionApplied) {
+	e.sessionsMu.Lock()
+	defer e.sessionsMu.Unlock()
+
+	progress, exists := e.sessions[event.SessionID]
+	if !exists {
+		return
+	}
+
+	if event.Stage > progress.stage {
+		progress.stage = event.Stage
+	}
+
+	// Milestones mark crossings made during this session. A session that
+	// starts beyond a threshold, e.g. a returning player or one credited
+	// offline progress, skips it silently.
+	if !progress.observed {
+		progress.observed = true
+		e.skipPassedStages(progress)
+		return
+	}
+
+	e.check(event.SessionID, progress, event.Time)
+}
+
+func (e *Engine) onPurchaseApplied(event events.PurchaseApplied) {
+	e.sessionsMu.Lock()
+	defer e.sessionsMu.Unlock()
+
+	progress, exists := e.sessions[event.SessionID]
+	if !exists {
+		return
+	}
+
+	progress.purchases[itemKey(event.ItemID)]++
+	progress.purchases[categoryKey(event.Category)]++
+
+	e.check(event.SessionID, progress, event.Time)
+}
+
+func (e *Engine) checkAll(now time.Time) {
+	e.sessionsMu.Lock()
+	defer e.sessionsMu.Unlock()
+
+	ids := make([]string, 0, len(e.sessions))
+	for id := range e.sessions {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		e.check(id, e.sessions[id], now)
+	}
+}
+
+func (e *Engine) skipPassedStages(progress *sessionProgress) {
+	for i := range e.script.Beats {
+		beat := &e.script.Beats[i]
+		if beat.Trigger.Type != TriggerStage {
+			continue
+		}
+
+		bp := progress.beat(beat.ID)
+		for progress.stage >= beat.threshold(bp.fired) {
+			bp.fired++
+			if !beat.Repeatable {
+				break
+			}
+		}
+	}
+}
+
+// check emits the first due beat for a session. Callers hold sessionsMu.
+func (e *Engine) check(sessionID string, progress *sessionProgress, now time.Time) {
+	if !progress.lastBeatAt.IsZero() && now.Sub(progress.lastBeatAt) < e.cfg.NarrativeMinGap {
+		return
+	}
+
+	for i := range e.script.Beats {
+		beat := &e.script.Beats[i]
+		bp := progress.beat(beat.ID)
+
+		if bp.fired > 0 && !beat.Repeatable {
+			continue
+		}
+		if bp.seen.Count > 0 && now.Sub(bp.seen.LastAt) < beat.cooldown() {
+			continue
+		}
+		if progress.value(beat, now) < beat.threshold(bp.fired) {
+			continue
+		}
+
+		bp.fired++
+		if bp.seen.Count == 0 {
+			bp.seen.FirstAt = now
+		}
+		bp.seen.Count++
+		bp.seen.LastAt = now
+		progress.lastBeatAt = now
+
+		e.emit(&Delivery{
+			SessionID: sessionID,
+			PlayerID:  progress.playerID,
+			BeatID:    beat.ID,
+			State:     beat.State,
+			Message:   beat.Messages[(bp.seen.Count-1)%len(beat.Messages)],
+			Time:      now,
+		})
+		return
+	}
+}
+
+func (e *Engine) emit(delivery *Delivery) {
+	select {
+	case e.deliveries <- delivery:
+	default:
+		log.Printf("Narrative delivery channel full, dropping beat %s for session %s",
+			delivery.BeatID, delivery.SessionID)
+	}
+}
+
+func (p *sessionProgress) beat(id string) *beatProgress {
+	bp, exists := p.beats[id]
+	if !exists {
+		bp = &beatProgress{seen: SeenBeat{BeatID: id}}
+		p.beats[id] = bp
+	}
+	return bp
+}
+
+// value is the session's current measure for the beat's trigger type.
+func (p *sessionProgress) value(beat *Beat, now time.Time) int {
+	switch beat.Trigger.Type {
+	case TriggerStage:
+		if !p.observed {
+			return 0
+		}
+		return p.stage
+	case TriggerPurchase:
+		if beat.Trigger.ItemID != nil {
+			return p.purchases[itemKey(*beat.Trigger.ItemID)]
+		}
+		return p.purchases[categoryKey(beat.Trigger.Category)]
+	default:
+		return int(now.Sub(p.startedAt) / time.Second)
+	}
+}
+
+func itemKey(itemID int) string {
+	return fmt.Sprintf("item:%d", itemID)
+}
+
+func categoryKey(category string) string {
+	return "category:" + category
+}
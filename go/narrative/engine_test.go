@@ -0,0 +1,120 @@
+package narrative
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+)
+
+func testEngine(t *testing.T, beats ...Beat) *Engine {
+	t.Helper()
+	script := &Script{Beats: beats}
+	if err := script.Validate(); err != nil {
+		t.Fatalf("invalid script: %v", err)
+	}
+	return NewEngine(&config.Config{NarrativeTickInterval: time.Second}, script)
+}
+
+func drain(e *Engine) []string {
+	var ids []string
+	for {
+		select {
+		case d := <-e.Deliveries():
+			ids = append(ids, d.BeatID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestEngineStageBeatsSkipThresholdsPassedBeforeSession(t *testing.T) {
+	e := testEngine(t,
+		Beat{ID: "ten", Trigger: Trigger{Type: TriggerStage, Stage: 10}, Messages: []string{"a"}},
+		Beat{ID: "hundred", Trigger: Trigger{Type: TriggerStage, Stage: 100}, Messages: []string{"b"}},
+	)
+	start := time.Now()
+	e.onSessionCreated(events.SessionCreated{SessionID: "s1", Time: start})
+
+	e.onActionApplied(events.ActionApplied{SessionID: "s1", Stage: 50, Time: start})
+	e.onActionApplied(events.ActionApplied{SessionID: "s1", Stage: 60, Time: start.Add(time.Second)})
+	if got := drain(e); len(got) != 0 {
+		t.Fatalf("stage 10 was passed before the session, got %v", got)
+	}
+
+	e.onActionApplied(events.ActionApplied{SessionID: "s1", Stage: 120, Time: start.Add(2 * time.Second)})
+	e.onActionApplied(events.ActionApplied{SessionID: "s1", Stage: 200, Time: start.Add(3 * time.Second)})
+	if got := drain(e); len(got) != 1 || got[0] != "hundred" {
+		t.Fatalf("one-shot beat should fire once, got %v", got)
+	}
+
+	seen, ok := e.Seen("s1")
+	if !ok || len(seen) != 1 || seen[0].BeatID != "hundred" || seen[0].Count != 1 {
+		t.Fatalf("unexpected seen beats: %+v", seen)
+	}
+}
+
+func TestEngineRepeatableBeatsRespectCooldown(t *testing.T) {
+	e := testEngine(t,
+		Beat{
+			ID:              "every_minute",
+			Trigger:         Trigger{Type: TriggerTimeInSession, Seconds: 60},
+			Messages:        []string{"first", "second"},
+			Repeatable:      true,
+			CooldownSeconds: 90,
+		},
+	)
+	start := time.Now()
+	e.onSessionCreated(events.SessionCreated{SessionID: "s1", Time: start})
+
+	e.checkAll(start.Add(30 * time.Second))
+	e.checkAll(start.Add(61 * time.Second))
+	e.checkAll(start.Add(121 * time.Second))
+	e.checkAll(start.Add(152 * time.Second))
+
+	var messages []string
+	for len(e.Deliveries()) > 0 {
+		messages = append(messages, (<-e.Deliveries()).Message)
+	}
+	if len(messages) != 2 || messages[0] != "first" || messages[1] != "second" {
+		t.Fatalf("expected two deliveries split by the cooldown, got %v", messages)
+	}
+}
+
+func TestEnginePurchaseCountAndSessionEnd(t *testing.T) {
+	item := 2
+	e := testEngine(t,
+		Beat{ID: "memes", Trigger: Trigger{Type: TriggerPurchase, Category: "abstract_meme", Count: 2}, Messages: []string{"a"}},
+		Beat{ID: "item_two", Trigger: Trigger{Type: TriggerPurchase, ItemID: &item}, Messages: []string{"b"}},
+	)
+	e.cfg.NarrativeMinGap = time.Minute
+	start := time.Now()
+	e.onSessionCreated(events.SessionCreated{SessionID: "s1", Time: start})
+
+	e.onPurchaseApplied(events.PurchaseApplied{SessionID: "s1", ItemID: 2, Category: "abstract_meme", Time: start})
+	e.onPurchaseApplied(events.PurchaseApplied{SessionID: "s1", ItemID: 5, Category: "abstract_meme", Time: start.Add(time.Second)})
+	if got := drain(e); len(got) != 1 || got[0] != "item_two" {
+		t.Fatalf("min gap should hold back the second beat, got %v", got)
+	}
+
+	e.checkAll(start.Add(2 * time.Minute))
+	if got := drain(e); len(got) != 1 || got[0] != "memes" {
+		t.Fatalf("held beat should fire after the gap, got %v", got)
+	}
+
+	e.onSessionEnded(events.SessionEnded{SessionID: "s1"})
+	if _, ok := e.Seen("s1"); ok {
+		t.Fatal("ended sessions should be forgotten")
+	}
+}
+
+func TestLoadDefaultScript(t *testing.T) {
+	script, err := LoadScript("")
+	if err != nil {
+		t.Fatalf("default script failed to load: %v", err)
+	}
+	if len(script.Beats) == 0 {
+		t.Fatal("default script has no beats")
+	}
+}
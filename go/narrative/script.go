@@ -0,0 +1,134 @@
+package narrative
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+//go:embed default_script.json
+var defaultScript []byte
+
+type TriggerType string
+
+const (
+	TriggerStage         TriggerType = "stage"
+	TriggerPurchase      TriggerType = "purchase"
+	TriggerTimeInSession TriggerType = "time_in_session"
+)
+
+// Trigger describes when a beat fires. Stage triggers fire once the stage
+// reaches Stage (and every Every stages after that when repeatable);
+// purchase triggers count purchases matching ItemID or Category; time
+// triggers fire after Seconds in the session.
+type Trigger struct {
+	Type     TriggerType `json:"type"`
+	Stage    int         `json:"stage,omitempty"`
+	Every    int         `json:"every,omitempty"`
+	ItemID   *int        `json:"item_id,omitempty"`
+	Category string      `json:"category,omitempty"`
+	Count    int         `json:"count,omitempty"`
+	Seconds  int         `json:"seconds,omitempty"`
+}
+
+type Beat struct {
+	ID              string   `json:"id"`
+	Trigger         Trigger  `json:"trigger"`
+	State           string   `json:"state"`
+	Messages        []string `json:"messages"`
+	Repeatable      bool     `json:"repeatable"`
+	CooldownSeconds int      `json:"cooldown_seconds"`
+}
+
+func (b *Beat) cooldown() time.Duration {
+	return time.Duration(b.CooldownSeconds) * time.Second
+}
+
+// threshold is the trigger value the beat needs for its next firing.
+func (b *Beat) threshold(fired int) int {
+	switch b.Trigger.Type {
+	case TriggerStage:
+		step := b.Trigger.Every
+		if step <= 0 {
+			step = b.Trigger.Stage
+		}
+		return b.Trigger.Stage + fired*step
+	case TriggerPurchase:
+		count := b.Trigger.Count
+		if count <= 0 {
+			count = 1
+		}
+		return count * (fired + 1)
+	default:
+		return b.Trigger.Seconds * (fired + 1)
+	}
+}
+
+type Script struct {
+	Beats []Beat `json:"beats"`
+}
+
+// LoadScript reads a milestone script from path, or the built-in script
+// when path is empty.
+func LoadScript(path string) (*Script, error) {
+	data := defaultScript
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read narrative script: %w", err)
+		}
+		data = raw
+	}
+
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("failed to parse narrative script: %w", err)
+	}
+	if err := script.Validate(); err != nil {
+		return nil, err
+	}
+	return &script, nil
+}
+
+func (s *Script) Validate() error {
+	seen := make(map[string]bool)
+	for i, beat := range s.Beats {
+		if beat.ID == "" {
+			return fmt.Errorf("beat %d has no id", i)
+		}
+		if seen[beat.ID] {
+			return fmt.Errorf("duplicate beat id %s", beat.ID)
+		}
+		seen[beat.ID] = true
+
+		if len(beat.Messages) == 0 {
+			return fmt.Errorf("beat %s has no messages", beat.ID)
+		}
+		for _, message := range beat.Messages {
+			if message == "" || utf8.RuneCountInString(message) > 200 {
+				return fmt.Errorf("beat %s has an empty or oversized message", beat.ID)
+			}
+		}
+
+		switch beat.Trigger.Type {
+		case TriggerStage:
+			if beat.Trigger.Stage <= 0 {
+				return fmt.Errorf("beat %s needs a positive stage", beat.ID)
+			}
+		case TriggerPurchase:
+			if beat.Trigger.ItemID == nil && beat.Trigger.Category == "" {
+				return fmt.Errorf("beat %s needs an item_id or category", beat.ID)
+			}
+		case TriggerTimeInSession:
+			if beat.Trigger.Seconds <= 0 {
+				return fmt.Errorf("beat %s needs positive seconds", beat.ID)
+			}
+		default:
+			return fmt.Errorf("beat %s has unknown trigger type %q", beat.ID, beat.Trigger.Type)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package nudge
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+// decisionHistorySize is how many decisions are kept per session.
+const decisionHistorySize = 20
+
+// Decision is the outcome of one nudge check. Only decisions for which a
+// rule's condition matched are made; Nudge tells whether it fired and
+// Reason why not.
+type Decision struct {
+	SessionID   string    `json:"session_id"`
+	RuleID      string    `json:"rule_id"`
+	Nudge       bool      `json:"nudge"`
+	Mode        Mode      `json:"mode,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Instruction string    `json:"-"`
+	Roll        float64   `json:"roll,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	Time        time.Time `json:"time"`
+}
+
+type sessionNudges struct {
+	count     int
+	lastAt    time.Time
+	ruleLast  map[string]time.Time
+	decisions []Decision
+}
+
+// Engine applies a nudge policy per session. Randomness only enters through
+// the probability roll, so a policy can be tested with a fixed roll.
+type Engine struct {
+	cfg    *config.Config
+	policy *Policy
+	roll   func() float64
+
+	sessions map[string]*sessionNudges
+	mu       sync.Mutex
+}
+
+func NewEngine(cfg *config.Config, policy *Policy) *Engine {
+	return &Engine{
+		cfg:      cfg,
+		policy:   policy,
+		roll:     rand.New(rand.NewSource(time.Now().UnixNano())).Float64,
+		sessions: make(map[string]*sessionNudges),
+	}
+}
+
+// Subscribe registers the engine's event handlers.
+func (e *Engine) Subscribe(d *events.Dispatcher) {
+	events.Subscribe(d, "nudge", e.onSessionEnded)
+}
+
+func (e *Engine) Policy() *Policy {
+	return e.policy
+}
+
+// Decide checks the policy against a session's state. It returns nil when
+// nudging is disabled or no rule applies; every other decision is logged and
+// kept in the session's history.
+func (e *Engine) Decide(sessionID string, state *game.UserState, now time.Time) *Decision {
+	if !e.cfg.NudgeEnabled || state == nil {
+		return nil
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var rule *Rule
+	for i := range e.policy.Rules {
+		if ok, _ := e.policy.Rules[i].When.Matches(state); ok {
+			rule = &e.policy.Rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return nil
+	}
+
+	s, exists := e.sessions[sessionID]
+	if !exists {
+		s = &sessionNudges{ruleLast: make(map[string]time.Time)}
+		e.sessions[sessionID] = s
+	}
+
+	decision := Decision{
+		SessionID: sessionID,
+		RuleID:    rule.ID,
+		Mode:      rule.Mode,
+		Category:  rule.Category,
+		Time:      now,
+	}
+	if decision.Mode == "" {
+		decision.Mode = Mode(e.cfg.NudgeDefaultMode)
+	}
+
+	switch {
+	case e.policy.MaxPerSession > 0 && s.count >= e.policy.MaxPerSession:
+		decision.Reason = fmt.Sprintf("session cap of %d reached", e.policy.MaxPerSession)
+	case !s.lastAt.IsZero() && now.Sub(s.lastAt) < seconds(e.policy.CooldownSeconds):
+		decision.Reason = "policy cooldown"
+	case !s.ruleLast[rule.ID].IsZero() && now.Sub(s.ruleLast[rule.ID]) < seconds(rule.CooldownSeconds):
+		decision.Reason = "rule cooldown"
+	default:
+		decision.Roll = e.roll()
+		if decision.Roll >= rule.Probability {
+			decision.Reason = fmt.Sprintf("roll %.2f above probability %.2f", decision.Roll, rule.Probability)
+			break
+		}
+		lines := e.policy.messages(rule.Category)
+		decision.Nudge = true
+		decision.Message = lines[int(decision.Roll*float64(len(lines))/rule.Probability)%len(lines)]
+		decision.Instruction = instruction(rule.Category, lines)
+		s.count++
+		s.lastAt = now
+		s.ruleLast[rule.ID] = now
+	}
+
+	s.decisions = append(s.decisions, decision)
+	if len(s.decisions) > decisionHistorySize {
+		s.decisions = s.decisions[len(s.decisions)-decisionHistorySize:]
+	}
+
+	if decision.Nudge {
+		log.Printf("Nudge session %s: rule %s fired (%s, roll %.2f)", sessionID, rule.ID, decision.Mode, decision.Roll)
+	} else {
+		log.Printf("Nudge session %s: rule %s held back: %s", sessionID, rule.ID, decision.Reason)
+	}
+	return &decision
+}
+
+// Decisions returns a session's recent nudge decisions, oldest first.
+func (e *Engine) Decisions(sessionID string) ([]Decision, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, exists := e.sessions[sessionID]
+	if !exists {
+		return nil, false
+	}
+	decisions := make([]Decision, len(s.decisions))
+	copy(decisions, s.decisions)
+	return decisions, true
+}
+
+func (e *Engine) onSessionEnded(ev events.SessionEnded) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	delete(e.sessions, ev.SessionID)
+}
+
+// instruction is the prompt addition for ModeInstruct.
+func instruction(category string, lines []string) string {
+	target := "something from the shop"
+	if category != "" {
+		target = fmt.Sprintf("something from the shop's %s items", strings.ReplaceAll(category, "_", " "))
+	}
+	return fmt.Sprintf(`Purchase nudge (decided by the server for this reply):
+ - Gently encourage the user to try %s, without breaking the one-sentence and no-numbers rules.
+ - Example phrases (examples only, do not repeat verbatim): "%s"`, target, strings.Join(lines, `", "`))
+}
+
+func seconds(n int) time.Duration {
+	return time.Duration(n) * time.Second
+}
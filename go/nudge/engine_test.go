@@ -0,0 +1,83 @@
+package nudge
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+func testEngine(policy *Policy, roll float64) *Engine {
+	e := NewEngine(&config.Config{NudgeEnabled: true, NudgeDefaultMode: string(ModeInstruct)}, policy)
+	e.roll = func() float64 { return roll }
+	return e
+}
+
+func TestDefaultPolicyConditions(t *testing.T) {
+	rule := Default().Rules[0]
+	cases := []struct {
+		stage, clicks int
+		want          bool
+	}{
+		{1600, 1650, true},
+		{1400, 1450, false},
+		{1600, 3000, false},
+	}
+	for _, c := range cases {
+		if ok, reason := rule.When.Matches(&game.UserState{Stage: c.stage, Clicks: c.clicks}); ok != c.want {
+			t.Fatalf("stage %d clicks %d: matched %v (%s), want %v", c.stage, c.clicks, ok, reason, c.want)
+		}
+	}
+}
+
+func TestDecideRollCooldownAndCap(t *testing.T) {
+	policy := Default()
+	policy.CooldownSeconds = 60
+	policy.MaxPerSession = 2
+	policy.Rules[0].CooldownSeconds = 0
+	state := &game.UserState{Stage: 2000, Clicks: 2000}
+	start := time.Now()
+
+	if d := testEngine(policy, 0.5).Decide("s1", state, start); d == nil || d.Nudge {
+		t.Fatalf("a roll above the probability must hold back: %+v", d)
+	}
+
+	e := testEngine(policy, 0.1)
+	if d := e.Decide("s1", &game.UserState{Stage: 10, Clicks: 10}, start); d != nil {
+		t.Fatalf("no rule should apply, got %+v", d)
+	}
+
+	d := e.Decide("s1", state, start)
+	if d == nil || !d.Nudge || d.Message == "" || d.Instruction == "" || d.Mode != ModeInstruct {
+		t.Fatalf("expected an instructed nudge, got %+v", d)
+	}
+	if d := e.Decide("s1", state, start.Add(30*time.Second)); d.Nudge || d.Reason != "policy cooldown" {
+		t.Fatalf("expected the policy cooldown, got %+v", d)
+	}
+	if d := e.Decide("s1", state, start.Add(61*time.Second)); !d.Nudge {
+		t.Fatalf("expected a second nudge, got %+v", d)
+	}
+	if d := e.Decide("s1", state, start.Add(time.Hour)); d.Nudge {
+		t.Fatalf("expected the session cap, got %+v", d)
+	}
+
+	decisions, _ := e.Decisions("s1")
+	if len(decisions) != 4 {
+		t.Fatalf("every decision should be recorded, got %d", len(decisions))
+	}
+}
+
+func TestValidateRejectsBadRules(t *testing.T) {
+	bad := []Rule{
+		{ID: "p", Probability: 1.5},
+		{ID: "m", Probability: 0.5, Mode: "shout"},
+		{ID: "s", Probability: 0.5, When: Condition{States: []string{"analyzed"}}},
+		{ID: "c", Probability: 0.5, Category: "hats"},
+	}
+	for _, r := range bad {
+		if err := (&Policy{Rules: []Rule{r}}).Validate(); err == nil {
+			t.Fatalf("rule %s should be rejected", r.ID)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package nudge
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/ahpxex/xtion-hackathon/game"
+)
+
+type Mode string
+
+const (
+	// ModeInstruct tells the LLM to work a purchase nudge into this call's
+	// message.
+	ModeInstruct Mode = "instruct"
+	// ModeInject sends a catalog nudge line next to the narrator's message.
+	ModeInject Mode = "inject"
+)
+
+// Condition describes when a rule applies. Zero values do not restrict.
+type Condition struct {
+	MinStage  int `json:"min_stage,omitempty"`
+	MinClicks int `json:"min_clicks,omitempty"`
+	// MaxStageClickGap is the largest relative difference between stage
+	// and clicks, e.g. 0.1 for "roughly equal".
+	MaxStageClickGap float64  `json:"max_stage_click_gap,omitempty"`
+	MinIdleSeconds   float64  `json:"min_idle_seconds,omitempty"`
+	States           []string `json:"states,omitempty"`
+}
+
+// Matches reports whether the user state satisfies the condition, and if
+// not, which part failed.
+func (c Condition) Matches(state *game.UserState) (bool, string) {
+	if state.Stage < c.MinStage {
+		return false, fmt.Sprintf("stage below %d", c.MinStage)
+	}
+	if state.Clicks < c.MinClicks {
+		return false, fmt.Sprintf("clicks below %d", c.MinClicks)
+	}
+	if c.MaxStageClickGap > 0 {
+		larger := math.Max(float64(state.Stage), float64(state.Clicks))
+		if larger == 0 || math.Abs(float64(state.Stage-state.Clicks))/larger > c.MaxStageClickGap {
+			return false, "stage and clicks too far apart"
+		}
+	}
+	if state.IdleSeconds < c.MinIdleSeconds {
+		return false, fmt.Sprintf("idle below %.0fs", c.MinIdleSeconds)
+	}
+	if len(c.States) > 0 {
+		for _, s := range c.States {
+			if s == state.CurrentState {
+				return true, ""
+			}
+		}
+		return false, fmt.Sprintf("state %s not targeted", state.CurrentState)
+	}
+	return true, ""
+}
+
+// Rule is one nudge opportunity. Category picks the catalog lines; an
+// empty Category uses the generic ones.
+type Rule struct {
+	ID              string    `json:"id"`
+	When            Condition `json:"when"`
+	Probability     float64   `json:"probability"`
+	CooldownSeconds int       `json:"cooldown_seconds,omitempty"`
+	Mode            Mode      `json:"mode,omitempty"`
+	Category        string    `json:"category,omitempty"`
+}
+
+// Policy is the full set of nudge rules. Rules are checked in order and the
+// first whose condition matches decides. CooldownSeconds and MaxPerSession
+// apply across all rules.
+type Policy struct {
+	Rules           []Rule              `json:"rules"`
+	CooldownSeconds int                 `json:"cooldown_seconds"`
+	MaxPerSession   int                 `json:"max_per_session"`
+	Messages        map[string][]string `json:"messages,omitempty"`
+}
+
+// genericCategory keys the catalog lines of rules without a category.
+const genericCategory = "any"
+
+var defaultMessages = map[string][]string{
+	genericCategory: {
+		"要不要试试商店里的东西？",
+		"不如买点什么让这世界动起来。",
+	},
+	"click_multiplier": {
+		"也许你的手指值得更多一点力量。",
+	},
+	"auto_clicker": {
+		"有些事情，可以交给别人去做。",
+	},
+	"abstract_meme": {
+		"商店里有些说不清的东西，也许正适合你。",
+	},
+}
+
+// Default reproduces the rule that used to live in the prompt: when stage
+// and clicks are roughly equal and both above 1500, nudge a purchase about
+// 30% of the time.
+func Default() *Policy {
+	return &Policy{
+		Rules: []Rule{
+			{
+				ID: "balanced_progress",
+				When: Condition{
+					MinStage:         1500,
+					MinClicks:        1500,
+					MaxStageClickGap: 0.1,
+				},
+				Probability:     0.3,
+				CooldownSeconds: 300,
+				Mode:            ModeInstruct,
+			},
+		},
+		CooldownSeconds: 120,
+		MaxPerSession:   3,
+	}
+}
+
+// Load reads a policy from path, or returns Default when path is empty.
+func Load(path string) (*Policy, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read nudge policy: %w", err)
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to parse nudge policy: %w", err)
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func (p *Policy) Validate() error {
+	if p.CooldownSeconds < 0 || p.MaxPerSession < 0 {
+		return fmt.Errorf("nudge policy cooldown and cap must not be negative")
+	}
+
+	seen := make(map[string]bool)
+	for i, r := range p.Rules {
+		if r.ID == "" {
+			return fmt.Errorf("nudge rule %d has no id", i)
+		}
+		if seen[r.ID] {
+			return fmt.Errorf("duplicate nudge rule %s", r.ID)
+		}
+		seen[r.ID] = true
+
+		if r.Probability < 0 || r.Probability > 1 {
+			return fmt.Errorf("nudge rule %s probability must be between 0 and 1", r.ID)
+		}
+		if r.CooldownSeconds < 0 {
+			return fmt.Errorf("nudge rule %s has a negative cooldown", r.ID)
+		}
+		switch r.Mode {
+		case "", ModeInstruct, ModeInject:
+		default:
+			return fmt.Errorf("nudge rule %s has unknown mode %s", r.ID, r.Mode)
+		}
+		if r.When.MaxStageClickGap < 0 || r.When.MinIdleSeconds < 0 {
+			return fmt.Errorf("nudge rule %s has a negative condition", r.ID)
+		}
+		for _, s := range r.When.States {
+			if !game.IsNarratorState(s) {
+				return fmt.Errorf("nudge rule %s targets unknown state %s", r.ID, s)
+			}
+		}
+		if r.Category != "" && len(p.messages(r.Category)) == 0 {
+			return fmt.Errorf("nudge rule %s has no messages for category %s", r.ID, r.Category)
+		}
+	}
+	return nil
+}
+
+// messages returns the catalog lines for a category, preferring the
+// policy's own over the built-in ones.
+func (p *Policy) messages(category string) []string {
+	if category == "" {
+		category = genericCategory
+	}
+	if lines := p.Messages[category]; len(lines) > 0 {
+		return lines
+	}
+	return defaultMessages[category]
+}
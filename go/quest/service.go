@@ -0,0 +1,434 @@
+package quest
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/leaderboard"
+)
+
+// Status is a quest together with one player's progress on it.
+type Status struct {
+	Quest
+	Current     int        `json:"current"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// Update carries quest progress for a player, either the full list on
+// connect or only the quests that just moved.
+type Update struct {
+	PlayerID string   `json:"player_id"`
+	Quests   []Status `json:"quests"`
+	Credits  int      `json:"credits"`
+}
+
+type period struct {
+	window leaderboard.Window
+	count  int
+	start  time.Time
+	end    time.Time
+	quests []Quest
+}
+
+type progress struct {
+	current     int
+	completedAt time.Time
+}
+
+// sessionTrack holds what the session-scoped objectives need.
+type sessionTrack struct {
+	playerID     string
+	lastActionAt time.Time
+	bought       map[string]bool
+}
+
+// Service generates daily and weekly quests from templates, tracks each
+// player's progress from the session event stream and pays credits into
+// the player store on completion.
+type Service struct {
+	cfg        *config.Config
+	templates  []Template
+	players    *game.PlayerStore
+	updateChan chan *Update
+	stopChan   chan struct{}
+	ticker     *time.Ticker
+	running    bool
+	mu         sync.RWMutex
+
+	periods  []*period
+	progress map[string]map[string]*progress
+	sessions map[string]*sessionTrack
+	stateMu  sync.Mutex
+}
+
+func NewService(cfg *config.Config, players *game.PlayerStore, templates []Template) (*Service, error) {
+	loc, err := time.LoadLocation(cfg.LeaderboardTimezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quest timezone: %w", err)
+	}
+
+	s := &Service{
+		cfg:        cfg,
+		templates:  templates,
+		players:    players,
+		updateChan: make(chan *Update, 256),
+		stopChan:   make(chan struct{}),
+		progress:   make(map[string]map[string]*progress),
+		sessions:   make(map[string]*sessionTrack),
+	}
+
+	if !cfg.QuestEnabled {
+		return s, nil
+	}
+
+	schedules := []struct {
+		schedule leaderboard.Schedule
+		count    int
+	}{
+		{leaderboard.ScheduleDaily, cfg.QuestDailyCount},
+		{leaderboard.ScheduleWeekly, cfg.QuestWeeklyCount},
+	}
+	for _, sc := range schedules {
+		if sc.count <= 0 {
+			continue
+		}
+		s.periods = append(s.periods, &period{
+			window: leaderboard.Window{
+				Name:      string(sc.schedule),
+				Schedule:  sc.schedule,
+				ResetHour: cfg.LeaderboardResetHour,
+				ResetDay:  time.Weekday(cfg.LeaderboardWeeklyResetDay),
+				Location:  loc,
+			},
+			count: sc.count,
+		})
+	}
+	s.rollover(time.Now())
+
+	return s, nil
+}
+
+// Subscribe registers the service's event handlers. They only touch quest
+// state and the player store, so they are safe to run under the hub's locks.
+func (s *Service) Subscribe(d *events.Dispatcher) {
+	events.Subscribe(d, "quest", s.onSessionCreated)
+	events.Subscribe(d, "quest", s.onActionApplied)
+	events.Subscribe(d, "quest", s.onPurchaseApplied)
+	events.Subscribe(d, "quest", s.onSessionEnded)
+}
+
+func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.ticker = time.NewTicker(s.cfg.QuestTickInterval)
+
+	go s.tickWorker()
+
+	log.Printf("Quest service started with %d active quests", len(s.Quests()))
+}
+
+func (s *Service) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.running {
+		return
+	}
+
+	s.running = false
+	if s.ticker != nil {
+		s.ticker.Stop()
+	}
+	close(s.stopChan)
+
+	log.Println("Quest service stopped")
+}
+
+// Updates delivers quest progress for connected players.
+func (s *Service) Updates() <-chan *Update {
+	return s.updateChan
+}
+
+// Quests lists the quests of the current periods.
+func (s *Service) Quests() []Quest {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	quests := make([]Quest, 0)
+	for _, p := range s.periods {
+		quests = append(quests, p.quests...)
+	}
+	return quests
+}
+
+// PlayerStatus returns the player's progress on every current quest and
+// their credit balance.
+func (s *Service) PlayerStatus(playerID string) *Update {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	return s.fullUpdate(playerID)
+}
+
+func (s *Service) tickWorker() {
+	for {
+		select {
+		case <-s.ticker.C:
+			s.tick(time.Now())
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+func (s *Service) tick(now time.Time) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	if s.rollover(now) {
+		for _, track := range s.sessions {
+			s.emit(s.fullUpdate(track.playerID))
+		}
+	}
+
+	// Idle streaks are checked here as well as on the next action, so a
+	// player who stays still is rewarded without having to click again.
+	for _, track := range s.sessions {
+		if track.lastActionAt.IsZero() {
+			continue
+		}
+		idle := int(now.Sub(track.lastActionAt) / time.Second)
+		s.emitChanged(track.playerID, s.advanceKind(track.playerID, KindIdle, idle, now, false))
+	}
+}
+
+// rollover regenerates the quests of every period that has ended and drops
+// progress on quests that are no longer current. Callers hold stateMu.
+func (s *Service) rollover(now time.Time) bool {
+	changed := false
+	for _, p := range s.periods {
+		if !p.end.IsZero() && now.Before(p.end) {
+			continue
+		}
+		p.start, p.end = p.window.Period(now)
+		p.quests = Generate(s.templates, p.window.Schedule, p.start, p.end, p.count)
+		changed = true
+	}
+	if !changed {
+		return false
+	}
+
+	current := make(map[string]bool)
+	for _, p := range s.periods {
+		for _, q := range p.quests {
+			current[q.ID] = true
+		}
+	}
+	for playerID, quests := range s.progress {
+		for questID := range quests {
+			if !current[questID] {
+				delete(quests, questID)
+			}
+		}
+		if len(quests) == 0 {
+			delete(s.progress, playerID)
+		}
+	}
+	return true
+}
+
+func (s *Service) onSessionCreated(e events.SessionCreated) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	s.sessions[e.SessionID] = &sessionTrack{
+		playerID: e.PlayerID,
+		bought:   make(map[string]bool),
+	}
+	s.emit(s.fullUpdate(e.PlayerID))
+}
+
+func (s *Service) onSessionEnded(e events.SessionEnded) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	delete(s.sessions, e.SessionID)
+}
+
+func (s *Service) onActionApplied(e events.ActionApplied) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	track, exists := s.sessions[e.SessionID]
+	if !exists {
+		return
+	}
+
+	var changed []Status
+	if !track.lastActionAt.IsZero() {
+		idle := int(e.Time.Sub(track.lastActionAt) / time.Second)
+		changed = append(changed, s.advanceKind(track.playerID, KindIdle, idle, e.Time, true)...)
+	}
+	track.lastActionAt = e.Time
+
+	for _, q := range s.currentQuests(KindReachStage) {
+		// Quests that forbid a category only count stages reached by hand
+		// in a session without such a purchase.
+		if q.Category != "" && (track.bought[q.Category] || e.Source == "offline") {
+			continue
+		}
+		if status := s.advance(track.playerID, q, e.Stage, e.Time, true); status != nil {
+			changed = append(changed, *status)
+		}
+	}
+
+	s.emitChanged(track.playerID, changed)
+}
+
+func (s *Service) onPurchaseApplied(e events.PurchaseApplied) {
+	s.stateMu.Lock()
+	defer s.stateMu.Unlock()
+
+	track, exists := s.sessions[e.SessionID]
+	if !exists {
+		return
+	}
+	track.bought[e.Category] = true
+
+	var changed []Status
+	for _, q := range s.currentQuests(KindPurchase) {
+		if q.Category != e.Category {
+			continue
+		}
+		current := 0
+		if p := s.progressFor(track.playerID, q.ID); p != nil {
+			current = p.current
+		}
+		if status := s.advance(track.playerID, q, current+1, e.Time, true); status != nil {
+			changed = append(changed, *status)
+		}
+	}
+
+	s.emitChanged(track.playerID, changed)
+}
+
+func (s *Service) currentQuests(kind Kind) []Quest {
+	quests := make([]Quest, 0)
+	for _, p := range s.periods {
+		for _, q := range p.quests {
+			if q.Kind == kind {
+				quests = append(quests, q)
+			}
+		}
+	}
+	return quests
+}
+
+func (s *Service) advanceKind(playerID string, kind Kind, value int, now time.Time, report bool) []Status {
+	var changed []Status
+	for _, q := range s.currentQuests(kind) {
+		if status := s.advance(playerID, q, value, now, report); status != nil {
+			changed = append(changed, *status)
+		}
+	}
+	return changed
+}
+
+// advance raises a player's progress on a quest to value and pays the reward
+// when the target is reached. It returns the new status when it should be
+// reported: always on completion, otherwise only when report is set.
+func (s *Service) advance(playerID string, q Quest, value int, now time.Time, report bool) *Status {
+	p := s.progressFor(playerID, q.ID)
+	if p == nil {
+		if s.progress[playerID] == nil {
+			s.progress[playerID] = make(map[string]*progress)
+		}
+		p = &progress{}
+		s.progress[playerID][q.ID] = p
+	}
+
+	if !p.completedAt.IsZero() || value <= p.current {
+		return nil
+	}
+
+	p.current = value
+	if p.current >= q.Target {
+		p.current = q.Target
+		p.completedAt = now
+		credits := s.players.AddCredits(playerID, q.Reward)
+		log.Printf("Player %s completed quest %s, +%d credits (%d total)", playerID, q.ID, q.Reward, credits)
+		report = true
+	}
+
+	if !report {
+		return nil
+	}
+	status := statusOf(q, p)
+	return &status
+}
+
+func (s *Service) progressFor(playerID, questID string) *progress {
+	if quests, exists := s.progress[playerID]; exists {
+		return quests[questID]
+	}
+	return nil
+}
+
+func (s *Service) fullUpdate(playerID string) *Update {
+	update := &Update{
+		PlayerID: playerID,
+		Quests:   make([]Status, 0),
+		Credits:  s.players.Credits(playerID),
+	}
+	for _, p := range s.periods {
+		for _, q := range p.quests {
+			update.Quests = append(update.Quests, statusOf(q, s.progressFor(playerID, q.ID)))
+		}
+	}
+	return update
+}
+
+func (s *Service) emitChanged(playerID string, changed []Status) {
+	if len(changed) == 0 {
+		return
+	}
+	s.emit(&Update{
+		PlayerID: playerID,
+		Quests:   changed,
+		Credits:  s.players.Credits(playerID),
+	})
+}
+
+func (s *Service) emit(update *Update) {
+	select {
+	case s.updateChan <- update:
+	default:
+		log.Printf("Quest update channel full, dropping update for player %s", update.PlayerID)
+	}
+}
+
+func statusOf(q Quest, p *progress) Status {
+	status := Status{Quest: q}
+	if p == nil {
+		return status
+	}
+	status.Current = p.current
+	if !p.completedAt.IsZero() {
+		completedAt := p.completedAt
+		status.Completed = true
+		status.CompletedAt = &completedAt
+	}
+	return status
+}
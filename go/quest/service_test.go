@@ -0,0 +1,113 @@
+package quest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/events"
+	"github.com/ahpxex/xtion-hackathon/game"
+	"github.com/ahpxex/xtion-hackathon/leaderboard"
+)
+
+func testService(t *testing.T, templates ...Template) (*Service, *game.PlayerStore) {
+	t.Helper()
+	players := game.NewPlayerStore()
+	s, err := NewService(&config.Config{
+		QuestEnabled:        true,
+		QuestDailyCount:     len(templates),
+		QuestTickInterval:   time.Second,
+		LeaderboardTimezone: "UTC",
+	}, players, templates)
+	if err != nil {
+		t.Fatalf("failed to create service: %v", err)
+	}
+	return s, players
+}
+
+func drain(s *Service) []*Update {
+	var updates []*Update
+	for len(s.Updates()) > 0 {
+		updates = append(updates, <-s.Updates())
+	}
+	return updates
+}
+
+func TestGenerateIsStablePerPeriod(t *testing.T) {
+	start := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 0, 1)
+
+	first := Generate(DefaultTemplates(), leaderboard.ScheduleDaily, start, end, 3)
+	again := Generate(DefaultTemplates(), leaderboard.ScheduleDaily, start, end, 3)
+	if len(first) != 3 {
+		t.Fatalf("expected 3 quests, got %d", len(first))
+	}
+	for i := range first {
+		if first[i] != again[i] {
+			t.Fatalf("quest %d differs between runs: %+v vs %+v", i, first[i], again[i])
+		}
+	}
+}
+
+func TestReachStageWithoutForbiddenCategory(t *testing.T) {
+	s, players := testService(t, Template{
+		ID: "bare_hands", Kind: KindReachStage, Title: "%d", Category: "auto_clicker",
+		Daily: &Tier{Targets: []int{500}, Reward: 50},
+	})
+	now := time.Now()
+
+	s.onSessionCreated(events.SessionCreated{SessionID: "s1", PlayerID: "p1", Time: now})
+	s.onPurchaseApplied(events.PurchaseApplied{SessionID: "s1", Category: "auto_clicker", Time: now})
+	s.onActionApplied(events.ActionApplied{SessionID: "s1", Stage: 600, Time: now})
+	if players.Credits("p1") != 0 {
+		t.Fatal("a session that bought an auto-clicker should not count")
+	}
+
+	s.onSessionEnded(events.SessionEnded{SessionID: "s1"})
+	s.onSessionCreated(events.SessionCreated{SessionID: "s2", PlayerID: "p1", Time: now})
+	drain(s)
+
+	s.onActionApplied(events.ActionApplied{SessionID: "s2", Stage: 300, Time: now})
+	s.onActionApplied(events.ActionApplied{SessionID: "s2", Stage: 500, Time: now})
+	updates := drain(s)
+	if len(updates) != 2 || !updates[1].Quests[0].Completed || updates[1].Credits != 50 {
+		t.Fatalf("unexpected updates: %+v", updates)
+	}
+	if players.Credits("p1") != 50 {
+		t.Fatalf("credits = %d, want 50", players.Credits("p1"))
+	}
+}
+
+func TestIdleAndPurchaseQuests(t *testing.T) {
+	s, players := testService(t,
+		Template{ID: "still", Kind: KindIdle, Title: "%d", Daily: &Tier{Targets: []int{60}, Reward: 30}},
+		Template{ID: "memes", Kind: KindPurchase, Title: "%d", Category: "abstract_meme", Daily: &Tier{Targets: []int{3}, Reward: 40}},
+	)
+	now := time.Now()
+	s.onSessionCreated(events.SessionCreated{SessionID: "s1", PlayerID: "p1", Time: now})
+	s.onActionApplied(events.ActionApplied{SessionID: "s1", Stage: 10, Time: now})
+
+	s.tick(now.Add(30 * time.Second))
+	if players.Credits("p1") != 0 {
+		t.Fatal("idle quest completed too early")
+	}
+	s.tick(now.Add(61 * time.Second))
+	if players.Credits("p1") != 30 {
+		t.Fatalf("idle quest should pay out while the player is still idle, credits %d", players.Credits("p1"))
+	}
+
+	for i := 0; i < 3; i++ {
+		s.onPurchaseApplied(events.PurchaseApplied{SessionID: "s1", Category: "abstract_meme", Time: now})
+	}
+	s.onPurchaseApplied(events.PurchaseApplied{SessionID: "s1", Category: "abstract_meme", Time: now})
+	if players.Credits("p1") != 70 {
+		t.Fatalf("credits = %d, want 70 with the purchase reward paid once", players.Credits("p1"))
+	}
+
+	status := s.PlayerStatus("p1")
+	for _, q := range status.Quests {
+		if !q.Completed || q.Current != q.Target {
+			t.Fatalf("expected every quest completed: %+v", q)
+		}
+	}
+}
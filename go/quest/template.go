@@ -0,0 +1,148 @@
+package quest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/leaderboard"
+)
+
+type Kind string
+
+const (
+	// KindReachStage completes when a session reaches Target stage. With a
+	// Category set, the session must not have bought anything from it.
+	KindReachStage Kind = "reach_stage"
+	// KindIdle completes after Target seconds without a user_action.
+	KindIdle Kind = "idle"
+	// KindPurchase completes after Target purchases from Category.
+	KindPurchase Kind = "purchase"
+)
+
+// Tier holds the target options and reward of a template for one schedule.
+type Tier struct {
+	Targets []int
+	Reward  int
+}
+
+// Template is the blueprint quests are generated from. Title is a format
+// string taking the target.
+type Template struct {
+	ID       string
+	Kind     Kind
+	Title    string
+	Category string
+	Daily    *Tier
+	Weekly   *Tier
+}
+
+func (t *Template) tier(schedule leaderboard.Schedule) *Tier {
+	switch schedule {
+	case leaderboard.ScheduleDaily:
+		return t.Daily
+	case leaderboard.ScheduleWeekly:
+		return t.Weekly
+	default:
+		return nil
+	}
+}
+
+// DefaultTemplates are the built-in quest blueprints.
+func DefaultTemplates() []Template {
+	return []Template{
+		{
+			ID:       "bare_hands",
+			Kind:     KindReachStage,
+			Title:    "不买自动点击器，到达第 %d 阶段",
+			Category: "auto_clicker",
+			Daily:    &Tier{Targets: []int{300, 500, 800}, Reward: 50},
+			Weekly:   &Tier{Targets: []int{1500, 2000}, Reward: 300},
+		},
+		{
+			ID:     "climber",
+			Kind:   KindReachStage,
+			Title:  "到达第 %d 阶段",
+			Daily:  &Tier{Targets: []int{1000, 1500}, Reward: 40},
+			Weekly: &Tier{Targets: []int{3000}, Reward: 200},
+		},
+		{
+			ID:     "still_life",
+			Kind:   KindIdle,
+			Title:  "什么也不做，静止 %d 秒",
+			Daily:  &Tier{Targets: []int{60, 90}, Reward: 30},
+			Weekly: &Tier{Targets: []int{300}, Reward: 150},
+		},
+		{
+			ID:       "meme_collector",
+			Kind:     KindPurchase,
+			Title:    "购买 %d 个抽象梗",
+			Category: "abstract_meme",
+			Daily:    &Tier{Targets: []int{3}, Reward: 40},
+			Weekly:   &Tier{Targets: []int{10}, Reward: 250},
+		},
+		{
+			ID:       "stronger_fingers",
+			Kind:     KindPurchase,
+			Title:    "购买 %d 个点击倍增器",
+			Category: "click_multiplier",
+			Daily:    &Tier{Targets: []int{2, 3}, Reward: 40},
+			Weekly:   &Tier{Targets: []int{8}, Reward: 200},
+		},
+	}
+}
+
+// Quest is one objective for the current period, shared by all players.
+type Quest struct {
+	ID          string               `json:"id"`
+	TemplateID  string               `json:"template_id"`
+	Schedule    leaderboard.Schedule `json:"schedule"`
+	Kind        Kind                 `json:"kind"`
+	Title       string               `json:"title"`
+	Target      int                  `json:"target"`
+	Category    string               `json:"category,omitempty"`
+	Reward      int                  `json:"reward"`
+	PeriodStart time.Time            `json:"period_start"`
+	PeriodEnd   time.Time            `json:"period_end"`
+}
+
+// Generate picks count quests for the period starting at periodStart. The
+// choice is seeded by the schedule and period, so every server instance and
+// restart produces the same quests.
+func Generate(templates []Template, schedule leaderboard.Schedule, periodStart, periodEnd time.Time, count int) []Quest {
+	eligible := make([]*Template, 0, len(templates))
+	for i := range templates {
+		if tier := templates[i].tier(schedule); tier != nil && len(tier.Targets) > 0 {
+			eligible = append(eligible, &templates[i])
+		}
+	}
+
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s/%d", schedule, periodStart.Unix())
+	rng := rand.New(rand.NewSource(int64(h.Sum64())))
+	rng.Shuffle(len(eligible), func(i, j int) { eligible[i], eligible[j] = eligible[j], eligible[i] })
+
+	if count > len(eligible) {
+		count = len(eligible)
+	}
+
+	quests := make([]Quest, 0, count)
+	for _, t := range eligible[:count] {
+		tier := t.tier(schedule)
+		target := tier.Targets[rng.Intn(len(tier.Targets))]
+		quests = append(quests, Quest{
+			ID:          fmt.Sprintf("%s-%s-%s", schedule, periodStart.Format("20060102"), t.ID),
+			TemplateID:  t.ID,
+			Schedule:    schedule,
+			Kind:        t.Kind,
+			Title:       fmt.Sprintf(t.Title, target),
+			Target:      target,
+			Category:    t.Category,
+			Reward:      tier.Reward,
+			PeriodStart: periodStart,
+			PeriodEnd:   periodEnd,
+		})
+	}
+	return quests
+}
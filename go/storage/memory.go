@@ -12,15 +12,21 @@ import (
 type MemoryStore struct {
 	sessions      map[string]*game.SessionData
 	stateManager  *game.StateManager
+	players       *game.PlayerStore
+	historySize   int
+	timelineSize  int
 	mu            sync.RWMutex
 	cleanupTicker *time.Ticker
 	stopCleanup   chan struct{}
 }
 
-func NewMemoryStore(historySize int) *MemoryStore {
+func NewMemoryStore(historySize, timelineSize int) *MemoryStore {
 	ms := &MemoryStore{
 		sessions:     make(map[string]*game.SessionData),
-		stateManager: game.NewStateManager(historySize),
+		stateManager: game.NewStateManager(historySize, timelineSize),
+		historySize:  historySize,
+		timelineSize: timelineSize,
+		players:      game.NewPlayerStore(),
 		stopCleanup:  make(chan struct{}),
 	}
 
@@ -32,6 +38,10 @@ func (ms *MemoryStore) GetStateManager() *game.StateManager {
 	return ms.stateManager
 }
 
+func (ms *MemoryStore) GetPlayerStore() *game.PlayerStore {
+	return ms.players
+}
+
 func (ms *MemoryStore) CreateSession(sessionID string) *game.SessionData {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
@@ -51,11 +61,11 @@ func (ms *MemoryStore) GetSession(sessionID string) (*game.SessionData, bool) {
 	return session, exists
 }
 
-func (ms *MemoryStore) UpdateSessionState(sessionID string, stage, clicks int) (*game.SessionData, error) {
+func (ms *MemoryStore) UpdateSessionState(sessionID string, stage, clicks int, clientTime time.Time) (*game.SessionData, error) {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	session, err := ms.stateManager.UpdateSessionState(sessionID, stage, clicks)
+	session, err := ms.stateManager.UpdateSessionState(sessionID, stage, clicks, clientTime)
 	if err != nil {
 		return nil, err
 	}
@@ -151,28 +161,37 @@ func (ms *MemoryStore) ExportSessionData(sessionID string) (map[string]interface
 	defer ms.mu.RUnlock()
 
 	session, exists := ms.sessions[sessionID]
+	if !exists {
+		// Sessions created by the hub live only in the state manager.
+		session, exists = ms.stateManager.GetSession(sessionID)
+	}
 	if !exists {
 		return nil, fmt.Errorf("session %s not found", sessionID)
 	}
 
+	summary := session.Summary()
 	userState := session.GetUserState()
 
 	data := map[string]interface{}{
-		"session_id":      session.ID,
-		"current_state":   session.CurrentState,
-		"last_analysis":   session.LastAnalysis,
-		"created_at":      session.CreatedAt,
-		"last_activity":   session.LastActivity,
-		"stage_history":   session.StageHistory,
-		"clicks_history":  session.ClicksHistory,
-		"item_purchases":  session.ItemPurchases,
-		"llm_responses":   session.LLMResponses,
-		"total_purchases": len(session.ItemPurchases),
-		"total_responses": len(session.LLMResponses),
-		"current_stage":   userState.Stage,
-		"current_clicks":  userState.Clicks,
-		"engagement_rate": userState.EngagementRate,
-		"is_active":       session.IsActive(5 * time.Minute),
+		"session_id":        summary.ID,
+		"current_state":     summary.CurrentState,
+		"variant":           session.Variant(),
+		"last_analysis":     summary.LastAnalysis,
+		"created_at":        summary.CreatedAt,
+		"last_activity":     summary.LastActivity,
+		"state_history":     session.StateHistory(),
+		"timeline":          session.Timeline(game.TimelineQuery{}),
+		"recent_actions":    session.GetRecentActions(ms.historySize),
+		"total_purchases":   session.PurchaseCount(),
+		"total_responses":   session.NarratorMessageCount(),
+		"current_stage":     userState.Stage,
+		"current_clicks":    userState.Clicks,
+		"engagement_rate":   userState.EngagementRate,
+		"clicks_per_second": userState.ClicksPerSecond,
+		"stage_per_minute":  userState.StagePerMinute,
+		"idle_seconds":      userState.IdleSeconds,
+		"prestige":          session.Prestige(),
+		"is_active":         session.IsActive(5 * time.Minute),
 	}
 
 	return data, nil
@@ -212,7 +231,9 @@ func (ms *MemoryStore) ClearAll() {
 
 	sessionCount := len(ms.sessions)
 	ms.sessions = make(map[string]*game.SessionData)
-	ms.stateManager = game.NewStateManager(10)
+	rules := ms.stateManager.MachineRules()
+	ms.stateManager = game.NewStateManager(ms.historySize, ms.timelineSize)
+	ms.stateManager.SetMachineRules(rules)
 
 	log.Printf("Cleared all %d sessions from memory store", sessionCount)
 }
@@ -5,6 +5,7 @@ import (
 	"log"
 	"time"
 
+	"github.com/ahpxex/xtion-hackathon/experiment"
 	"github.com/ahpxex/xtion-hackathon/game"
 	"github.com/gorilla/websocket"
 )
@@ -14,6 +15,9 @@ const (
 	pongWait       = 60 * time.Second
 	pingPeriod     = (pongWait * 9) / 10
 	maxMessageSize = 512
+	// messagePacing keeps narrator lines and other frames apart; stream
+	// frames are not paced, so a message types out as it is generated.
+	messagePacing = 1 * time.Second
 )
 
 type Client struct {
@@ -21,6 +25,8 @@ type Client struct {
 	conn        *websocket.Conn
 	send        chan interface{}
 	sessionID   string
+	playerID    string
+	variant     *experiment.Variant
 	sessionData *game.SessionData
 	closed      bool
 	closeChan   chan struct{}
@@ -59,7 +65,7 @@ func (c *Client) readPump() {
 			break
 		}
 
-		msg, err := c.hub.messageHandler.ParseMessage(messageBytes)
+		msg, err := c.hub.messageHandler.ParseMessage(messageBytes, c.hub.progressLimits(c))
 		if err != nil {
 			log.Printf("Message parsing error for client %s: %v", c.sessionID, err)
 			continue
@@ -89,7 +95,9 @@ func (c *Client) writePump() {
 				log.Printf("Write error for client %s: %v", c.sessionID, err)
 				return
 			}
-			time.Sleep(1 * time.Second)
+			if paced(message) {
+				time.Sleep(messagePacing)
+			}
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
@@ -104,6 +112,18 @@ func (c *Client) writePump() {
 	}
 }
 
+func paced(message interface{}) bool {
+	frame, ok := message.(map[string]interface{})
+	if !ok {
+		return true
+	}
+	switch frame["type"] {
+	case "narrator_delta", "narrator_done":
+		return false
+	}
+	return true
+}
+
 func (c *Client) writeJSON(v interface{}) error {
 	if c.closed {
 		return websocket.ErrCloseSent
@@ -148,6 +168,17 @@ func (c *Client) GetSessionID() string {
 	return c.sessionID
 }
 
+func (c *Client) GetPlayerID() string {
+	return c.playerID
+}
+
+func (c *Client) GetVariant() string {
+	if c.variant == nil {
+		return ""
+	}
+	return c.variant.Name
+}
+
 func (c *Client) GetSessionData() *game.SessionData {
 	return c.sessionData
 }
@@ -177,7 +208,7 @@ func (c *Client) SendResponse(state, message string) error {
 
 func (c *Client) UpdateLastActivity() {
 	if c.sessionData != nil {
-		c.sessionData.UpdateCurrentState(c.sessionData.CurrentState)
+		c.sessionData.Touch()
 	}
 }
 
@@ -191,6 +222,8 @@ func (c *Client) IsActive(timeout time.Duration) bool {
 func (c *Client) GetConnectionInfo() map[string]interface{} {
 	info := map[string]interface{}{
 		"session_id":    c.sessionID,
+		"player_id":     c.playerID,
+		"variant":       c.GetVariant(),
 		"connected_at":  time.Now().Format(time.RFC3339),
 		"is_active":     !c.closed,
 		"last_activity": time.Now().Format(time.RFC3339),
@@ -202,8 +235,8 @@ func (c *Client) GetConnectionInfo() map[string]interface{} {
 		info["stage"] = userState.Stage
 		info["clicks"] = userState.Clicks
 		info["engagement_rate"] = userState.EngagementRate
-		info["messages_count"] = len(c.sessionData.LLMResponses)
-		info["purchases_count"] = len(c.sessionData.ItemPurchases)
+		info["messages_count"] = c.sessionData.NarratorMessageCount()
+		info["purchases_count"] = c.sessionData.PurchaseCount()
 	}
 
 	return info
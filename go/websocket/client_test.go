@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahpxex/xtion-hackathon/config"
+	"github.com/ahpxex/xtion-hackathon/llm"
+	"github.com/gorilla/websocket"
+)
+
+func TestWritePumpDoesNotPaceDeltas(t *testing.T) {
+	const deltas = 20
+	handler := NewMessageHandler(&config.Config{})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %v", err)
+			return
+		}
+		client := NewClient(conn, "s1", nil)
+		for i := 0; i < deltas; i++ {
+			client.SendMessage(handler.CreateNarratorDelta(&llm.StreamDelta{SessionID: "s1", StreamID: "st1", Seq: i, Text: "字"}))
+		}
+		client.SendMessage(handler.CreateNarratorDone("st1", "productive", "字", true))
+		go client.writePump()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(2 * time.Second))
+	for i := 0; i <= deltas; i++ {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("frame %d not received: %v", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("%d deltas took %s to arrive", deltas, elapsed)
+	}
+}
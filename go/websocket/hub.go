@@ -1,14 +1,23 @@
 package websocket
 
 import (
+    "fmt"
     "log"
     "net/http"
+    "regexp"
     "sync"
     "time"
 
     "github.com/ahpxex/xtion-hackathon/config"
+    "github.com/ahpxex/xtion-hackathon/events"
+    "github.com/ahpxex/xtion-hackathon/experiment"
     "github.com/ahpxex/xtion-hackathon/game"
+    "github.com/ahpxex/xtion-hackathon/idle"
+    "github.com/ahpxex/xtion-hackathon/leaderboard"
     "github.com/ahpxex/xtion-hackathon/llm"
+    "github.com/ahpxex/xtion-hackathon/narrative"
+    "github.com/ahpxex/xtion-hackathon/nudge"
+    "github.com/ahpxex/xtion-hackathon/quest"
     "github.com/gorilla/websocket"
 )
 
@@ -20,25 +29,45 @@ var upgrader = websocket.Upgrader{
 	WriteBufferSize: 1024,
 }
 
+var playerIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
 type Hub struct {
 	clients        map[*Client]bool
 	register       chan *Client
 	unregister     chan *Client
 	messageHandler *MessageHandler
 	stateManager   *game.StateManager
+	players        *game.PlayerStore
 	analyzer       *llm.StateAnalyzer
+	leaderboard    *leaderboard.Service
+	dispatcher     *events.Dispatcher
+	narrative      *narrative.Engine
+	experiment     *experiment.Experiment
+	quests         *quest.Service
+	idle           *idle.Watcher
+	responses      *game.ResponseSystem
+	tokens         *PlayerTokens
 	cfg            *config.Config
 	mu             sync.RWMutex
 }
 
-func NewHub(cfg *config.Config, stateManager *game.StateManager, analyzer *llm.StateAnalyzer) *Hub {
+func NewHub(cfg *config.Config, stateManager *game.StateManager, players *game.PlayerStore, analyzer *llm.StateAnalyzer, board *leaderboard.Service, dispatcher *events.Dispatcher, story *narrative.Engine, exp *experiment.Experiment, quests *quest.Service, watcher *idle.Watcher) *Hub {
 	return &Hub{
 		clients:        make(map[*Client]bool),
 		register:       make(chan *Client),
 		unregister:     make(chan *Client),
 		messageHandler: NewMessageHandler(cfg),
 		stateManager:   stateManager,
+		players:        players,
 		analyzer:       analyzer,
+		leaderboard:    board,
+		dispatcher:     dispatcher,
+		narrative:      story,
+		experiment:     exp,
+		quests:         quests,
+		idle:           watcher,
+		responses:      game.NewResponseSystem(),
+		tokens:         NewPlayerTokens(cfg.PlayerTokenSecret),
 		cfg:            cfg,
 	}
 }
@@ -57,6 +86,21 @@ func (h *Hub) Run() {
 
 		case result := <-h.analyzer.GetResults():
 			h.handleAnalysisResult(result)
+
+		case delta := <-h.analyzer.Deltas():
+			h.handleDelta(delta)
+
+		case change := <-h.leaderboard.Updates():
+			h.handleRankChange(change)
+
+		case beat := <-h.narrative.Deliveries():
+			h.handleBeat(beat)
+
+		case update := <-h.quests.Updates():
+			h.handleQuestUpdate(update)
+
+		case nudge := <-h.idle.Nudges():
+			h.handleIdle(nudge)
 		}
 	}
 }
@@ -70,6 +114,10 @@ func (h *Hub) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	sessionID := generateSessionID()
 	client := NewClient(conn, sessionID, h)
+	playerID, token, issued := h.tokens.resolve(r)
+	client.playerID = playerID
+	client.variant = h.experiment.Assign(client.playerID)
+	h.sendMessage(client, h.messageHandler.CreatePlayerIdentity(playerID, token, issued))
 
 	// Register the client first so session exists before any messages are processed
 	h.registerClient(client)
@@ -86,7 +134,25 @@ func (h *Hub) registerClient(client *Client) {
 	h.clients[client] = true
 
 	session := h.stateManager.CreateSession(client.sessionID)
+	session.SetVariant(client.GetVariant())
+	if record, exists := h.players.Get(client.playerID); exists {
+		session.SetPrestige(record.Prestige)
+	}
+	session.RecordConnect()
 	client.sessionData = session
+
+	h.leaderboard.Track(client.playerID)
+	h.dispatcher.Publish(events.SessionCreated{
+		SessionID: client.sessionID,
+		PlayerID:  client.playerID,
+		Variant:   client.GetVariant(),
+		Time:      time.Now(),
+	})
+	h.restoreOfflineProgress(client, session)
+
+	if prestige := session.Prestige(); h.cfg.PrestigeEnabled && prestige.Count > 0 {
+		h.sendMessage(client, h.messageHandler.CreatePrestige(h.prestigeRules().Status(prestige, 0)))
+	}
 }
 
 func (h *Hub) unregisterClient(client *Client) {
@@ -97,51 +163,172 @@ func (h *Hub) unregisterClient(client *Client) {
 		delete(h.clients, client)
 		close(client.send)
 
+		if !h.hasClientForPlayer(client.playerID) {
+			h.leaderboard.Untrack(client.playerID)
+		}
+
 		if client.sessionID != "" {
+			if client.sessionData != nil {
+				client.sessionData.RecordDisconnect()
+				h.publishSessionEnded(client)
+			}
+			h.recordLeave(client)
+			h.analyzer.Forget(client.sessionID)
 			h.stateManager.DeleteSession(client.sessionID)
 		}
 	}
 }
 
+// restoreOfflineProgress credits a returning player for what their
+// producers earned while they were away and seeds the new session with it.
+func (h *Hub) restoreOfflineProgress(client *Client, session *game.SessionData) {
+	record, exists := h.players.Get(client.playerID)
+	if !exists {
+		return
+	}
+
+	limits := h.progressLimits(client)
+	income := h.cfg.OfflineIncomePerTick
+	if h.cfg.PrestigeEnabled && record.Prestige.Multiplier > 1 {
+		income = int(float64(income) * record.Prestige.Multiplier)
+	}
+
+	progress := game.ComputeOfflineProgress(record, time.Now(), game.OfflineRules{
+		IncomePerTick: income,
+		TickInterval:  h.cfg.OfflineTickInterval,
+		Efficiency:    h.cfg.OfflineEfficiency,
+		MaxDuration:   h.cfg.OfflineMaxDuration,
+		MinAway:       h.cfg.OfflineMinAway,
+		StageMax:      limits.StageMax,
+		ClicksMax:     limits.ClicksMax,
+	})
+	if progress == nil {
+		return
+	}
+
+	session.UpdateState(progress.Stage, progress.Clicks, time.Now())
+	h.players.MarkReturned(client.playerID, progress.Stage, progress.Clicks)
+	h.dispatcher.Publish(events.ActionApplied{
+		SessionID: client.sessionID,
+		PlayerID:  client.playerID,
+		Stage:     progress.Stage,
+		Clicks:    progress.Clicks,
+		Source:    "offline",
+		Variant:   client.GetVariant(),
+		Time:      time.Now(),
+	})
+
+	log.Printf("Player %s returned after %ds, credited %d clicks from %d producers",
+		client.playerID, progress.AwaySeconds, progress.ClicksEarned, progress.Producers)
+
+	h.sendMessage(client, h.messageHandler.CreateOfflineProgress(progress))
+
+	if h.cfg.OfflineNarratorEnabled {
+		h.deliverNarration(client, "returning", game.GetReturnResponse(progress), "offline")
+	}
+}
+
+func (h *Hub) publishSessionEnded(client *Client) {
+	session := client.sessionData
+	userState := session.GetUserState()
+	h.dispatcher.Publish(events.SessionEnded{
+		SessionID: client.sessionID,
+		PlayerID:  client.playerID,
+		Stage:     userState.Stage,
+		Clicks:    userState.Clicks,
+		Duration:  time.Since(session.CreatedAt),
+		Variant:   client.GetVariant(),
+		Time:      time.Now(),
+	})
+}
+
+// recordLeave remembers where a player stopped and what they owned so the
+// next connection can be credited for the time away.
+func (h *Hub) recordLeave(client *Client) {
+	session, exists := h.stateManager.GetSession(client.sessionID)
+	if !exists {
+		return
+	}
+
+	userState := session.GetUserState()
+	h.players.RecordLeave(client.playerID, userState.Stage, userState.Clicks, session.GetInventory(), time.Now())
+}
+
 func (h *Hub) handleClientMessage(client *Client, msg *ClientMessage) {
 	switch msg.Type {
 	case "user_action":
 		h.handleUserAction(client, msg)
 	case "purchase":
 		h.handlePurchase(client, msg)
+	case "prestige":
+		h.handlePrestige(client, msg)
 	default:
 		log.Printf("Unknown message type from client %s: %s", client.sessionID, msg.Type)
 	}
 }
 
 func (h *Hub) handleUserAction(client *Client, msg *ClientMessage) {
-    if err := h.messageHandler.ValidateUserAction(msg); err != nil {
+    if err := h.messageHandler.ValidateUserAction(msg, h.progressLimits(client)); err != nil {
         log.Printf("Validation error for client %s: %v", client.sessionID, err)
         h.sendErr(client, err)
         return
     }
 
-    session, err := h.stateManager.UpdateSessionState(client.sessionID, msg.Stage, msg.Clicks)
+    session, err := h.stateManager.UpdateSessionState(client.sessionID, msg.Stage, msg.Clicks, time.Unix(msg.Timestamp, 0))
     if err != nil {
         h.sendErr(client, err)
         return
     }
 
     client.sessionData = session
+    h.dispatcher.Publish(events.ActionApplied{
+        SessionID: client.sessionID,
+        PlayerID:  client.playerID,
+        Stage:     msg.Stage,
+        Clicks:    msg.Clicks,
+        Source:    "client",
+        Variant:   client.GetVariant(),
+        Time:      time.Now(),
+    })
 
     if h.analyzer.IsRunning() {
-        userState := session.GetUserState()
-        recentActions := session.GetRecentActions(h.cfg.HistoryWindowSize)
+        h.queueAnalysis(client, session)
+    }
+}
 
-        req := &llm.AnalysisRequest{
-            SessionID:     client.sessionID,
-            UserState:     userState,
-            RecentActions: recentActions,
-            Timestamp:     time.Now(),
-        }
+// queueAnalysis asks the analyzer to look at the session's current state.
+func (h *Hub) queueAnalysis(client *Client, session *game.SessionData) {
+	opts := h.analysisOptions(client)
+	opts.Inventory = session.GetInventory()
+	opts.History = session.RecentNarrations(h.cfg.NarratorMemorySize)
+	h.analyzer.QueueAnalysis(&llm.AnalysisRequest{
+		SessionID:     client.sessionID,
+		UserState:     session.GetUserState(),
+		RecentActions: session.GetRecentActions(h.cfg.HistoryWindowSize),
+		Options:       opts,
+		Timestamp:     time.Now(),
+	})
+}
 
-        h.analyzer.QueueAnalysis(req)
-    }
+// handleIdle reacts to a session that stopped sending actions, either by
+// having the analyzer look at it again or with a scripted line.
+func (h *Hub) handleIdle(nudge *idle.Nudge) {
+	h.mu.RLock()
+	client, exists := h.findClientBySessionID(nudge.SessionID)
+	h.mu.RUnlock()
+
+	if !exists || client.sessionData == nil {
+		return
+	}
+
+	log.Printf("Session %s idle for %s, nudge %d", nudge.SessionID, nudge.IdleFor.Round(time.Second), nudge.Count)
+
+	if h.cfg.IdleMode == "analysis" && h.analyzer.IsRunning() {
+		h.queueAnalysis(client, client.sessionData)
+		return
+	}
+
+	h.deliverNarration(client, "idle", game.GetIdleResponse(nudge.Count), "idle")
 }
 
 func (h *Hub) handlePurchase(client *Client, msg *ClientMessage) {
@@ -156,11 +343,20 @@ func (h *Hub) handlePurchase(client *Client, msg *ClientMessage) {
 		return
 	}
 
-	if client.sessionData != nil {
-		client.sessionData.AddPurchase(msg.ItemID)
+	h.dispatcher.Publish(events.PurchaseApplied{
+		SessionID: client.sessionID,
+		PlayerID:  client.playerID,
+		ItemID:    msg.ItemID,
+		Category:  game.GetItemCategory(msg.ItemID),
+		Variant:   client.GetVariant(),
+		Time:      time.Now(),
+	})
+
+	pool := game.DefaultResponsePool
+	if client.variant != nil && client.variant.ResponsePool != "" {
+		pool = client.variant.ResponsePool
 	}
-
-	response := getEncodedResponse(msg.ItemID)
+	response := h.responses.GetPoolResponse(pool, msg.ItemID)
 
 	respMsg := h.messageHandler.CreateResponse(
 		"PURCHASE_RESPONSE",
@@ -170,7 +366,55 @@ func (h *Hub) handlePurchase(client *Client, msg *ClientMessage) {
 	h.sendMessage(client, respMsg)
 }
 
+// handlePrestige trades the session's progress for prestige currency once
+// the player has reached the current stage cap.
+func (h *Hub) handlePrestige(client *Client, msg *ClientMessage) {
+	if !h.cfg.PrestigeEnabled {
+		h.sendErr(client, fmt.Errorf("prestige is disabled"))
+		return
+	}
+
+	session, exists := h.stateManager.GetSession(client.sessionID)
+	if !exists {
+		h.sendErr(client, fmt.Errorf("session %s not found", client.sessionID))
+		return
+	}
+
+	stage := session.GetUserState().Stage
+	rules := h.prestigeRules()
+	state, earned, err := h.players.Prestige(client.playerID, rules, stage, time.Now())
+	if err != nil {
+		h.sendErr(client, err)
+		return
+	}
+
+	session.StartOver(state)
+	h.dispatcher.Publish(events.PrestigeApplied{
+		SessionID: client.sessionID,
+		PlayerID:  client.playerID,
+		Stage:     stage,
+		Count:     state.Count,
+		Earned:    earned,
+		Currency:  state.Currency,
+		Variant:   client.GetVariant(),
+		Time:      time.Now(),
+	})
+
+	log.Printf("Player %s prestiged at stage %d (count %d, earned %d)", client.playerID, stage, state.Count, earned)
+
+	h.sendMessage(client, h.messageHandler.CreatePrestige(rules.Status(state, earned)))
+	message := game.GetPrestigeResponse(state.Count)
+	session.AddNarration("prestige", message, "prestige")
+	h.deliverNarration(client, "prestige", message, "prestige")
+}
+
 func (h *Hub) handleAnalysisResult(result *llm.AnalysisResult) {
+	if result.StreamID != "" {
+		// Deltas are queued before their result but arrive on another
+		// channel; send the ones still waiting before closing the stream.
+		h.flushDeltas()
+	}
+
 	h.mu.RLock()
 	client, exists := h.findClientBySessionID(result.SessionID)
 	h.mu.RUnlock()
@@ -180,31 +424,203 @@ func (h *Hub) handleAnalysisResult(result *llm.AnalysisResult) {
 		return
 	}
 
-	if result.StateChange && result.Response != nil {
-		session, exists := h.stateManager.GetSession(result.SessionID)
-		if exists {
-			currentState := result.Response.NewState
-			if currentState == "" {
-				currentState = result.PreviousState
+	spoken := false
+	if result.StreamID != "" {
+		defer func() {
+			if !spoken {
+				h.sendMessage(client, h.messageHandler.CreateNarratorDone(result.StreamID, "", "", false))
 			}
-			session.UpdateCurrentState(currentState)
-			session.AddLLMResponse(result.Response.Message)
+		}()
+	}
+
+	if result.Nudge != nil && result.Nudge.Mode == nudge.ModeInject {
+		h.deliverNarration(client, "nudge", result.Nudge.Message, "nudge")
+	}
+
+	// An instructed nudge is spoken even without a state change, since the
+	// message was written for it.
+	instructed := result.Nudge != nil && result.Nudge.Mode == nudge.ModeInstruct
+	if result.Response == nil || (!result.StateChange && !instructed) {
+		return
+	}
+
+	session, exists := h.stateManager.GetSession(result.SessionID)
+	if !exists {
+		return
+	}
+
+	// Both analyzer and heuristic states go through the session's state
+	// machine; a rejected proposal is not narrated.
+	now := time.Now()
+	state := session.GetUserState().CurrentState
+	accepted := false
+	if result.StateChange {
+		decision := session.ProposeState(game.StateProposal{
+			State:      result.Response.NewState,
+			Confidence: result.Confidence,
+			Source:     result.Source,
+		}, now)
+		if decision.Accepted {
+			accepted = true
+			state = decision.To
+			h.dispatcher.Publish(events.StateChanged{
+				SessionID: result.SessionID,
+				PlayerID:  client.playerID,
+				From:      decision.From,
+				To:        decision.To,
+				Variant:   client.GetVariant(),
+				Time:      now,
+			})
+		} else {
+			log.Printf("Session %s: %s state %s rejected: %s", result.SessionID, result.Source, decision.To, decision.Reason)
 		}
+	}
+	if !accepted && !instructed {
+		return
+	}
+	if result.Repeated {
+		// The state still applies; only the message is held back.
+		return
+	}
+
+	session.AddLLMResponse(result.Response.Message, result.PromptVersion)
+	spoken = true
+	if result.StreamID != "" {
+		h.sendMessage(client, h.messageHandler.CreateNarratorDone(result.StreamID, state, result.Response.Message, true))
+	} else {
+		h.sendMessage(client, h.messageHandler.CreateResponse(state, result.Response.Message))
+	}
+	h.publishNarration(client, state, result.Response.Message, "llm", result.PromptVersion)
+}
+
+func (h *Hub) handleDelta(delta *llm.StreamDelta) {
+	h.mu.RLock()
+	client, exists := h.findClientBySessionID(delta.SessionID)
+	h.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+	h.sendMessage(client, h.messageHandler.CreateNarratorDelta(delta))
+}
 
-		currentState := result.PreviousState
-		if result.Response.NewState != "" {
-			currentState = result.Response.NewState
+func (h *Hub) flushDeltas() {
+	for {
+		select {
+		case delta := <-h.analyzer.Deltas():
+			h.handleDelta(delta)
+		default:
+			return
 		}
+	}
+}
 
-		respMsg := h.messageHandler.CreateResponse(
-			currentState,
-			result.Response.Message,
-		)
+// handleBeat speaks a scripted story beat and records it on the session
+// timeline next to the analyzer's lines.
+func (h *Hub) handleBeat(beat *narrative.Delivery) {
+	h.mu.RLock()
+	client, exists := h.findClientBySessionID(beat.SessionID)
+	h.mu.RUnlock()
 
-		h.sendMessage(client, respMsg)
+	if !exists {
+		return
+	}
+
+	if session, exists := h.stateManager.GetSession(beat.SessionID); exists {
+		session.AddNarration(beat.State, beat.Message, "script")
+	}
+
+	log.Printf("Narrative beat %s delivered to session %s", beat.BeatID, beat.SessionID)
+	h.deliverNarration(client, beat.State, beat.Message, "script")
+}
+
+// deliverNarration sends a narrator line in the standard response frame and
+// announces it to event subscribers. Source is "llm", "offline" or "script".
+func (h *Hub) deliverNarration(client *Client, state, message, source string) {
+	h.sendMessage(client, h.messageHandler.CreateResponse(state, message))
+	h.publishNarration(client, state, message, source, "")
+}
+
+// publishNarration announces a narrator line that has been sent, with the
+// prompt template version for analyzer lines.
+func (h *Hub) publishNarration(client *Client, state, message, source, promptVersion string) {
+	h.dispatcher.Publish(events.NarratorMessageSent{
+		SessionID:     client.sessionID,
+		PlayerID:      client.playerID,
+		State:         state,
+		Message:       message,
+		Source:        source,
+		Variant:       client.GetVariant(),
+		PromptVersion: promptVersion,
+		Time:          time.Now(),
+	})
+}
+
+func (h *Hub) prestigeRules() game.PrestigeRules {
+	return game.PrestigeRules{
+		StageMax:           h.cfg.StageMaxValue,
+		ClicksMax:          h.cfg.ClicksMaxValue,
+		CapGrowth:          h.cfg.PrestigeCapGrowth,
+		StagePerPoint:      h.cfg.PrestigeStagePerPoint,
+		MultiplierPerPoint: h.cfg.PrestigeMultiplierPerPoint,
+	}
+}
+
+// progressLimits are the stage and clicks caps for a client, raised by each
+// prestige the player has made.
+func (h *Hub) progressLimits(client *Client) game.ProgressLimits {
+	count := 0
+	if h.cfg.PrestigeEnabled && client.sessionData != nil {
+		count = client.sessionData.Prestige().Count
+	}
+	return h.prestigeRules().Limits(count)
+}
+
+// analysisOptions applies the client's experiment variant to its analysis
+// requests.
+func (h *Hub) analysisOptions(client *Client) llm.AnalysisOptions {
+	if client.variant == nil {
+		return llm.AnalysisOptions{}
+	}
+	return llm.AnalysisOptions{
+		Variant:      client.variant.Name,
+		SystemPrompt: client.variant.SystemPrompt,
+		Temperature:  client.variant.Temperature,
+		Interval:     client.variant.AnalysisInterval(),
+	}
+}
+
+func (h *Hub) handleRankChange(change *leaderboard.RankChange) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		if client.playerID == change.PlayerID {
+			h.sendMessage(client, h.messageHandler.CreateRankUpdate(change))
+		}
 	}
 }
 
+func (h *Hub) handleQuestUpdate(update *quest.Update) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for client := range h.clients {
+		if client.playerID == update.PlayerID {
+			h.sendMessage(client, h.messageHandler.CreateQuestProgress(update))
+		}
+	}
+}
+
+func (h *Hub) hasClientForPlayer(playerID string) bool {
+	for client := range h.clients {
+		if client.playerID == playerID {
+			return true
+		}
+	}
+	return false
+}
+
 func (h *Hub) findClientBySessionID(sessionID string) (*Client, bool) {
 	for client := range h.clients {
 		if client.sessionID == sessionID {
@@ -264,25 +680,6 @@ func getPurchaseCategory(itemID int) string {
 	}
 }
 
-func getEncodedResponse(itemID int) string {
-    responses := map[int]string{
-        0: "你的点击倍增器……好像也没那么有意义。",
-        1: "自动点击器？看来手动已经不配了。",
-        2: "你买了一个抽象梗，虚空很满意。",
-        3: "每次点击变多了，真是深刻的成就呢。",
-        4: "给自动化再来点自动化，太元了。",
-        5: "一个数字概念，你甚至摸不到它。",
-        6: "更用力地点击，这算是进步吗？",
-        7: "机器替你点了，你还在玩吗？",
-        8: "消费像素，现代人的浪漫。",
-    }
-
-    if response, exists := responses[itemID]; exists {
-        return response
-    }
-    return "你买了点什么。恭喜？"
-}
-
 func generateSessionID() string {
 	return time.Now().Format("20060102-150405") + "-" +
 		string(rune(time.Now().UnixNano()%26+65)) +
@@ -0,0 +1,79 @@
+package websocket
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// PlayerTokens issues player IDs and the signed tokens that prove them.
+// The server picks a new player's ID and hands out "<id>.<signature>";
+// clients present the token on later connects instead of a raw ID, so no
+// one can claim another player's progress.
+type PlayerTokens struct {
+	secret []byte
+}
+
+// NewPlayerTokens signs with secret. Without one a random secret is used,
+// and tokens stop being accepted when the server restarts.
+func NewPlayerTokens(secret string) *PlayerTokens {
+	if secret != "" {
+		return &PlayerTokens{secret: []byte(secret)}
+	}
+
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		panic("failed to generate player token secret: " + err.Error())
+	}
+	log.Println("Warning: PLAYER_TOKEN_SECRET is not set, player tokens will not survive a restart")
+	return &PlayerTokens{secret: random}
+}
+
+// Issue creates a new player ID and its token.
+func (pt *PlayerTokens) Issue() (string, string) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic("failed to generate player ID: " + err.Error())
+	}
+	playerID := "p-" + hex.EncodeToString(id)
+	return playerID, pt.Token(playerID)
+}
+
+func (pt *PlayerTokens) Token(playerID string) string {
+	return playerID + "." + pt.sign(playerID)
+}
+
+// Verify returns the player ID a token was issued for.
+func (pt *PlayerTokens) Verify(token string) (string, bool) {
+	playerID, signature, ok := strings.Cut(token, ".")
+	if !ok || !playerIDPattern.MatchString(playerID) {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(pt.sign(playerID))) {
+		return "", false
+	}
+	return playerID, true
+}
+
+func (pt *PlayerTokens) sign(playerID string) string {
+	mac := hmac.New(sha256.New, pt.secret)
+	mac.Write([]byte(playerID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// resolve takes the player from the player_token query parameter. A
+// missing or invalid token gets a new player, and issued reports that the
+// client should store the returned token.
+func (pt *PlayerTokens) resolve(r *http.Request) (playerID, token string, issued bool) {
+	token = r.URL.Query().Get("player_token")
+	if playerID, ok := pt.Verify(token); ok {
+		return playerID, token, false
+	}
+	playerID, token = pt.Issue()
+	return playerID, token, true
+}
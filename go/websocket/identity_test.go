@@ -0,0 +1,35 @@
+package websocket
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPlayerTokens(t *testing.T) {
+	tokens := NewPlayerTokens("secret")
+	playerID, token := tokens.Issue()
+
+	if got, ok := tokens.Verify(token); !ok || got != playerID {
+		t.Fatalf("an issued token should verify, got %q %v", got, ok)
+	}
+	if _, ok := tokens.Verify("penguin-42"); ok {
+		t.Fatal("a raw player ID must not be accepted")
+	}
+	forged := "penguin-42." + strings.SplitN(token, ".", 2)[1]
+	if _, ok := tokens.Verify(forged); ok {
+		t.Fatal("a signature must not carry over to another player ID")
+	}
+	if _, ok := NewPlayerTokens("other").Verify(token); ok {
+		t.Fatal("tokens signed with another secret must not verify")
+	}
+
+	r := httptest.NewRequest("GET", "/ws?player_token="+token, nil)
+	if got, _, issued := tokens.resolve(r); got != playerID || issued {
+		t.Fatalf("a valid token should resume the player, got %q issued=%v", got, issued)
+	}
+	r = httptest.NewRequest("GET", "/ws?player_id="+playerID, nil)
+	if got, _, issued := tokens.resolve(r); got == playerID || !issued {
+		t.Fatal("a claimed player ID should get a new player instead")
+	}
+}
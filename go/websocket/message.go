@@ -5,6 +5,10 @@ import (
     "fmt"
     "time"
     "github.com/ahpxex/xtion-hackathon/config"
+    "github.com/ahpxex/xtion-hackathon/game"
+    "github.com/ahpxex/xtion-hackathon/leaderboard"
+    "github.com/ahpxex/xtion-hackathon/llm"
+    "github.com/ahpxex/xtion-hackathon/quest"
 )
 
 // ClientMessage 统一的客户端消息结构，根据 type 携带不同字段
@@ -38,7 +42,9 @@ func NewMessageHandler(cfg *config.Config) *MessageHandler {
     }
 }
 
-func (mh *MessageHandler) ParseMessage(data []byte) (*ClientMessage, error) {
+// ParseMessage decodes and validates a client frame. limits are the
+// player's prestige-aware stage and clicks caps.
+func (mh *MessageHandler) ParseMessage(data []byte, limits game.ProgressLimits) (*ClientMessage, error) {
     var msg ClientMessage
     if err := json.Unmarshal(data, &msg); err != nil {
         return nil, fmt.Errorf("invalid JSON format: %w", err)
@@ -46,7 +52,7 @@ func (mh *MessageHandler) ParseMessage(data []byte) (*ClientMessage, error) {
 
     switch msg.Type {
     case "user_action":
-        if err := mh.ValidateUserAction(&msg); err != nil {
+        if err := mh.ValidateUserAction(&msg, limits); err != nil {
             return nil, fmt.Errorf("user_action validation failed: %w", err)
         }
         return &msg, nil
@@ -55,6 +61,11 @@ func (mh *MessageHandler) ParseMessage(data []byte) (*ClientMessage, error) {
             return nil, fmt.Errorf("purchase validation failed: %w", err)
         }
         return &msg, nil
+    case "prestige":
+        if err := mh.ValidatePrestige(&msg); err != nil {
+            return nil, fmt.Errorf("prestige validation failed: %w", err)
+        }
+        return &msg, nil
     default:
         return nil, fmt.Errorf("unknown message type: %s", msg.Type)
     }
@@ -71,12 +82,98 @@ func (mh *MessageHandler) CreateResponse(state, message string) map[string]inter
     }
 }
 
-func (mh *MessageHandler) ValidateUserAction(msg *ClientMessage) error {
-    if msg.Stage < 0 || msg.Stage > mh.cfg.StageMaxValue {
-        return fmt.Errorf("validation failed: stage must be between 0 and %d", mh.cfg.StageMaxValue)
+// CreateNarratorDelta carries a piece of a narrator message that is still
+// being generated, for clients that type it out as it arrives.
+func (mh *MessageHandler) CreateNarratorDelta(delta *llm.StreamDelta) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "narrator_delta",
+        "timestamp": time.Now().Unix(),
+        "data": map[string]interface{}{
+            "stream_id": delta.StreamID,
+            "seq":       delta.Seq,
+            "text":      delta.Text,
+        },
+    }
+}
+
+// CreateNarratorDone closes a delta stream. When spoken, message is the
+// final sanitized text that replaces the typed-out deltas; otherwise the
+// client discards them.
+func (mh *MessageHandler) CreateNarratorDone(streamID, state, message string, spoken bool) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "narrator_done",
+        "timestamp": time.Now().Unix(),
+        "data": map[string]interface{}{
+            "stream_id": streamID,
+            "state":     state,
+            "message":   message,
+            "spoken":    spoken,
+        },
+    }
+}
+
+// CreatePlayerIdentity tells the client who it is playing as. issued is
+// set when the token is new and should replace any the client stored.
+func (mh *MessageHandler) CreatePlayerIdentity(playerID, token string, issued bool) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "player_identity",
+        "timestamp": time.Now().Unix(),
+        "data": map[string]interface{}{
+            "player_id":    playerID,
+            "player_token": token,
+            "issued":       issued,
+        },
+    }
+}
+
+func (mh *MessageHandler) CreateRankUpdate(change *leaderboard.RankChange) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "rank_update",
+        "timestamp": change.Timestamp.Unix(),
+        "data": map[string]interface{}{
+            "window":        change.Window,
+            "player_id":     change.PlayerID,
+            "rank":          change.Rank,
+            "previous_rank": change.PreviousRank,
+            "score":         change.Score,
+            "total_players": change.TotalPlayers,
+        },
+    }
+}
+
+func (mh *MessageHandler) CreateOfflineProgress(progress *game.OfflineProgress) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "offline_progress",
+        "timestamp": time.Now().Unix(),
+        "data":      progress,
+    }
+}
+
+func (mh *MessageHandler) CreateQuestProgress(update *quest.Update) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "quest_progress",
+        "timestamp": time.Now().Unix(),
+        "data": map[string]interface{}{
+            "quests":  update.Quests,
+            "credits": update.Credits,
+        },
+    }
+}
+
+func (mh *MessageHandler) CreatePrestige(status game.PrestigeStatus) map[string]interface{} {
+    return map[string]interface{}{
+        "type":      "prestige",
+        "timestamp": time.Now().Unix(),
+        "data":      status,
+    }
+}
+
+func (mh *MessageHandler) ValidateUserAction(msg *ClientMessage, limits game.ProgressLimits) error {
+    if msg.Stage < 0 || msg.Stage > limits.StageMax {
+        return fmt.Errorf("validation failed: stage must be between 0 and %d", limits.StageMax)
     }
-    if msg.Clicks < 0 || msg.Clicks > mh.cfg.ClicksMaxValue {
-        return fmt.Errorf("validation failed: clicks must be between 0 and %d", mh.cfg.ClicksMaxValue)
+    if msg.Clicks < 0 || msg.Clicks > limits.ClicksMax {
+        return fmt.Errorf("validation failed: clicks must be between 0 and %d", limits.ClicksMax)
     }
     if msg.Timestamp <= 0 {
         return fmt.Errorf("validation failed: timestamp must be a valid Unix timestamp")
@@ -93,3 +190,10 @@ func (mh *MessageHandler) ValidatePurchase(msg *ClientMessage) error {
     }
     return nil
 }
+
+func (mh *MessageHandler) ValidatePrestige(msg *ClientMessage) error {
+    if msg.Timestamp <= 0 {
+        return fmt.Errorf("validation failed: timestamp must be a valid Unix timestamp")
+    }
+    return nil
+}